@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const SessionCookie = "mkv_session"
+const CsrfField = "csrf"
+const CsrfHeader = "X-CSRF-Token"
+
+type contextKey int
+
+const sessionKey contextKey = iota
+
+// Middleware requires every request, other than the ones skipped, to have a
+// valid session cookie or bearer api token. Requests that change something and
+// are authenticated with a session must include the session's csrf token in
+// the csrf form field or X-CSRF-Token header. Unauthenticated api requests
+// get a 401, others are redirected to loginPath.
+func Middleware(store AuthStore, loginPath string, skip func(c echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip != nil && skip(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			if token, ok := bearerToken(req); ok {
+				if _, ok := store.CheckToken(token); !ok {
+					return unauthorized(c, loginPath)
+				}
+				return next(c)
+			}
+
+			cookie, err := req.Cookie(SessionCookie)
+			if err != nil {
+				return unauthorized(c, loginPath)
+			}
+			session, ok := store.GetSession(cookie.Value)
+			if !ok {
+				return unauthorized(c, loginPath)
+			}
+
+			if !isSafeMethod(req.Method) && !validCsrf(c, session) {
+				return c.String(http.StatusForbidden, "invalid csrf token")
+			}
+
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), sessionKey, session)))
+			return next(c)
+		}
+	}
+}
+
+// CsrfToken returns the csrf token of the session in ctx, or "" if there
+// isn't one.
+func CsrfToken(ctx context.Context) string {
+	if session, ok := ctx.Value(sessionKey).(*Session); ok {
+		return session.Csrf
+	}
+	return ""
+}
+
+// Username returns the user logged in with the session in ctx, or "" if there
+// isn't one.
+func Username(ctx context.Context) string {
+	if session, ok := ctx.Value(sessionKey).(*Session); ok {
+		return session.Username
+	}
+	return ""
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	header := req.Header.Get(echo.HeaderAuthorization)
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func validCsrf(c echo.Context, session *Session) bool {
+	token := c.Request().Header.Get(CsrfHeader)
+	if token == "" {
+		token = c.FormValue(CsrfField)
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.Csrf)) == 1
+}
+
+func unauthorized(c echo.Context, loginPath string) error {
+	if strings.HasPrefix(c.Request().URL.Path, "/api/") || c.Request().Header.Get("HX-Request") != "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"Error": "unauthorized"})
+	}
+	return c.Redirect(http.StatusSeeOther, loginPath)
+}
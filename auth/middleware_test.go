@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestServer(t *testing.T) (*echo.Echo, AuthStore) {
+	t.Helper()
+	store := openTestStore(t)
+	if err := store.AddUser("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(Middleware(store, "/login", func(c echo.Context) bool {
+		return c.Request().URL.Path == "/login"
+	}))
+	ok := func(c echo.Context) error {
+		return c.String(http.StatusOK, Username(c.Request().Context())+" "+CsrfToken(c.Request().Context()))
+	}
+	e.GET("/login", ok)
+	e.GET("/", ok)
+	e.POST("/edit", ok)
+	e.GET("/api/v1/drives", ok)
+	e.POST("/api/v1/eject", ok)
+	return e, store
+}
+
+func serve(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware_Unauthenticated(t *testing.T) {
+	e, _ := newTestServer(t)
+
+	rec := serve(e, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Fatalf("expected redirect to /login, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec = serve(e, httptest.NewRequest(http.MethodGet, "/api/v1/drives", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for api, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("HX-Request", "true")
+	if rec := serve(e, req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for htmx, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookie, Value: "bogus"})
+	if rec := serve(e, req); rec.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect for unknown session, got %d", rec.Code)
+	}
+
+	if rec := serve(e, httptest.NewRequest(http.MethodGet, "/login", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("expected login to be skipped, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_Session(t *testing.T) {
+	e, store := newTestServer(t)
+	session, _ := store.NewSession("alice", time.Hour)
+	cookie := &http.Cookie{Name: SessionCookie, Value: session.Id}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec := serve(e, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice "+session.Csrf {
+		t.Fatalf("expected session in context, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/edit", strings.NewReader("imdbid=tt1"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.AddCookie(cookie)
+	if rec := serve(e, req); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without csrf token, got %d", rec.Code)
+	}
+
+	form := url.Values{CsrfField: {"wrong"}}
+	req = httptest.NewRequest(http.MethodPost, "/edit", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.AddCookie(cookie)
+	if rec := serve(e, req); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 with wrong csrf token, got %d", rec.Code)
+	}
+
+	form = url.Values{CsrfField: {session.Csrf}}
+	req = httptest.NewRequest(http.MethodPost, "/edit", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.AddCookie(cookie)
+	if rec := serve(e, req); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with csrf form field, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/eject", nil)
+	req.Header.Set(CsrfHeader, session.Csrf)
+	req.AddCookie(cookie)
+	if rec := serve(e, req); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with csrf header, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_Token(t *testing.T) {
+	e, store := newTestServer(t)
+	token, _ := store.NewToken("alice", "script")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/eject", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	if rec := serve(e, req); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/drives", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer wrong")
+	if rec := serve(e, req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %d", rec.Code)
+	}
+}
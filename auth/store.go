@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUserExists = errors.New("user already exists")
+var ErrNoUser = errors.New("user not found")
+
+type Session struct {
+	Id       string
+	Username string
+	Csrf     string
+	Expires  time.Time
+}
+
+type AuthStore interface {
+	AddUser(username string, password string) error
+	SetPassword(username string, password string) error
+	HasUsers() bool
+	CheckPassword(username string, password string) bool
+	NewSession(username string, ttl time.Duration) (*Session, error)
+	GetSession(id string) (*Session, bool)
+	DeleteSession(id string) error
+	// NewToken returns a new api token, only a hash of it is stored.
+	NewToken(username string, name string) (string, error)
+	CheckToken(token string) (username string, ok bool)
+}
+
+func NewSqliteAuthStore(db *sql.DB) (AuthStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (
+		username TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		csrf TEXT NOT NULL,
+		expires INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS api_tokens (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		name TEXT,
+		created INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sqliteAuthStore{
+		db:       db,
+		users:    make(map[string]string),
+		sessions: make(map[string]*Session),
+		tokens:   make(map[string]string),
+	}
+
+	if err := s.loadUsers(); err != nil {
+		return nil, err
+	}
+	if err := s.loadSessions(); err != nil {
+		return nil, err
+	}
+	if err := s.loadTokens(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type sqliteAuthStore struct {
+	mutex    sync.RWMutex
+	db       *sql.DB
+	users    map[string]string
+	sessions map[string]*Session
+	tokens   map[string]string
+}
+
+func (s *sqliteAuthStore) loadUsers() error {
+	rows, err := s.db.Query("SELECT username, password_hash FROM users")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username, hash string
+		if err := rows.Scan(&username, &hash); err != nil {
+			log.Println("error scanning users row:", err)
+			continue
+		}
+		s.users[username] = hash
+	}
+	return nil
+}
+
+func (s *sqliteAuthStore) loadSessions() error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires < ?", time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query("SELECT id, username, csrf, expires FROM sessions")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session Session
+		var expires int64
+		if err := rows.Scan(&session.Id, &session.Username, &session.Csrf, &expires); err != nil {
+			log.Println("error scanning sessions row:", err)
+			continue
+		}
+		session.Expires = time.Unix(expires, 0)
+		s.sessions[session.Id] = &session
+	}
+	return nil
+}
+
+func (s *sqliteAuthStore) loadTokens() error {
+	rows, err := s.db.Query("SELECT token_hash, username FROM api_tokens")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash, username string
+		if err := rows.Scan(&hash, &username); err != nil {
+			log.Println("error scanning api_tokens row:", err)
+			continue
+		}
+		s.tokens[hash] = username
+	}
+	return nil
+}
+
+func (s *sqliteAuthStore) AddUser(username string, password string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[username]; ok {
+		return ErrUserExists
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", username, string(hash))
+	if err != nil {
+		return err
+	}
+	s.users[username] = string(hash)
+	return nil
+}
+
+func (s *sqliteAuthStore) SetPassword(username string, password string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[username]; !ok {
+		return ErrNoUser
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE users SET password_hash = ? WHERE username = ?", string(hash), username)
+	if err != nil {
+		return err
+	}
+	s.users[username] = string(hash)
+
+	// log out everywhere
+	_, err = s.db.Exec("DELETE FROM sessions WHERE username = ?", username)
+	for id, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, id)
+		}
+	}
+	return err
+}
+
+func (s *sqliteAuthStore) HasUsers() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.users) > 0
+}
+
+// dummyHash is compared against for unknown users, so they take as long to
+// check as known ones.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (s *sqliteAuthStore) CheckPassword(username string, password string) bool {
+	s.mutex.RLock()
+	hash, ok := s.users[username]
+	s.mutex.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (s *sqliteAuthStore) NewSession(username string, ttl time.Duration) (*Session, error) {
+	id, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Id:       id,
+		Username: username,
+		Csrf:     csrf,
+		Expires:  time.Now().Add(ttl),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = s.db.Exec(
+		"INSERT INTO sessions (id, username, csrf, expires) VALUES (?, ?, ?, ?)",
+		session.Id, session.Username, session.Csrf, session.Expires.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions[id] = session
+	return session, nil
+}
+
+func (s *sqliteAuthStore) GetSession(id string) (*Session, bool) {
+	s.mutex.RLock()
+	session, ok := s.sessions[id]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(session.Expires) {
+		s.DeleteSession(id)
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *sqliteAuthStore) DeleteSession(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+	_, err := s.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteAuthStore) NewToken(username string, name string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[username]; !ok {
+		return "", ErrNoUser
+	}
+	token, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	hash := hashToken(token)
+	_, err = s.db.Exec(
+		"INSERT INTO api_tokens (token_hash, username, name, created) VALUES (?, ?, ?, ?)",
+		hash, username, name, time.Now().Unix(),
+	)
+	if err != nil {
+		return "", err
+	}
+	s.tokens[hash] = username
+	return token, nil
+}
+
+func (s *sqliteAuthStore) CheckToken(token string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	username, ok := s.tokens[hashToken(token)]
+	return username, ok
+}
+
+// api tokens are random, so a fast hash is enough to keep them out of the db
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestStore(t *testing.T) AuthStore {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store, err := NewSqliteAuthStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSqliteAuthStore_Users(t *testing.T) {
+	store := openTestStore(t)
+
+	if store.HasUsers() {
+		t.Fatal("expected no users")
+	}
+	if err := store.AddUser("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if !store.HasUsers() {
+		t.Fatal("expected users")
+	}
+	if err := store.AddUser("alice", "other"); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("expected ErrUserExists, got %v", err)
+	}
+
+	if !store.CheckPassword("alice", "secret") {
+		t.Fatal("expected password to match")
+	}
+	if store.CheckPassword("alice", "wrong") {
+		t.Fatal("expected wrong password to fail")
+	}
+	if store.CheckPassword("bob", "secret") {
+		t.Fatal("expected unknown user to fail")
+	}
+
+	if err := store.SetPassword("bob", "secret"); !errors.Is(err, ErrNoUser) {
+		t.Fatalf("expected ErrNoUser, got %v", err)
+	}
+	if err := store.SetPassword("alice", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if store.CheckPassword("alice", "secret") {
+		t.Fatal("expected old password to fail")
+	}
+	if !store.CheckPassword("alice", "changed") {
+		t.Fatal("expected new password to match")
+	}
+}
+
+func TestSqliteAuthStore_Sessions(t *testing.T) {
+	store := openTestStore(t)
+	store.AddUser("alice", "secret")
+
+	session, err := store.NewSession("alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Id == "" || session.Csrf == "" || session.Id == session.Csrf {
+		t.Fatalf("expected distinct session id and csrf token, got %+v", session)
+	}
+
+	got, ok := store.GetSession(session.Id)
+	if !ok || got.Username != "alice" {
+		t.Fatalf("expected session for alice, got %+v", got)
+	}
+
+	if err := store.DeleteSession(session.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.GetSession(session.Id); ok {
+		t.Fatal("expected deleted session to be gone")
+	}
+
+	expired, _ := store.NewSession("alice", -time.Minute)
+	if _, ok := store.GetSession(expired.Id); ok {
+		t.Fatal("expected expired session to be rejected")
+	}
+
+	// changing the password logs out every session
+	session, _ = store.NewSession("alice", time.Hour)
+	store.SetPassword("alice", "changed")
+	if _, ok := store.GetSession(session.Id); ok {
+		t.Fatal("expected session to be removed after password change")
+	}
+}
+
+func TestSqliteAuthStore_Tokens(t *testing.T) {
+	store := openTestStore(t)
+	store.AddUser("alice", "secret")
+
+	if _, err := store.NewToken("bob", "script"); !errors.Is(err, ErrNoUser) {
+		t.Fatalf("expected ErrNoUser, got %v", err)
+	}
+
+	token, err := store.NewToken("alice", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, ok := store.CheckToken(token)
+	if !ok || username != "alice" {
+		t.Fatalf("expected token for alice, got %q %v", username, ok)
+	}
+	if _, ok := store.CheckToken("not a token"); ok {
+		t.Fatal("expected unknown token to fail")
+	}
+}
+
+func TestSqliteAuthStore_Persistence(t *testing.T) {
+	dbPath := t.TempDir() + "/auth.db"
+
+	db1, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store1, err := NewSqliteAuthStore(db1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store1.AddUser("alice", "secret")
+	session, _ := store1.NewSession("alice", time.Hour)
+	token, _ := store1.NewToken("alice", "script")
+	db1.Close()
+
+	var stored int
+	db2, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	db2.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE token_hash = ?", token).Scan(&stored)
+	if stored != 0 {
+		t.Fatal("expected token to not be stored in plain text")
+	}
+
+	store2, err := NewSqliteAuthStore(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store2.CheckPassword("alice", "secret") {
+		t.Fatal("expected user to persist")
+	}
+	if got, ok := store2.GetSession(session.Id); !ok || got.Csrf != session.Csrf {
+		t.Fatal("expected session to persist")
+	}
+	if _, ok := store2.CheckToken(token); !ok {
+		t.Fatal("expected token to persist")
+	}
+}
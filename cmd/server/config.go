@@ -1,9 +1,19 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/ingest"
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/workflow"
 	"github.com/pelletier/go-toml/v2"
 )
 
@@ -12,26 +22,145 @@ const DEFAULT_LOG_DIR = "."
 const DEFAULT_RIP_DIR = "."
 const DEFAULT_PORT = 8080
 const DEFAULT_SHAFILE = "movies.sha256"
+const DEFAULT_SESSION_DAYS = 30
+const DEFAULT_METADATA_CACHE_DAYS = 7
 
 type OmdbConfig struct {
 	Apikey string
 }
 
+// TmdbConfig takes either a v3 api key or a v4 read access token.
+type TmdbConfig struct {
+	Apikey string
+}
+
+// AuthConfig turns on logins for the web ui and api tokens for the api, users
+// are added with the useradd command.
+type AuthConfig struct {
+	Enabled      bool
+	SessionDays  int
+	SecureCookie bool
+}
+
+// NamingConfig holds text/template strings for the path of ingested files,
+// relative to the target. See ingest.NameData for the available fields.
+type NamingConfig struct {
+	Movie   string
+	Episode string
+}
+
+// TargetConfig is where ripped files are ingested to. User, Key and
+// KnownHosts are only used by sftp targets, see ingest.Target. User defaults
+// to the user running the server. Endpoint, Region, AccessKey and SecretKey
+// are only used by s3 targets, where Host is the bucket, see ingest.S3Config.
+// Password is only used by webdav targets, with User for basic auth. Verify
+// reads back files that a file target clones or links instead of copying, to
+// check their shasum.
 type TargetConfig struct {
-	Scheme string
-	Host   string
-	Path   string
+	Scheme     string
+	User       string
+	Host       string
+	Path       string
+	Naming     *NamingConfig
+	Key        string
+	KnownHosts string
+	Endpoint   string
+	Region     string
+	AccessKey  string
+	SecretKey  string
+	Password   string
+	Verify     bool
+}
+
+func (t TargetConfig) target(naming *ingest.Naming) ingest.Target {
+	u := &url.URL{
+		Scheme: t.Scheme,
+		Host:   t.Host,
+		Path:   t.Path,
+	}
+	if t.User != "" {
+		u.User = url.User(t.User)
+	}
+	return ingest.Target{
+		Url:        u,
+		Naming:     naming,
+		Key:        t.Key,
+		KnownHosts: t.KnownHosts,
+		Password:   t.Password,
+		Verify:     t.Verify,
+		S3: ingest.S3Config{
+			Endpoint:  t.Endpoint,
+			Region:    t.Region,
+			AccessKey: t.AccessKey,
+			SecretKey: t.SecretKey,
+		},
+	}
+}
+
+// BackupConfig sets up full disc backups, which are only ingested to their own
+// targets. Naming is the template for the backup folder or iso, relative to
+// the target, and defaults to ingest.DefaultBackupTemplate.
+type BackupConfig struct {
+	Iso     bool
+	Naming  string
+	Targets []TargetConfig
+}
+
+// WatchConfig adds a virtual drive for every iso and disc backup folder in
+// Dir, checked every Interval seconds. Sources are moved to Done, by default
+// a done folder in Dir, once they have been ripped.
+type WatchConfig struct {
+	Dir      string
+	Done     string
+	Interval int
+}
+
+// TranscodeConfig runs an encoder on every ripped title, before it is
+// ingested, to make a variant that is ingested to its own targets. Command is
+// the encoder and its arguments, where {input} and {output} are replaced by
+// the paths of the ripped and transcoded files. Naming defaults to
+// ingest.DefaultVariantMovieTemplate and ingest.DefaultVariantEpisodeTemplate.
+type TranscodeConfig struct {
+	Name       string
+	Command    []string
+	Ext        string
+	Resolution string
+	Naming     *NamingConfig
+	Targets    []TargetConfig
+}
+
+// ProfileConfig is a named set of makemkv options, see drive.RipProfile.
+// Minlength defaults to 3600 seconds and Decrypt, which only applies to
+// backups, to true.
+type ProfileConfig struct {
+	Minlength *int
+	Selection string
+	Profile   string
+	Decrypt   *bool
+	Cache     int
 }
 
 type Config struct {
-	Data        string
-	Log         string
-	Rip         string
-	Port        int
-	Shafile     string
-	Omdb        *OmdbConfig
-	Targets     []TargetConfig
-	UseMovieDir bool
+	Data              string
+	Log               string
+	Rip               string
+	Port              int
+	Shafile           string
+	Metadata          string
+	MetadataCacheDays int
+	Omdb              *OmdbConfig
+	Tmdb              *TmdbConfig
+	Auth              *AuthConfig
+	Targets           []TargetConfig
+	Backup            *BackupConfig
+	Watch             *WatchConfig
+	Transcode         []TranscodeConfig
+	Profile           string
+	Profiles          map[string]ProfileConfig
+	DiscProfiles      map[string]string
+	Naming            *NamingConfig
+	UseMovieDir       bool
+	AutoEject         bool
 }
 
 func ParseConfigFile(file string) Config {
@@ -47,6 +176,7 @@ func ParseConfigFile(file string) Config {
 
 func parseConfigBytes(config *Config, b []byte) {
 	config.UseMovieDir = false
+	config.AutoEject = false
 	toml.Unmarshal(b, config)
 	if config.Data == "" {
 		config.Data = DEFAULT_DATA_DIR
@@ -63,7 +193,189 @@ func parseConfigBytes(config *Config, b []byte) {
 	if config.Shafile == "" {
 		config.Shafile = DEFAULT_SHAFILE
 	}
+	if config.MetadataCacheDays <= 0 {
+		config.MetadataCacheDays = DEFAULT_METADATA_CACHE_DAYS
+	}
+	if config.Auth != nil && config.Auth.SessionDays <= 0 {
+		config.Auth.SessionDays = DEFAULT_SESSION_DAYS
+	}
 	if config.Targets == nil {
 		config.Targets = make([]TargetConfig, 0)
 	}
+	if config.Watch != nil && config.Watch.Done == "" {
+		config.Watch.Done = path.Join(config.Watch.Dir, "done")
+	}
+}
+
+// RipProfiles returns the configured rip profiles. Profile picks the default
+// one, it can be left out with only one profile or with one named default.
+// DiscProfiles picks the default for "dvd" or "bluray" discs.
+func (c Config) RipProfiles() (drive.Profiles, error) {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	def := c.Profile
+	if def == "" && len(names) > 1 {
+		if _, ok := c.Profiles[drive.DefaultProfileName]; !ok {
+			return drive.Profiles{}, fmt.Errorf("profile must be set to pick the default rip profile")
+		}
+		def = drive.DefaultProfileName
+	}
+
+	profiles := make([]drive.RipProfile, len(names))
+	for i, name := range names {
+		p := c.Profiles[name]
+		profiles[i] = drive.RipProfile{
+			Name:      name,
+			Minlength: drive.DefaultMinlength,
+			Selection: p.Selection,
+			Profile:   p.Profile,
+			Decrypt:   true,
+			Cache:     p.Cache,
+		}
+		if p.Minlength != nil {
+			profiles[i].Minlength = *p.Minlength
+		}
+		if p.Decrypt != nil {
+			profiles[i].Decrypt = *p.Decrypt
+		}
+	}
+	return drive.NewProfiles(profiles, def, c.DiscProfiles)
+}
+
+// IngestTargets returns the configured targets. Target naming templates take
+// precedence over the global ones, which fall back to the defaults.
+func (c Config) IngestTargets() ([]ingest.Target, error) {
+	targets := make([]ingest.Target, len(c.Targets))
+	for i, t := range c.Targets {
+		movie := ingest.DefaultMovieTemplate
+		if c.UseMovieDir {
+			movie = ingest.DefaultMovieDirTemplate
+		}
+		episode := ingest.DefaultEpisodeTemplate
+		for _, n := range []*NamingConfig{c.Naming, t.Naming} {
+			if n == nil {
+				continue
+			}
+			if n.Movie != "" {
+				movie = n.Movie
+			}
+			if n.Episode != "" {
+				episode = n.Episode
+			}
+		}
+
+		naming, err := ingest.NewNaming(movie, episode)
+		if err != nil {
+			return nil, fmt.Errorf("target %d: %w", i, err)
+		}
+		targets[i] = t.target(naming)
+	}
+	return targets, nil
+}
+
+// BackupTargets returns the configured backup targets. A target's movie
+// naming template takes precedence over the backup one.
+func (c Config) BackupTargets() ([]ingest.Target, error) {
+	if c.Backup == nil {
+		return nil, nil
+	}
+	targets := make([]ingest.Target, len(c.Backup.Targets))
+	for i, t := range c.Backup.Targets {
+		backup := ingest.DefaultBackupTemplate
+		if c.Backup.Naming != "" {
+			backup = c.Backup.Naming
+		}
+		if t.Naming != nil && t.Naming.Movie != "" {
+			backup = t.Naming.Movie
+		}
+
+		naming, err := ingest.NewNaming(backup, ingest.DefaultEpisodeTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("backup target %d: %w", i, err)
+		}
+		targets[i] = t.target(naming)
+	}
+	return targets, nil
+}
+
+// Encoders returns the configured transcodes. A target's naming templates take
+// precedence over the transcode ones.
+func (c Config) Encoders() ([]workflow.Encoder, error) {
+	encoders := make([]workflow.Encoder, len(c.Transcode))
+	seen := make(map[string]bool)
+	for i, tc := range c.Transcode {
+		if tc.Name == "" || strings.ContainsAny(tc.Name, "/.") {
+			return nil, fmt.Errorf("transcode %d: invalid name %q", i, tc.Name)
+		}
+		if seen[tc.Name] {
+			return nil, fmt.Errorf("transcode %s: duplicate name", tc.Name)
+		}
+		seen[tc.Name] = true
+		if len(tc.Command) == 0 || !slices.ContainsFunc(tc.Command, func(arg string) bool { return strings.Contains(arg, "{output}") }) {
+			return nil, fmt.Errorf("transcode %s: command must write to {output}", tc.Name)
+		}
+		ext := tc.Ext
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		targets := make([]ingest.Target, len(tc.Targets))
+		for j, t := range tc.Targets {
+			movie := ingest.DefaultVariantMovieTemplate
+			episode := ingest.DefaultVariantEpisodeTemplate
+			for _, n := range []*NamingConfig{tc.Naming, t.Naming} {
+				if n == nil {
+					continue
+				}
+				if n.Movie != "" {
+					movie = n.Movie
+				}
+				if n.Episode != "" {
+					episode = n.Episode
+				}
+			}
+
+			naming, err := ingest.NewNaming(movie, episode)
+			if err != nil {
+				return nil, fmt.Errorf("transcode %s target %d: %w", tc.Name, j, err)
+			}
+			targets[j] = t.target(naming)
+		}
+		encoders[i] = workflow.Encoder{
+			Name:       tc.Name,
+			Command:    tc.Command,
+			Ext:        ext,
+			Resolution: tc.Resolution,
+			Targets:    targets,
+		}
+	}
+	return encoders, nil
+}
+
+// MetadataProvider returns the provider named by Metadata, omdb by default,
+// with its responses cached in db.
+func (c Config) MetadataProvider(db *sql.DB) (metadata.Provider, error) {
+	var name string
+	var provider metadata.Provider
+	switch strings.ToLower(c.Metadata) {
+	case "", "omdb":
+		if c.Omdb == nil || c.Omdb.Apikey == "" {
+			return nil, fmt.Errorf("must set omdb apikey")
+		}
+		name = "omdb"
+		provider = metadata.NewOmdbProvider(c.Omdb.Apikey)
+	case "tmdb":
+		if c.Tmdb == nil || c.Tmdb.Apikey == "" {
+			return nil, fmt.Errorf("must set tmdb apikey")
+		}
+		name = "tmdb"
+		provider = metadata.NewTmdbProvider(c.Tmdb.Apikey)
+	default:
+		return nil, fmt.Errorf("unknown metadata provider %q", c.Metadata)
+	}
+	return metadata.NewSqliteCache(db, name, provider, time.Duration(c.MetadataCacheDays)*24*time.Hour)
 }
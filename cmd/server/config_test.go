@@ -1,8 +1,13 @@
 package main
 
 import (
+	"path"
 	"testing"
 
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/ingest"
+	"github.com/aravance/mkv-ripper/model"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -10,14 +15,16 @@ func TestParseConfigBytesEmpty(t *testing.T) {
 	var config Config
 	parseConfigBytes(&config, []byte{})
 	expected := Config{
-		Data:        DEFAULT_DATA_DIR,
-		Log:         DEFAULT_LOG_DIR,
-		Rip:         DEFAULT_RIP_DIR,
-		Port:        DEFAULT_PORT,
-		Shafile:     DEFAULT_SHAFILE,
-		Omdb:        nil,
-		Targets:     []TargetConfig{},
-		UseMovieDir: false,
+		Data:              DEFAULT_DATA_DIR,
+		Log:               DEFAULT_LOG_DIR,
+		Rip:               DEFAULT_RIP_DIR,
+		Port:              DEFAULT_PORT,
+		Shafile:           DEFAULT_SHAFILE,
+		Omdb:              nil,
+		MetadataCacheDays: DEFAULT_METADATA_CACHE_DAYS,
+		Targets:           []TargetConfig{},
+		UseMovieDir:       false,
+		AutoEject:         false,
 	}
 	if !cmp.Equal(config, expected) {
 		t.Fatalf("parseConfigBytes(&config, []byte{}) = %v, expected: %v", config, expected)
@@ -28,14 +35,24 @@ const tomlstr = `
 rip="/var/rip"
 port=1337
 usemoviedir=true
+autoeject=true
 shafile="checksums.sha256"
 
 [omdb]
 apikey="foobar"
 
+[auth]
+enabled=true
+
+[naming]
+movie="{{.Name}} ({{.Year}})/{{.Name}} ({{.Year}}).mkv"
+
 [[targets]]
 path="/home"
 
+[targets.naming]
+episode="{{.Name}}/{{.Name}} {{.Season}}x{{.Episode}}.mkv"
+
 [[targets]]
 scheme="ssh"
 host="localhost"
@@ -46,20 +63,319 @@ func TestParseConfigBytesPartial(t *testing.T) {
 	var config Config
 	parseConfigBytes(&config, []byte(tomlstr))
 	expected := Config{
-		Data:    DEFAULT_DATA_DIR,
-		Log:     DEFAULT_LOG_DIR,
-		Rip:     "/var/rip",
-		Port:    1337,
-		Shafile: "checksums.sha256",
-		Omdb:    &OmdbConfig{"foobar"},
+		Data:              DEFAULT_DATA_DIR,
+		Log:               DEFAULT_LOG_DIR,
+		Rip:               "/var/rip",
+		Port:              1337,
+		Shafile:           "checksums.sha256",
+		Omdb:              &OmdbConfig{"foobar"},
+		MetadataCacheDays: DEFAULT_METADATA_CACHE_DAYS,
+		Auth:              &AuthConfig{Enabled: true, SessionDays: DEFAULT_SESSION_DAYS},
 		Targets: []TargetConfig{
-			{Path: "/home"},
+			{Path: "/home", Naming: &NamingConfig{Episode: "{{.Name}}/{{.Name}} {{.Season}}x{{.Episode}}.mkv"}},
 			{Scheme: "ssh", Host: "localhost", Path: "/var"},
 		},
+		Naming:      &NamingConfig{Movie: "{{.Name}} ({{.Year}})/{{.Name}} ({{.Year}}).mkv"},
 		UseMovieDir: true,
+		AutoEject:   true,
 	}
 
 	if !cmp.Equal(config, expected) {
 		t.Fatalf("parseConfigBytes(&config, []byte{}) = %v, expected: %v", config, expected)
 	}
 }
+
+func TestIngestTargets(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(tomlstr))
+	targets, err := config.IngestTargets()
+	if err != nil {
+		t.Fatalf("config.IngestTargets() error: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("len(targets) = %d, expected: 2", len(targets))
+	}
+
+	season, episode := 1, 2
+	mkv := model.MkvFile{Resolution: "1080p"}
+	movie := model.Media{Name: "bar", Year: "1989"}
+	show := model.Media{Name: "Show", Year: "2001", Season: &season, Episode: &episode}
+
+	tests := []struct {
+		target int
+		media  model.Media
+		dir    string
+		file   string
+	}{
+		{0, movie, "bar (1989)", "bar (1989).mkv"},
+		{0, show, "Show", "Show 1x2.mkv"},
+		{1, movie, "bar (1989)", "bar (1989).mkv"},
+		{1, show, "Show (2001)/Season 01", "Show - S01E02.mkv"},
+	}
+	for _, tt := range tests {
+		dir, file, err := targets[tt.target].Naming.Path(mkv, tt.media)
+		if err != nil {
+			t.Fatalf("Naming.Path() error: %v", err)
+		}
+		if dir != tt.dir || file != tt.file {
+			t.Fatalf("target %d Naming.Path(%v) = %q, %q, expected: %q, %q", tt.target, tt.media, dir, file, tt.dir, tt.file)
+		}
+	}
+	if targets[1].Url.Scheme != "ssh" || targets[1].Url.Host != "localhost" || targets[1].Url.Path != "/var" {
+		t.Fatalf("targets[1].Url = %v", targets[1].Url)
+	}
+
+	config.Naming = &NamingConfig{Movie: "{{.Name"}
+	if _, err := config.IngestTargets(); err == nil {
+		t.Fatal("config.IngestTargets() expected error for invalid template")
+	}
+}
+
+func TestSftpTarget(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(`
+[[targets]]
+scheme = "sftp"
+user = "media"
+host = "nas:2222"
+path = "/movies"
+key = "/etc/mkv-ripper/id_ed25519"
+knownhosts = "/etc/mkv-ripper/known_hosts"
+`))
+	targets, err := config.IngestTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("len(targets) = %d, expected: 1", len(targets))
+	}
+	if u := targets[0].Url.String(); u != "sftp://media@nas:2222/movies" {
+		t.Fatalf("targets[0].Url = %s, expected: sftp://media@nas:2222/movies", u)
+	}
+	if targets[0].Key != "/etc/mkv-ripper/id_ed25519" || targets[0].KnownHosts != "/etc/mkv-ripper/known_hosts" {
+		t.Fatalf("targets[0] = %+v, expected the key and known_hosts", targets[0])
+	}
+}
+
+func TestS3Target(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(`
+[[targets]]
+scheme = "s3"
+host = "rips"
+path = "/movies"
+endpoint = "http://minio:9000"
+accesskey = "key"
+secretkey = "secret"
+`))
+	targets, err := config.IngestTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := targets[0].Url.String(); u != "s3://rips/movies" {
+		t.Fatalf("targets[0].Url = %s, expected: s3://rips/movies", u)
+	}
+	expected := ingest.S3Config{Endpoint: "http://minio:9000", AccessKey: "key", SecretKey: "secret"}
+	if targets[0].S3 != expected {
+		t.Fatalf("targets[0].S3 = %+v, expected: %+v", targets[0].S3, expected)
+	}
+}
+
+func TestWebdavTarget(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(`
+[[targets]]
+scheme = "webdavs"
+user = "rips"
+password = "secret"
+host = "nas:8443"
+path = "/movies"
+`))
+	targets, err := config.IngestTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := targets[0].Url.String(); u != "webdavs://rips@nas:8443/movies" {
+		t.Fatalf("targets[0].Url = %s, expected: webdavs://rips@nas:8443/movies", u)
+	}
+	if targets[0].Password != "secret" {
+		t.Fatalf("targets[0].Password = %q, expected: secret", targets[0].Password)
+	}
+}
+
+func TestBackupTargets(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(tomlstr))
+	if targets, err := config.BackupTargets(); err != nil || len(targets) != 0 {
+		t.Fatalf("config.BackupTargets() = %v, %v, expected no targets", targets, err)
+	}
+
+	parseConfigBytes(&config, []byte(`
+[backup]
+iso = true
+naming = "Backups/{{.Name}} ({{.Year}}){{.Ext}}"
+
+[[backup.targets]]
+path = "/backups"
+
+[[backup.targets]]
+scheme = "ssh"
+host = "nas"
+path = "/backups"
+naming = { movie = "{{.Name}}{{.Ext}}" }
+`))
+	if config.Backup == nil || !config.Backup.Iso {
+		t.Fatalf("config.Backup = %+v, expected iso backups", config.Backup)
+	}
+	targets, err := config.BackupTargets()
+	if err != nil {
+		t.Fatalf("config.BackupTargets() error: %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("len(targets) = %d, expected: 2", len(targets))
+	}
+
+	iso := model.MkvFile{Filename: "/rip/d1/backup.iso"}
+	movie := model.Media{Name: "bar", Year: "1989"}
+	for i, expected := range []string{"Backups/bar (1989).iso", "bar.iso"} {
+		dir, file, err := targets[i].Naming.Path(iso, movie)
+		if err != nil {
+			t.Fatalf("Naming.Path() error: %v", err)
+		}
+		if p := path.Join(dir, file); p != expected {
+			t.Fatalf("target %d Naming.Path() = %q, expected: %q", i, p, expected)
+		}
+	}
+	if targets[1].Url.Scheme != "ssh" || targets[1].Url.Host != "nas" {
+		t.Fatalf("targets[1].Url = %v", targets[1].Url)
+	}
+}
+
+func TestParseConfigBytesWatch(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(`
+[watch]
+dir = "/srv/isos"
+interval = 30
+`))
+	expected := &WatchConfig{Dir: "/srv/isos", Done: "/srv/isos/done", Interval: 30}
+	if !cmp.Equal(config.Watch, expected) {
+		t.Fatalf("config.Watch = %+v, expected: %+v", config.Watch, expected)
+	}
+}
+
+func TestRipProfiles(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(`
+profile = "movies"
+
+[profiles.movies]
+selection = "-sel:all,+sel:(eng|nolang)"
+cache = 1024
+
+[profiles.extras]
+minlength = 120
+decrypt = false
+
+[discprofiles]
+dvd = "extras"
+`))
+	profiles, err := config.RipProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	movies := drive.RipProfile{Name: "movies", Minlength: drive.DefaultMinlength, Selection: "-sel:all,+sel:(eng|nolang)", Decrypt: true, Cache: 1024}
+	if got := profiles.Get(""); !cmp.Equal(got, movies) {
+		t.Fatalf(`Get("") = %+v, expected: %+v`, got, movies)
+	}
+	extras := drive.RipProfile{Name: "extras", Minlength: 120}
+	if got := profiles.Get("extras"); !cmp.Equal(got, extras) {
+		t.Fatalf(`Get("extras") = %+v, expected: %+v`, got, extras)
+	}
+	if got := profiles.ForDisc(&makemkv.DiscInfo{DiscType: "DVD disc"}); got != "extras" {
+		t.Fatalf("ForDisc(dvd) = %s, expected: extras", got)
+	}
+
+	config = Config{}
+	parseConfigBytes(&config, []byte("[profiles.a]\n[profiles.b]\n"))
+	if _, err := config.RipProfiles(); err == nil {
+		t.Fatal("expected profiles without a default to fail")
+	}
+
+	config = Config{}
+	parseConfigBytes(&config, nil)
+	if profiles, err := config.RipProfiles(); err != nil || profiles.Get("").Minlength != drive.DefaultMinlength || profiles.Minlength() != drive.EpisodeMinlength {
+		t.Fatalf("RipProfiles() without profiles = %v, %v", profiles.Names(), err)
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	var config Config
+	parseConfigBytes(&config, []byte(`
+[[transcode]]
+name = "720p"
+command = ["HandBrakeCLI", "-i", "{input}", "-o", "{output}", "--preset", "Fast 720p30"]
+ext = "mp4"
+resolution = "720p"
+
+[[transcode.targets]]
+path = "/mobile"
+
+[[transcode.targets]]
+path = "/tablet"
+naming = { movie = "{{.Name}}{{.Ext}}" }
+`))
+	encoders, err := config.Encoders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoders) != 1 || encoders[0].Name != "720p" || encoders[0].Ext != ".mp4" || encoders[0].Resolution != "720p" {
+		t.Fatalf("config.Encoders() = %+v, expected a 720p mp4 encoder", encoders)
+	}
+
+	mp4 := model.MkvFile{Filename: "/rip/d1/title_t00.720p.mp4", Variant: "720p"}
+	movie := model.Media{Name: "bar", Year: "1989"}
+	for i, expected := range []string{"bar (1989) - 720p.mp4", "bar.mp4"} {
+		dir, file, err := encoders[0].Targets[i].Naming.Path(mp4, movie)
+		if err != nil {
+			t.Fatalf("Naming.Path() error: %v", err)
+		}
+		if p := path.Join(dir, file); p != expected {
+			t.Fatalf("target %d Naming.Path() = %q, expected: %q", i, p, expected)
+		}
+	}
+
+	for _, bad := range []string{
+		"[[transcode]]\ncommand = [\"ffmpeg\", \"{output}\"]\n",
+		"[[transcode]]\nname = \"a/b\"\ncommand = [\"ffmpeg\", \"{output}\"]\n",
+		"[[transcode]]\nname = \"small\"\ncommand = [\"ffmpeg\", \"{input}\"]\n",
+		"[[transcode]]\nname = \"small\"\ncommand = [\"ffmpeg\", \"{output}\"]\n[[transcode]]\nname = \"small\"\ncommand = [\"ffmpeg\", \"{output}\"]\n",
+	} {
+		config = Config{}
+		parseConfigBytes(&config, []byte(bad))
+		if _, err := config.Encoders(); err == nil {
+			t.Fatalf("expected config.Encoders() to fail for %q", bad)
+		}
+	}
+}
+
+func TestMetadataProvider(t *testing.T) {
+	db := openTestDB(t)
+	tests := []struct {
+		toml string
+		ok   bool
+	}{
+		{"", false},
+		{"[omdb]\napikey=\"foo\"", true},
+		{"metadata=\"tmdb\"\n[omdb]\napikey=\"foo\"", false},
+		{"metadata=\"tmdb\"\n[tmdb]\napikey=\"foo\"", true},
+		{"metadata=\"other\"\n[omdb]\napikey=\"foo\"", false},
+	}
+	for _, test := range tests {
+		var config Config
+		parseConfigBytes(&config, []byte(test.toml))
+		if _, err := config.MetadataProvider(db); (err == nil) != test.ok {
+			t.Errorf("MetadataProvider() with %q err = %v, expected ok %v", test.toml, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"slices"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+	"github.com/aravance/mkv-ripper/workflow"
+)
+
+// handleEpisodes queues a workflow for every episode on a tv series disc,
+// numbering them after the episodes already ripped from earlier discs of the
+// same season.
+func handleEpisodes(
+	wfman workflow.WorkflowManager,
+	d drive.Drive,
+	provider metadata.Provider,
+	disc *drive.Disc,
+	info *makemkv.DiscInfo,
+	episodes []*makemkv.TitleInfo,
+	profile string,
+) {
+	season := util.GuessSeason(disc.Label, info.Name, info.VolumeName)
+	if season == 0 {
+		season = 1
+	}
+
+	name := util.GuessName(info, episodes[0])
+	series, err := util.GetSeries(name, provider)
+	if err != nil {
+		log.Println("failed to fetch series details:", name, "err:", err)
+		series = nil
+	}
+
+	first := 1
+	if series != nil {
+		first = nextEpisode(wfman, disc.Id, series.Ids, season)
+	}
+	if first == 1 {
+		// nothing ripped from this season yet, assume earlier discs hold the
+		// same number of episodes as this one
+		if n := util.GuessDiscNumber(disc.Label, info.Name, info.VolumeName); n > 1 {
+			first = (n-1)*len(episodes) + 1
+		}
+	}
+
+	var seasonEpisodes []metadata.Episode
+	if series != nil {
+		if s, err := provider.Season(series.Ids, season); err != nil {
+			log.Println("failed to fetch season details:", series.Title, season, "err:", err)
+		} else {
+			seasonEpisodes = s
+		}
+	}
+	numbers := episodeNumbers(first, len(episodes), seasonEpisodes)
+	if numbers[0] != first {
+		log.Printf("numbering %s season %d from episode %d instead of %d, the season has %d episodes", series.Title, season, numbers[0], first, len(seasonEpisodes))
+	}
+
+	for i, t := range episodes {
+		wf, _ := wfman.NewWorkflow(disc.Id, t.Id, disc.Label, util.GuessName(info, t))
+		s, e := season, numbers[i]
+		wf.Season = &s
+		wf.Episode = &e
+		wf.Profile = profile
+		if series != nil {
+			util.SetTitle(wf, series)
+		}
+		if err := wfman.Enqueue(d, wf); err != nil {
+			log.Println("failed to queue episode", wf, "err:", err)
+		}
+	}
+}
+
+// episodeNumbers numbers count episodes from the first. With the episode list
+// of the season, a disc that would end past the season's last episode is moved
+// back to end on it, and the numbers are taken from the list, which may skip
+// or start at 0.
+func episodeNumbers(first int, count int, season []metadata.Episode) []int {
+	numbers := make([]int, count)
+	if len(season) == 0 {
+		for i := range numbers {
+			numbers[i] = first + i
+		}
+		return numbers
+	}
+
+	season = slices.Clone(season)
+	slices.SortFunc(season, func(a, b metadata.Episode) int {
+		return a.Episode - b.Episode
+	})
+	if first+count-1 > len(season) {
+		first = max(1, len(season)-count+1)
+	}
+	last := season[len(season)-1].Episode
+	for i := range numbers {
+		if n := first - 1 + i; n < len(season) {
+			numbers[i] = season[n].Episode
+		} else {
+			numbers[i] = last + n - len(season) + 1
+		}
+	}
+	return numbers
+}
+
+// nextEpisode returns the episode number following the last one ripped for
+// the season from any other disc.
+func nextEpisode(wfman workflow.WorkflowManager, discId string, seriesIds metadata.Ids, season int) int {
+	last := 0
+	for _, wf := range wfman.GetAllWorkflows() {
+		if wf.DiscId == discId || !isSeason(wf, seriesIds, season) {
+			continue
+		}
+		last = max(last, *wf.Episode)
+	}
+	return last + 1
+}
+
+func isSeason(wf *model.Workflow, seriesIds metadata.Ids, season int) bool {
+	if !wf.IsEpisode() || *wf.Season != season {
+		return false
+	}
+	ids := util.TitleIds(wf)
+	return (ids.Imdb != "" && ids.Imdb == seriesIds.Imdb) || (ids.Tmdb != "" && ids.Tmdb == seriesIds.Tmdb)
+}
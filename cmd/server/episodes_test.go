@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
+)
+
+func intPtr(i int) *int { return &i }
+
+func TestNextEpisode(t *testing.T) {
+	db := openTestDB(t)
+	discdb := newTestDiscDB(t, db)
+	wfm := newTestWorkflowManager(t, db, discdb)
+
+	series := "tt0108778"
+	other := "tt0386676"
+	wfm.Save(&model.Workflow{DiscId: "disc1", TitleId: 0, ImdbId: &series, Season: intPtr(1), Episode: intPtr(1)})
+	wfm.Save(&model.Workflow{DiscId: "disc1", TitleId: 1, ImdbId: &series, Season: intPtr(1), Episode: intPtr(4)})
+	wfm.Save(&model.Workflow{DiscId: "disc1", TitleId: 2, ImdbId: &series, Season: intPtr(2), Episode: intPtr(9)})
+	wfm.Save(&model.Workflow{DiscId: "disc3", TitleId: 0, ImdbId: &other, Season: intPtr(1), Episode: intPtr(12)})
+	wfm.Save(&model.Workflow{DiscId: "disc2", TitleId: 0, ImdbId: &series, Season: intPtr(1), Episode: intPtr(8)})
+
+	if next := nextEpisode(wfm, "disc2", metadata.Ids{Imdb: series}, 1); next != 5 {
+		t.Fatalf("nextEpisode(season 1) = %d, expected %d", next, 5)
+	}
+	if next := nextEpisode(wfm, "disc2", metadata.Ids{Imdb: series}, 3); next != 1 {
+		t.Fatalf("nextEpisode(season 3) = %d, expected %d", next, 1)
+	}
+
+	// episodes named with another provider are matched by its id
+	tmdbId := "1668"
+	wfm.Save(&model.Workflow{DiscId: "disc4", TitleId: 0, TmdbId: &tmdbId, Season: intPtr(1), Episode: intPtr(6)})
+	if next := nextEpisode(wfm, "disc2", metadata.Ids{Imdb: series, Tmdb: tmdbId}, 1); next != 7 {
+		t.Fatalf("nextEpisode(tmdb) = %d, expected %d", next, 7)
+	}
+}
+
+func TestEpisodeNumbers(t *testing.T) {
+	season := func(numbers ...int) []metadata.Episode {
+		episodes := make([]metadata.Episode, len(numbers))
+		for i, n := range numbers {
+			episodes[i] = metadata.Episode{Episode: n}
+		}
+		return episodes
+	}
+
+	tests := []struct {
+		name     string
+		first    int
+		count    int
+		season   []metadata.Episode
+		expected []int
+	}{
+		{"no season", 5, 3, nil, []int{5, 6, 7}},
+		{"fits", 5, 3, season(1, 2, 3, 4, 5, 6, 7, 8), []int{5, 6, 7}},
+		// the last disc holds fewer episodes than the first ones
+		{"past the end", 7, 4, season(1, 2, 3, 4, 5, 6, 7, 8), []int{5, 6, 7, 8}},
+		{"longer than the season", 1, 4, season(1, 2, 3), []int{1, 2, 3, 4}},
+		{"starts at 0", 1, 2, season(0, 1, 2, 3), []int{0, 1}},
+		{"unordered", 2, 2, season(3, 1, 2), []int{2, 3}},
+	}
+	for _, test := range tests {
+		if got := episodeNumbers(test.first, test.count, test.season); !slices.Equal(got, test.expected) {
+			t.Errorf("%s: episodeNumbers(%d, %d) = %v, expected: %v", test.name, test.first, test.count, got, test.expected)
+		}
+	}
+}
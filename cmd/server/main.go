@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path"
@@ -16,12 +15,14 @@ import (
 	"time"
 
 	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/auth"
 	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/event"
 	"github.com/aravance/mkv-ripper/handler"
+	"github.com/aravance/mkv-ripper/metadata"
 	"github.com/aravance/mkv-ripper/model"
 	"github.com/aravance/mkv-ripper/util"
 	"github.com/aravance/mkv-ripper/workflow"
-	"github.com/eefret/gomdb"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "modernc.org/sqlite"
@@ -29,18 +30,24 @@ import (
 
 func main() {
 	cfg := ParseConfigFile("mkv-ripper.toml")
-	if cfg.Omdb == nil || cfg.Omdb.Apikey == "" {
-		log.Fatalln("must set omdb apikey")
-	}
 
 	outdir := cfg.Rip
-	targets := make([]*url.URL, len(cfg.Targets))
-	for i, t := range cfg.Targets {
-		targets[i] = &url.URL{
-			Scheme: t.Scheme,
-			Host:   t.Host,
-			Path:   t.Path,
-		}
+	targets, err := cfg.IngestTargets()
+	if err != nil {
+		log.Fatalln("invalid naming template", err)
+	}
+	backupTargets, err := cfg.BackupTargets()
+	if err != nil {
+		log.Fatalln("invalid backup naming template", err)
+	}
+	backups := workflow.Backups{Targets: backupTargets, Iso: cfg.Backup != nil && cfg.Backup.Iso}
+	profiles, err := cfg.RipProfiles()
+	if err != nil {
+		log.Fatalln("invalid rip profiles", err)
+	}
+	encoders, err := cfg.Encoders()
+	if err != nil {
+		log.Fatalln("invalid transcode config", err)
 	}
 
 	if logfile, err := os.OpenFile(path.Join(cfg.Log, "mkv.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664); err != nil {
@@ -57,27 +64,57 @@ func main() {
 	}
 	defer sqldb.Close()
 
+	if len(os.Args) > 1 {
+		code := runCommand(sqldb, os.Args[1:], os.Stdin, os.Stdout)
+		sqldb.Close()
+		os.Exit(code)
+	}
+
+	provider, err := cfg.MetadataProvider(sqldb)
+	if err != nil {
+		log.Fatalln("failed to initialize metadata provider", err)
+	}
+
+	if err := migrateDiscIds(sqldb); err != nil {
+		log.Fatalln("failed to migrate disc ids", err)
+	}
+
 	var wfman workflow.WorkflowManager
-	omdbapi := gomdb.Init(cfg.Omdb.Apikey)
 	discdb, err := drive.NewSqliteDiscDatabase(sqldb)
 	if err != nil {
 		log.Fatalln("failed to initialize disc database", err)
 	}
 
-	migrateJsonDiscs(path.Join(cfg.Data, "discs.json"), discdb)
+	discIds := migrateJsonDiscs(path.Join(cfg.Data, "discs.json"), discdb)
+
+	matches, err := drive.NewSqliteMatchDatabase(sqldb)
+	if err != nil {
+		log.Fatalln("failed to initialize match database", err)
+	}
 
-	handle := func(driveman drive.DriveManager) {
-		handleDisc(discdb, wfman, driveman, omdbapi)
+	hub := event.NewHub()
+	handle := func(d drive.Drive) {
+		handleDisc(discdb, matches, wfman, d, provider)
+	}
+	changed := func(d drive.Drive) {
+		hub.Publish(event.Drive(d.Id()))
+	}
+	driveman := drive.NewUdevDriveManager(discdb, profiles.Minlength(), handle, changed)
+	if cfg.Watch != nil {
+		interval := time.Duration(cfg.Watch.Interval) * time.Second
+		dirman := drive.NewDirDriveManager(cfg.Watch.Dir, cfg.Watch.Done, interval, discdb, profiles.Minlength(), handle, changed)
+		driveman = drive.NewMultiDriveManager(driveman, dirman)
 	}
-	driveman := drive.NewUdevDriveManager(handle)
-	wfman, err = workflow.NewSqliteWorkflowManager(sqldb, driveman, discdb, targets, outdir, cfg.UseMovieDir, cfg.Shafile)
+	wfman, err = workflow.NewSqliteWorkflowManager(sqldb, discdb, targets, backups, profiles, encoders, outdir, cfg.Shafile, cfg.AutoEject, hub)
 	if err != nil {
 		log.Fatalln("failed to initialize workflow manager", err)
 	}
 
-	migrateJsonWorkflows(path.Join(cfg.Data, "workflows.json"), wfman)
+	migrateJsonWorkflows(path.Join(cfg.Data, "workflows.json"), wfman, discIds)
 
-	driveman.Start()
+	if err := driveman.Start(); err != nil {
+		log.Println("error starting drive manager", err)
+	}
 	defer driveman.Stop()
 
 	for _, wf := range wfman.GetAllWorkflows() {
@@ -85,12 +122,13 @@ func main() {
 			wf.Status = model.StatusError
 			wfman.Save(wf)
 		}
-		if wf.Status == model.StatusImporting {
+		// a half done transcode is started over
+		if wf.Status == model.StatusImporting || wf.Status == model.StatusTranscoding {
 			wf.Status = model.StatusPending
 			wfman.Save(wf)
 		}
 		if wf.Status == model.StatusPending {
-			if wf.Name != nil && wf.Year != nil {
+			if wf.Name != nil && wf.Year != nil && !wf.NeedsReview() {
 				go func(w *model.Workflow) {
 					wfman.Ingest(w)
 				}(wf)
@@ -103,21 +141,63 @@ func main() {
 	server.Use(middleware.Logger())
 	server.Use(middleware.Recover())
 
+	if cfg.Auth != nil && cfg.Auth.Enabled {
+		store, err := auth.NewSqliteAuthStore(sqldb)
+		if err != nil {
+			log.Fatalln("failed to initialize auth store", err)
+		}
+		if !store.HasUsers() {
+			log.Fatalln("auth is enabled but there are no users, add one with: mkv-ripper useradd <username>")
+		}
+		authHandler := handler.NewAuthHandler(store, time.Duration(cfg.Auth.SessionDays)*24*time.Hour, cfg.Auth.SecureCookie)
+		server.GET("/login", authHandler.GetLogin)
+		server.POST("/login", authHandler.PostLogin)
+		server.POST("/logout", authHandler.PostLogout)
+		server.Use(auth.Middleware(store, "/login", func(c echo.Context) bool {
+			return c.Request().URL.Path == "/login"
+		}))
+	}
+
 	indexHandler := handler.NewIndexHandler(driveman, wfman)
-	driveHandler := handler.NewDriveHandler(discdb, driveman, wfman, omdbapi)
-	workflowHandler := handler.NewWorkflowHandler(wfman, driveman, discdb, omdbapi)
-	omdbHandler := handler.NewOmdbHandler(omdbapi)
+	driveHandler := handler.NewDriveHandler(discdb, matches, driveman, wfman, provider)
+	workflowHandler := handler.NewWorkflowHandler(wfman, driveman, discdb, matches, provider)
+	searchHandler := handler.NewSearchHandler(provider)
+	eventHandler := handler.NewEventHandler(hub, driveman, wfman)
+	apiHandler := handler.NewApiHandler(discdb, matches, driveman, wfman, provider)
 
 	server.GET("/", indexHandler.GetIndex)
-	server.GET("/drive", driveHandler.GetDrive)
-	server.GET("/drive/status", driveHandler.GetDriveStatus)
+	server.GET("/drive", driveHandler.GetDrives)
+	server.GET("/drive/:driveId", driveHandler.GetDrive)
+	server.GET("/drive/:driveId/status", driveHandler.GetDriveStatus)
+	server.POST("/drive/:driveId/eject", driveHandler.Eject)
+	server.POST("/drive/:driveId/backup", driveHandler.Backup)
+	server.POST("/drive/:driveId/queue", driveHandler.QueueTitles)
 	server.GET("/disc/:discId/title/:titleId", workflowHandler.GetWorkflow)
 	server.POST("/disc/:discId/title/:titleId", workflowHandler.PostWorkflow)
 	server.GET("/disc/:discId/title/:titleId/edit", workflowHandler.EditWorkflow)
 	server.GET("/disc/:discId/title/:titleId/status", workflowHandler.Status)
-	// TODO make this a post
-	server.GET("/disc/:discId/title/:titleId/rip", workflowHandler.RipTitle)
-	server.GET("/omdb/search", omdbHandler.Search)
+	server.POST("/disc/:discId/title/:titleId/cancel", workflowHandler.CancelWorkflow)
+	server.POST("/disc/:discId/title/:titleId/retry", workflowHandler.RetryIngest)
+	server.POST("/disc/:discId/title/:titleId/confirm", workflowHandler.ConfirmWorkflow)
+	server.POST("/disc/:discId/title/:titleId/rip", workflowHandler.RipTitle)
+	server.GET("/search", searchHandler.Search)
+	server.GET("/events", eventHandler.Stream)
+
+	api := server.Group("/api/v1")
+	api.GET("/openapi.yaml", apiHandler.OpenApi)
+	api.GET("/workflows", apiHandler.ListWorkflows)
+	api.GET("/workflows/:discId/:titleId", apiHandler.GetWorkflow)
+	api.PUT("/workflows/:discId/:titleId/metadata", apiHandler.SetMetadata)
+	api.POST("/workflows/:discId/:titleId/rip", apiHandler.RipTitle)
+	api.POST("/workflows/:discId/:titleId/ingest", apiHandler.Ingest)
+	api.POST("/workflows/:discId/:titleId/cancel", apiHandler.Cancel)
+	api.POST("/workflows/:discId/:titleId/confirm", apiHandler.Confirm)
+	api.GET("/drives", apiHandler.ListDrives)
+	api.GET("/drives/:driveId", apiHandler.GetDrive)
+	api.GET("/drives/:driveId/info", apiHandler.GetDiscInfo)
+	api.POST("/drives/:driveId/eject", apiHandler.Eject)
+	api.POST("/drives/:driveId/backup", apiHandler.Backup)
+	api.GET("/search", apiHandler.Search)
 
 	go func() {
 		if err := server.Start(fmt.Sprintf(":%d", cfg.Port)); !errors.Is(err, http.ErrServerClosed) {
@@ -125,11 +205,12 @@ func main() {
 		}
 	}()
 
-	sigchan := make(chan os.Signal)
+	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigchan
 
 	log.Println("shutting down")
+	hub.Close()
 	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownRelease()
 	if err := server.Shutdown(shutdownCtx); err != nil {
@@ -139,58 +220,120 @@ func main() {
 
 func handleDisc(
 	discdb drive.DiscDatabase,
+	matches drive.MatchDatabase,
 	wfman workflow.WorkflowManager,
-	driveman drive.DriveManager,
-	omdbapi *gomdb.OmdbApi,
+	d drive.Drive,
+	provider metadata.Provider,
 ) {
-	disc := driveman.GetDisc()
-	if disc == nil || (disc.Uuid == "" && disc.Label == "") {
+	disc := d.GetDisc()
+	if disc == nil {
 		return
 	}
 
 	var info *makemkv.DiscInfo
 	var found bool
 	var err error
-	info, found = discdb.GetDiscInfo(disc.Uuid)
+	if disc.Id != "" {
+		info, found = discdb.GetDiscInfo(disc.Id)
+	}
 	if !found {
-		info, err = driveman.GetDiscInfo()
+		info, err = d.GetDiscInfo()
 		if err != nil {
 			log.Println("error getting disc info:", disc, "err:", err)
 			return
 		}
-		err = discdb.SaveDiscInfo(disc.Uuid, info)
+		// reading the disc gave it its id
+		disc = d.GetDisc()
+		if disc == nil || disc.Id == "" {
+			log.Println("disc changed while reading it")
+			return
+		}
+		// a copy of a disc seen before, or one with a new uuid
+		_, found = discdb.GetDiscInfo(disc.Id)
+		err = discdb.SaveDiscInfo(disc, info)
 		if err != nil {
 			log.Println("error saving disc info:", disc, "err:", err)
 			return
 		}
+	}
+
+	if found {
+		wfman.ResumeQueue(d)
+	} else {
+		// guesses only pick from the titles of the disc's default profile
+		profile := wfman.Profiles().Get(wfman.Profiles().ForDisc(info))
+		if handleKnownDisc(matches, wfman, d, provider, disc, info, profile.Name) {
+			return
+		}
 
-		main := util.GuessMainTitle(info)
+		// episodes are often shorter than a profile's minlength, so they're
+		// guessed from every title that was read
+		if episodes := util.GuessEpisodes(info); len(episodes) > 0 {
+			handleEpisodes(wfman, d, provider, disc, info, episodes, profile.Name)
+			return
+		}
+
+		main := util.GuessMainTitle(profile.Titles(info))
 		if main == nil {
 			log.Println("failed to guess main title")
 			return
 		}
 		name := util.GuessName(info, main)
-		wf, _ := wfman.NewWorkflow(disc.Uuid, main.Id, disc.Label, name)
+		wf, _ := wfman.NewWorkflow(disc.Id, main.Id, disc.Label, name)
+		wf.Profile = profile.Name
 
 		var wg sync.WaitGroup
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if movie, err := util.GetMovie(name, omdbapi); err != nil {
+			if match, err := util.MatchMovie(provider, name, main.Duration, disc.Label, info.VolumeName); err != nil {
 				log.Println("failed to fetch movie details:", name)
 			} else {
-				wf.Name = &movie.Title
-				wf.Year = &movie.Year
-				wf.ImdbId = &movie.ImdbID
+				util.SetTitle(wf, match.Title)
+				wf.Confidence = &match.Confidence
 				wfman.Save(wf)
 			}
 		}()
 
-		err = wfman.Start(wf)
+		err = wfman.Enqueue(d, wf)
 		wg.Wait()
 		if err != nil {
-			log.Println("failed to rip title", err)
+			log.Println("failed to queue title", err)
 			return
 		}
 	}
 }
+
+// handleKnownDisc queues the title a user picked for another copy of the disc,
+// with the movie they picked for it. It returns false if the disc hasn't been
+// seen before.
+func handleKnownDisc(
+	matches drive.MatchDatabase,
+	wfman workflow.WorkflowManager,
+	d drive.Drive,
+	provider metadata.Provider,
+	disc *drive.Disc,
+	info *makemkv.DiscInfo,
+	profile string,
+) bool {
+	m, ok := drive.FindMatch(matches, disc.Label, info)
+	if !ok {
+		return false
+	}
+	movie, err := provider.Get(m.Ids, metadata.Movie)
+	if err != nil {
+		log.Println("failed to fetch remembered movie:", m.Ids, "err:", err)
+		return false
+	}
+
+	title := &info.Titles[m.TitleId]
+	wf, _ := wfman.NewWorkflow(disc.Id, title.Id, disc.Label, util.GuessName(info, title))
+	util.SetTitle(wf, movie)
+	wf.Confidence = drive.MatchConfidence(m, info)
+	wf.Profile = profile
+	wfman.Save(wf)
+	if err := wfman.Enqueue(d, wf); err != nil {
+		log.Println("failed to queue title", err)
+	}
+	return true
+}
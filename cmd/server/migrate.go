@@ -1,36 +1,50 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
 
 	"github.com/aravance/go-makemkv"
 	"github.com/aravance/mkv-ripper/drive"
 	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
 	"github.com/aravance/mkv-ripper/workflow"
 )
 
-func migrateJsonDiscs(file string, discdb drive.DiscDatabase) {
+// migrateJsonDiscs imports discs.json, which was keyed by filesystem uuid. It
+// returns the ids the discs were saved under by their uuid.
+func migrateJsonDiscs(file string, discdb drive.DiscDatabase) map[string]string {
+	ids := make(map[string]string)
 	bytes, err := os.ReadFile(file)
 	if err != nil {
-		return // no file to migrate
+		return ids // no file to migrate
 	}
 
 	var discInfoMap map[string]*makemkv.DiscInfo
 	if err := json.Unmarshal(bytes, &discInfoMap); err != nil {
 		log.Println("failed to parse discs.json for migration:", err)
-		return
+		return ids
 	}
 
 	migrated := 0
 	for uuid, info := range discInfoMap {
-		if _, ok := discdb.GetDiscInfo(uuid); ok {
+		// by uuid only, the disc may have been relabelled since
+		if disc, ok := discdb.FindDisc(uuid, ""); ok {
+			ids[uuid] = disc.Id
 			continue // already in sqlite
 		}
-		if err := discdb.SaveDiscInfo(uuid, info); err != nil {
+		id := drive.Fingerprint(info)
+		if _, ok := discdb.GetDiscInfo(id); ok {
+			// another disc with the same titles, keep them apart
+			id = uuid
+		}
+		if err := discdb.SaveDiscInfo(&drive.Disc{Id: id, Uuid: uuid, Minlength: drive.DefaultMinlength}, info); err != nil {
 			log.Println("failed to migrate disc", uuid, err)
 		} else {
+			ids[uuid] = id
 			migrated++
 		}
 	}
@@ -41,9 +55,12 @@ func migrateJsonDiscs(file string, discdb drive.DiscDatabase) {
 	if err := os.Rename(file, file+".bak"); err != nil {
 		log.Println("failed to rename", file, "to .bak:", err)
 	}
+	return ids
 }
 
-func migrateJsonWorkflows(file string, wfman workflow.WorkflowManager) {
+// migrateJsonWorkflows imports workflows.json, discIds maps the uuids they
+// were keyed by to the ids of their discs.
+func migrateJsonWorkflows(file string, wfman workflow.WorkflowManager, discIds map[string]string) {
 	bytes, err := os.ReadFile(file)
 	if err != nil {
 		return // no file to migrate
@@ -56,7 +73,11 @@ func migrateJsonWorkflows(file string, wfman workflow.WorkflowManager) {
 	}
 
 	migrated := 0
-	for discId, titles := range wfMap {
+	for uuid, titles := range wfMap {
+		discId := uuid
+		if id, ok := discIds[uuid]; ok {
+			discId = id
+		}
 		for titleId, wf := range titles {
 			if existing := wfman.GetWorkflow(discId, titleId); existing != nil {
 				continue // already in sqlite
@@ -78,3 +99,110 @@ func migrateJsonWorkflows(file string, wfman workflow.WorkflowManager) {
 		log.Println("failed to rename", file, "to .bak:", err)
 	}
 }
+
+// migrateDiscIds moves discs that were keyed by their filesystem uuid over to
+// their fingerprint, along with their workflows. Discs whose fingerprint is
+// taken by another disc keep their uuid.
+func migrateDiscIds(db *sql.DB) error {
+	hasUuid, err := util.HasColumn(db, "disc_info", "uuid")
+	if err != nil {
+		return err
+	}
+	hasId, err := util.HasColumn(db, "disc_info", "id")
+	if err != nil || !hasUuid || hasId {
+		return err
+	}
+
+	type oldDisc struct {
+		uuid     string
+		infoJson string
+	}
+	var discs []oldDisc
+	rows, err := db.Query("SELECT uuid, info_json FROM disc_info")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var d oldDisc
+		if err := rows.Scan(&d.uuid, &d.infoJson); err != nil {
+			log.Println("error scanning disc_info row:", err)
+			continue
+		}
+		discs = append(discs, d)
+	}
+	rows.Close()
+
+	hasWorkflows, err := util.HasColumn(db, "workflows", "disc_id")
+	if err != nil {
+		return err
+	}
+	hasTargets, err := util.HasColumn(db, "ingest_targets", "disc_id")
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE TABLE disc_info_new (
+		id TEXT PRIMARY KEY,
+		uuid TEXT,
+		label TEXT,
+		info_json TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, d := range discs {
+		id := d.uuid
+		var info makemkv.DiscInfo
+		if err := json.Unmarshal([]byte(d.infoJson), &info); err != nil {
+			log.Println("error unmarshaling disc info for", d.uuid, err)
+		} else if fingerprint := drive.Fingerprint(&info); !used[fingerprint] {
+			id = fingerprint
+		}
+		used[id] = true
+
+		var label sql.NullString
+		if hasWorkflows {
+			err := tx.QueryRow("SELECT label FROM workflows WHERE disc_id = ? AND label != '' LIMIT 1", d.uuid).Scan(&label)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+		_, err := tx.Exec("INSERT INTO disc_info_new (id, uuid, label, info_json) VALUES (?, ?, ?, ?)", id, d.uuid, label, d.infoJson)
+		if err != nil {
+			return err
+		}
+		if id == d.uuid {
+			continue
+		}
+		if hasWorkflows {
+			if _, err := tx.Exec("UPDATE workflows SET disc_id = ? WHERE disc_id = ?", id, d.uuid); err != nil {
+				return err
+			}
+		}
+		if hasTargets {
+			if _, err := tx.Exec("UPDATE ingest_targets SET disc_id = ? WHERE disc_id = ?", id, d.uuid); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec("DROP TABLE disc_info"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("ALTER TABLE disc_info_new RENAME TO disc_info"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("moved %d disc(s) over to fingerprint ids", len(discs))
+	return nil
+}
@@ -24,20 +24,6 @@ func openTestDB(t *testing.T) *sql.DB {
 	return db
 }
 
-// Mock DriveManager for workflow manager
-type testDriveManager struct{}
-
-func (m *testDriveManager) Eject() error                             { return nil }
-func (m *testDriveManager) GetDiscInfo() (*makemkv.DiscInfo, error)  { return nil, nil }
-func (m *testDriveManager) GetDisc() *drive.Disc                    { return nil }
-func (m *testDriveManager) HasDisc() bool                           { return false }
-func (m *testDriveManager) Start() error                            { return nil }
-func (m *testDriveManager) Stop() error                             { return nil }
-func (m *testDriveManager) Status() drive.DriveStatus                { return drive.StatusEmpty }
-func (m *testDriveManager) RipFile(_ *makemkv.TitleInfo, _ string, _ chan makemkv.Status) (*model.MkvFile, error) {
-	return nil, nil
-}
-
 func newTestDiscDB(t *testing.T, db *sql.DB) drive.DiscDatabase {
 	t.Helper()
 	discdb, err := drive.NewSqliteDiscDatabase(db)
@@ -49,7 +35,7 @@ func newTestDiscDB(t *testing.T, db *sql.DB) drive.DiscDatabase {
 
 func newTestWorkflowManager(t *testing.T, db *sql.DB, discdb drive.DiscDatabase) workflow.WorkflowManager {
 	t.Helper()
-	wfm, err := workflow.NewSqliteWorkflowManager(db, &testDriveManager{}, discdb, nil, t.TempDir(), false, "movies.sha256")
+	wfm, err := workflow.NewSqliteWorkflowManager(db, discdb, nil, workflow.Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -69,13 +55,23 @@ func TestMigrateJsonDiscs_ImportsAll(t *testing.T) {
 	file := filepath.Join(tmpDir, "discs.json")
 	os.WriteFile(file, data, 0644)
 
-	migrateJsonDiscs(file, discdb)
-
-	if _, ok := discdb.GetDiscInfo("uuid-1"); !ok {
-		t.Fatal("uuid-1 not migrated")
+	ids := migrateJsonDiscs(file, discdb)
+
+	for _, uuid := range []string{"uuid-1", "uuid-2"} {
+		disc, ok := discdb.FindDisc(uuid, "")
+		if !ok {
+			t.Fatal(uuid, "not migrated")
+		}
+		if ids[uuid] != disc.Id {
+			t.Fatalf("migrateJsonDiscs() id of %s = %s, expected %s", uuid, ids[uuid], disc.Id)
+		}
+		if disc.Minlength != drive.DefaultMinlength {
+			t.Fatalf("migrated disc minlength = %d, expected: %d", disc.Minlength, drive.DefaultMinlength)
+		}
 	}
-	if _, ok := discdb.GetDiscInfo("uuid-2"); !ok {
-		t.Fatal("uuid-2 not migrated")
+	// both discs have the same (no) titles
+	if ids["uuid-1"] == ids["uuid-2"] {
+		t.Fatal("expected discs with the same fingerprint to keep different ids")
 	}
 
 	// Verify renamed to .bak
@@ -92,8 +88,8 @@ func TestMigrateJsonDiscs_SkipsExisting(t *testing.T) {
 	discdb := newTestDiscDB(t, db)
 	tmpDir := t.TempDir()
 
-	// Pre-populate
-	discdb.SaveDiscInfo("uuid-1", &makemkv.DiscInfo{Name: "Original"})
+	// Pre-populate, it got a label once the disc was inserted again
+	discdb.SaveDiscInfo(&drive.Disc{Id: "f1", Uuid: "uuid-1", Label: "MOVIE"}, &makemkv.DiscInfo{Name: "Original"})
 
 	discs := map[string]*makemkv.DiscInfo{
 		"uuid-1": {Name: "FromJSON"},
@@ -103,15 +99,19 @@ func TestMigrateJsonDiscs_SkipsExisting(t *testing.T) {
 	file := filepath.Join(tmpDir, "discs.json")
 	os.WriteFile(file, data, 0644)
 
-	migrateJsonDiscs(file, discdb)
+	ids := migrateJsonDiscs(file, discdb)
 
-	info, _ := discdb.GetDiscInfo("uuid-1")
-	if info.Name != "Original" {
+	info, _ := discdb.GetDiscInfo("f1")
+	if info.Name != "Original" || ids["uuid-1"] != "f1" {
 		t.Fatalf("expected existing to be preserved, got %s", info.Name)
 	}
-	if _, ok := discdb.GetDiscInfo("uuid-2"); !ok {
+	if _, ok := discdb.FindDisc("uuid-2", ""); !ok {
 		t.Fatal("uuid-2 not migrated")
 	}
+	// FindDisc only finds a disc if it's the only one with the uuid
+	if disc, ok := discdb.FindDisc("uuid-1", ""); !ok || disc.Id != "f1" {
+		t.Fatalf(`FindDisc("uuid-1", "") = %+v, %v, expected only f1`, disc, ok)
+	}
 }
 
 func TestMigrateJsonDiscs_NoFile(t *testing.T) {
@@ -156,10 +156,10 @@ func TestMigrateJsonWorkflows_ImportsAll(t *testing.T) {
 	file := filepath.Join(tmpDir, "workflows.json")
 	os.WriteFile(file, data, 0644)
 
-	migrateJsonWorkflows(file, wfm)
+	migrateJsonWorkflows(file, wfm, map[string]string{"d1": "f1"})
 
-	if wfm.GetWorkflow("d1", 0) == nil {
-		t.Fatal("d1/0 not migrated")
+	if wfm.GetWorkflow("f1", 0) == nil {
+		t.Fatal("d1/0 not migrated to its fingerprint")
 	}
 	if wfm.GetWorkflow("d2", 1) == nil {
 		t.Fatal("d2/1 not migrated")
@@ -187,7 +187,7 @@ func TestMigrateJsonWorkflows_SkipsExisting(t *testing.T) {
 	file := filepath.Join(tmpDir, "workflows.json")
 	os.WriteFile(file, data, 0644)
 
-	migrateJsonWorkflows(file, wfm)
+	migrateJsonWorkflows(file, wfm, nil)
 
 	got := wfm.GetWorkflow("d1", 0)
 	if got.Label != "EXISTING" {
@@ -199,7 +199,7 @@ func TestMigrateJsonWorkflows_NoFile(t *testing.T) {
 	db := openTestDB(t)
 	discdb := newTestDiscDB(t, db)
 	wfm := newTestWorkflowManager(t, db, discdb)
-	migrateJsonWorkflows("/nonexistent/workflows.json", wfm)
+	migrateJsonWorkflows("/nonexistent/workflows.json", wfm, nil)
 }
 
 func TestMigrateJsonWorkflows_MalformedJSON(t *testing.T) {
@@ -211,9 +211,62 @@ func TestMigrateJsonWorkflows_MalformedJSON(t *testing.T) {
 	file := filepath.Join(tmpDir, "workflows.json")
 	os.WriteFile(file, []byte("not json"), 0644)
 
-	migrateJsonWorkflows(file, wfm)
+	migrateJsonWorkflows(file, wfm, nil)
 
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		t.Fatal("malformed json file should not be renamed")
 	}
 }
+
+func TestMigrateDiscIds(t *testing.T) {
+	db := openTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE disc_info (uuid TEXT PRIMARY KEY, info_json TEXT NOT NULL)`,
+		`CREATE TABLE workflows (disc_id TEXT NOT NULL, title_id INTEGER NOT NULL, label TEXT, original_name TEXT, status TEXT, imdb_id TEXT, name TEXT, year TEXT, file_json TEXT, PRIMARY KEY(disc_id, title_id))`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	movie := &makemkv.DiscInfo{Name: "Movie", Titles: []makemkv.TitleInfo{{Id: 0, FileSize: 100}}}
+	other := &makemkv.DiscInfo{Name: "Other", Titles: []makemkv.TitleInfo{{Id: 0, FileSize: 200}}}
+	same := &makemkv.DiscInfo{Name: "Same", Titles: []makemkv.TitleInfo{{Id: 0, FileSize: 200}}}
+	for uuid, info := range map[string]*makemkv.DiscInfo{"u1": movie, "u2": other, "u3": same} {
+		b, _ := json.Marshal(info)
+		db.Exec("INSERT INTO disc_info (uuid, info_json) VALUES (?, ?)", uuid, string(b))
+	}
+	db.Exec("INSERT INTO workflows (disc_id, title_id, label, original_name, status) VALUES ('u1', 0, 'MOVIE', 'movie', 'Done')")
+
+	if err := migrateDiscIds(db); err != nil {
+		t.Fatal(err)
+	}
+	// a second run does nothing
+	if err := migrateDiscIds(db); err != nil {
+		t.Fatal(err)
+	}
+
+	discdb := newTestDiscDB(t, db)
+	wfm := newTestWorkflowManager(t, db, discdb)
+
+	id := drive.Fingerprint(movie)
+	if info, ok := discdb.GetDiscInfo(id); !ok || info.Name != "Movie" {
+		t.Fatalf("GetDiscInfo(fingerprint) = %v, %v", info, ok)
+	}
+	if found, ok := discdb.FindDisc("u1", "MOVIE"); !ok || found.Id != id {
+		t.Fatalf(`FindDisc("u1", "MOVIE") = %v, %v, expected %v`, found, ok, id)
+	}
+	if wf := wfm.GetWorkflow(id, 0); wf == nil || wf.Label != "MOVIE" {
+		t.Fatalf("GetWorkflow(fingerprint, 0) = %v, expected the migrated workflow", wf)
+	}
+	if wfm.GetWorkflow("u1", 0) != nil {
+		t.Fatal("expected the workflow to move off of the uuid")
+	}
+
+	// other and same have the same fingerprint, only one of them gets it
+	_, otherOk := discdb.GetDiscInfo("u2")
+	_, sameOk := discdb.GetDiscInfo("u3")
+	if _, ok := discdb.GetDiscInfo(drive.Fingerprint(other)); !ok || otherOk == sameOk {
+		t.Fatal("expected one of the discs with the same fingerprint to keep its uuid")
+	}
+}
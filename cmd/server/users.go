@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aravance/mkv-ripper/auth"
+)
+
+const usage = `usage: mkv-ripper [command]
+
+Runs the server when no command is given.
+
+commands:
+  useradd <username>         add a user, the password is read from stdin
+  passwd <username>          change a user's password, read from stdin
+  token <username> [name]    create an api token for a user
+`
+
+// runCommand runs a user management command and returns the exit code.
+func runCommand(db *sql.DB, args []string, stdin io.Reader, stdout io.Writer) int {
+	store, err := auth.NewSqliteAuthStore(db)
+	if err != nil {
+		fmt.Fprintln(stdout, "failed to open auth store:", err)
+		return 1
+	}
+
+	switch {
+	case len(args) == 2 && args[0] == "useradd":
+		password, err := readPassword(stdin, stdout)
+		if err != nil {
+			fmt.Fprintln(stdout, err)
+			return 1
+		}
+		if err := store.AddUser(args[1], password); err != nil {
+			fmt.Fprintln(stdout, "failed to add user:", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "added user", args[1])
+
+	case len(args) == 2 && args[0] == "passwd":
+		password, err := readPassword(stdin, stdout)
+		if err != nil {
+			fmt.Fprintln(stdout, err)
+			return 1
+		}
+		if err := store.SetPassword(args[1], password); err != nil {
+			fmt.Fprintln(stdout, "failed to set password:", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "changed password for", args[1])
+
+	case (len(args) == 2 || len(args) == 3) && args[0] == "token":
+		name := ""
+		if len(args) == 3 {
+			name = args[2]
+		}
+		token, err := store.NewToken(args[1], name)
+		if err != nil {
+			fmt.Fprintln(stdout, "failed to create token:", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, token)
+
+	default:
+		fmt.Fprint(stdout, usage)
+		return 2
+	}
+	return 0
+}
+
+func readPassword(stdin io.Reader, stdout io.Writer) (string, error) {
+	if f, ok := stdin.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(stdout, "password: ")
+		}
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password cannot be empty")
+	}
+	return password, nil
+}
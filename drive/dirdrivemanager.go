@@ -0,0 +1,294 @@
+package drive
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultScanInterval = 10 * time.Second
+
+// fileDevice is an iso or a folder backup of a disc, read by makemkv as an
+// "iso:" or "file:" source.
+type fileDevice struct {
+	path  string
+	kind  string
+	label string
+}
+
+func (d *fileDevice) Device() string {
+	return d.path
+}
+
+func (d *fileDevice) Type() string {
+	return d.kind
+}
+
+func (d *fileDevice) Available() bool {
+	info, err := os.Stat(d.path)
+	return err == nil && info.IsDir() == (d.kind == "file")
+}
+
+// NewDirDriveManager returns a DriveManager with a virtual drive for every
+// iso and disc backup folder in dir. Sources are picked up once their size
+// stops changing between scans, and moved to donedir when their drive is
+// ejected. Discs are read and onDisc and onChange are called like for
+// NewUdevDriveManager.
+func NewDirDriveManager(dir string, donedir string, interval time.Duration, discdb DiscDatabase, minlength int, onDisc func(Drive), onChange func(Drive)) DriveManager {
+	if interval <= 0 {
+		interval = DefaultScanInterval
+	}
+	return &dirDriveManager{
+		dir:       dir,
+		donedir:   donedir,
+		interval:  interval,
+		discdb:    discdb,
+		minlength: minlength,
+		drives:    make(map[string]*mkvDrive),
+		sizes:     make(map[string]int64),
+		onDisc:    onDisc,
+		onChange:  onChange,
+	}
+}
+
+type dirDriveManager struct {
+	dir       string
+	donedir   string
+	interval  time.Duration
+	discdb    DiscDatabase
+	minlength int
+	mutex     sync.Mutex
+	started   bool
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	drives    map[string]*mkvDrive
+	// sizes of the sources that are still settling, by path
+	sizes    map[string]int64
+	onDisc   func(Drive)
+	onChange func(Drive)
+}
+
+func (m *dirDriveManager) GetDrive(id string) (Drive, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d, ok := m.drives[id]
+	if !ok {
+		return nil, false
+	}
+	return d, true
+}
+
+func (m *dirDriveManager) GetDrives() []Drive {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	drives := make([]Drive, 0, len(m.drives))
+	for _, d := range m.drives {
+		drives = append(drives, d)
+	}
+	slices.SortFunc(drives, func(a, b Drive) int {
+		return strings.Compare(a.Id(), b.Id())
+	})
+	return drives
+}
+
+func (m *dirDriveManager) Start() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.started {
+		return nil
+	}
+	if err := os.MkdirAll(m.donedir, 0755); err != nil {
+		return err
+	}
+
+	m.stop = make(chan struct{})
+	m.started = true
+	m.wg.Add(1)
+	go m.run()
+	return nil
+}
+
+func (m *dirDriveManager) Stop() error {
+	m.mutex.Lock()
+	if !m.started {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.started = false
+	close(m.stop)
+	m.mutex.Unlock()
+
+	m.wg.Wait()
+	return nil
+}
+
+func (m *dirDriveManager) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.scan()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan adds a drive for every source that has settled, and removes the
+// drives of sources that are gone.
+func (m *dirDriveManager) scan() {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		log.Println("error reading watched dir", m.dir, "err:", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	var added []*mkvDrive
+	for _, e := range entries {
+		dev := sourceDevice(m.dir, e)
+		if dev == nil {
+			continue
+		}
+		seen[dev.path] = true
+		size, err := sourceSize(dev.path)
+		if err != nil {
+			log.Println("error reading source", dev.path, "err:", err)
+			continue
+		}
+
+		id := sourceId(e.Name())
+		m.mutex.Lock()
+		_, exists := m.drives[id]
+		last, settling := m.sizes[dev.path]
+		if !exists {
+			if settling && last == size {
+				delete(m.sizes, dev.path)
+				d := m.newDrive(id, dev)
+				m.drives[id] = d
+				added = append(added, d)
+			} else {
+				m.sizes[dev.path] = size
+			}
+		}
+		m.mutex.Unlock()
+	}
+
+	var removed []*mkvDrive
+	m.mutex.Lock()
+	for p := range m.sizes {
+		if !seen[p] {
+			delete(m.sizes, p)
+		}
+	}
+	for id, d := range m.drives {
+		if status := d.Status(); !seen[d.devname] && (status == StatusReady || status == StatusEmpty) {
+			delete(m.drives, id)
+			removed = append(removed, d)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, d := range added {
+		log.Println("found source", d.devname)
+		dev := d.device.(*fileDevice)
+		d.setDevice(dev, dev.label, "", 0)
+		go m.onDisc(d)
+	}
+	for _, d := range removed {
+		log.Println("source is gone", d.devname)
+		d.changed()
+	}
+}
+
+func (m *dirDriveManager) newDrive(id string, dev *fileDevice) *mkvDrive {
+	d := &mkvDrive{
+		id:        id,
+		devname:   dev.path,
+		device:    dev,
+		status:    StatusEmpty,
+		discdb:    m.discdb,
+		minlength: m.minlength,
+		autoEject: true,
+		onChange:  m.onChange,
+	}
+	d.eject = func() error {
+		done := path.Join(m.donedir, path.Base(dev.path))
+		if _, err := os.Stat(done); err == nil {
+			return &fs.PathError{Op: "move", Path: done, Err: fs.ErrExist}
+		}
+		log.Println("moving", dev.path, "to", done)
+		if err := os.Rename(dev.path, done); err != nil {
+			return err
+		}
+		d.device = nil
+		d.disc = nil
+		d.resetStatus()
+		return nil
+	}
+	return d
+}
+
+// sourceDevice returns the device for an iso or a folder with a BDMV or
+// VIDEO_TS folder in it, or nil for anything else in the watched dir.
+func sourceDevice(dir string, e fs.DirEntry) *fileDevice {
+	name := e.Name()
+	if strings.HasPrefix(name, ".") {
+		return nil
+	}
+	p := path.Join(dir, name)
+	if e.IsDir() {
+		for _, sub := range []string{"BDMV", "VIDEO_TS"} {
+			if info, err := os.Stat(path.Join(p, sub)); err == nil && info.IsDir() {
+				return &fileDevice{path: p, kind: "file", label: name}
+			}
+		}
+		return nil
+	}
+	if e.Type().IsRegular() && strings.EqualFold(path.Ext(name), ".iso") {
+		return &fileDevice{path: p, kind: "iso", label: strings.TrimSuffix(name, path.Ext(name))}
+	}
+	return nil
+}
+
+// sourceSize is the size of a file, or of all the files in a folder
+func sourceSize(p string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+var unsafeIdChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sourceId makes a drive id that is safe to use in urls
+func sourceId(name string) string {
+	return "file-" + unsafeIdChars.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,123 @@
+package drive
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDirDriveManager_Scan(t *testing.T) {
+	discdb, err := NewSqliteDiscDatabase(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	done := path.Join(dir, "done")
+	if err := os.MkdirAll(path.Join(dir, "The Matrix", "BDMV"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Join(dir, "not a disc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "SPIRITED_AWAY.iso"), []byte("iso"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "notes.txt"), []byte("txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	discs := make(chan Drive, 4)
+	m := NewDirDriveManager(dir, done, 0, discdb, DefaultMinlength, func(d Drive) { discs <- d }, nil).(*dirDriveManager)
+	if err := os.MkdirAll(done, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m.scan()
+	if drives := m.GetDrives(); len(drives) != 0 {
+		t.Fatalf("GetDrives() = %v, expected sources to settle first", drives)
+	}
+
+	// the iso is still being copied
+	if err := os.WriteFile(path.Join(dir, "SPIRITED_AWAY.iso"), []byte("iso image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.scan()
+	drives := m.GetDrives()
+	if len(drives) != 1 || drives[0].Id() != "file-The_Matrix" {
+		t.Fatalf("GetDrives() = %v, expected only the settled folder", drives)
+	}
+	<-discs
+
+	m.scan()
+	<-discs
+	drives = m.GetDrives()
+	if len(drives) != 2 {
+		t.Fatalf("len(GetDrives()) = %d, expected: 2", len(drives))
+	}
+
+	iso, ok := m.GetDrive("file-SPIRITED_AWAY.iso")
+	if !ok {
+		t.Fatal("GetDrive(file-SPIRITED_AWAY.iso) not found")
+	}
+	if iso.Status() != StatusReady || !iso.HasDisc() {
+		t.Fatalf("iso drive status = %s, expected: %s", iso.Status(), StatusReady)
+	}
+	if disc := iso.GetDisc(); disc == nil || disc.Label != "SPIRITED_AWAY" {
+		t.Fatalf("iso drive disc = %+v, expected label SPIRITED_AWAY", disc)
+	}
+	if dev := iso.(*mkvDrive).device; dev.Type() != "iso" || dev.Device() != path.Join(dir, "SPIRITED_AWAY.iso") {
+		t.Fatalf("iso drive device = %s:%s", dev.Type(), dev.Device())
+	}
+	folder, _ := m.GetDrive("file-The_Matrix")
+	if dev := folder.(*mkvDrive).device; dev.Type() != "file" {
+		t.Fatalf("folder drive device type = %s, expected: file", dev.Type())
+	}
+	if !AutoEject(iso) {
+		t.Fatal("expected file drives to be ejected when done")
+	}
+
+	if err := iso.Eject(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(done, "SPIRITED_AWAY.iso")); err != nil {
+		t.Fatalf("ejected source was not moved to done: %v", err)
+	}
+	if iso.HasDisc() || iso.Status() != StatusEmpty {
+		t.Fatalf("ejected drive status = %s, expected: %s", iso.Status(), StatusEmpty)
+	}
+
+	m.scan()
+	if _, ok := m.GetDrive("file-SPIRITED_AWAY.iso"); ok {
+		t.Fatal("expected drive of ejected source to be removed")
+	}
+	if drives := m.GetDrives(); len(drives) != 1 {
+		t.Fatalf("len(GetDrives()) = %d, expected: 1", len(drives))
+	}
+}
+
+func TestMultiDriveManager(t *testing.T) {
+	discdb, err := NewSqliteDiscDatabase(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "MOVIE.iso"), []byte("iso"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirman := NewDirDriveManager(dir, path.Join(dir, "done"), 0, discdb, DefaultMinlength, func(Drive) {}, nil)
+	dirman.(*dirDriveManager).scan()
+	dirman.(*dirDriveManager).scan()
+
+	m := NewMultiDriveManager(NewDirDriveManager(t.TempDir(), t.TempDir(), 0, discdb, DefaultMinlength, nil, nil), dirman)
+	if drives := m.GetDrives(); len(drives) != 1 || drives[0].Id() != "file-MOVIE.iso" {
+		t.Fatalf("GetDrives() = %v", drives)
+	}
+	if _, ok := m.GetDrive("file-MOVIE.iso"); !ok {
+		t.Fatal("GetDrive(file-MOVIE.iso) not found")
+	}
+	if _, ok := m.GetDrive("sr0"); ok {
+		t.Fatal("GetDrive(sr0) found a drive that doesn't exist")
+	}
+}
@@ -1,64 +1,59 @@
 package drive
 
 import (
-	"encoding/json"
-	"log"
-	"os"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/aravance/go-makemkv"
 )
 
+// DiscDatabase stores the makemkv info of discs by their id, the Fingerprint
+// of their titles. Filesystem uuids, labels and media sizes are also stored,
+// but only to find a disc again without reading it, some discs share or don't
+// have them.
 type DiscDatabase interface {
-	GetDiscInfo(string) (info *makemkv.DiscInfo, ok bool)
-	SaveDiscInfo(string, *makemkv.DiscInfo) error
+	GetDiscInfo(id string) (info *makemkv.DiscInfo, ok bool)
+	// GetDisc returns the disc stored under id.
+	GetDisc(id string) (disc Disc, ok bool)
+	// SaveDiscInfo stores the info under the id of the disc, and remembers
+	// its uuid and label.
+	SaveDiscInfo(disc *Disc, info *makemkv.DiscInfo) error
+	// FindDisc returns the disc with this uuid and label, if only one disc
+	// has them. An empty label matches discs with any label.
+	FindDisc(uuid string, label string) (disc Disc, ok bool)
 }
 
-func NewJsonDiscDatabase(file string) DiscDatabase {
-	discInfoMap, err := loadDiscInfoJson(file)
-	if err != nil {
-		log.Printf("failed to load previous disc info")
-		discInfoMap = make(map[string]*makemkv.DiscInfo)
+// Fingerprint identifies a pressing of a disc by the layout of its titles.
+// Only its longest titles count, and not their ids, so that the disc has the
+// same fingerprint whatever minlength it was read with, up to EpisodeMinlength,
+// or up to DefaultMinlength if it has a title that long.
+func Fingerprint(info *makemkv.DiscInfo) string {
+	h := sha256.New()
+	for _, t := range fingerprintTitles(info).Titles {
+		fmt.Fprintf(h, "%d|%d|%s|%v\n", t.Duration, t.FileSize, t.SourceFileName, t.Segments)
 	}
-	return &jsonDiscDatabase{
-		file,
-		discInfoMap,
-	}
-}
-
-type jsonDiscDatabase struct {
-	file        string
-	discInfoMap map[string]*makemkv.DiscInfo
-}
-
-func (d *jsonDiscDatabase) GetDiscInfo(id string) (info *makemkv.DiscInfo, ok bool) {
-	info, ok = d.discInfoMap[id]
-	return info, ok
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-func (d *jsonDiscDatabase) SaveDiscInfo(id string, info *makemkv.DiscInfo) error {
-	d.discInfoMap[id] = info
-
-	if bytes, err := json.Marshal(d.discInfoMap); err != nil {
-		return err
-	} else if err := os.WriteFile(d.file, bytes, 0644); err != nil {
-		return err
-	} else {
-		return nil
+// fingerprintTitles are the titles at least DefaultMinlength long, or at least
+// EpisodeMinlength on discs without any, or else all of them.
+func fingerprintTitles(info *makemkv.DiscInfo) *makemkv.DiscInfo {
+	for _, minlength := range []int{DefaultMinlength, EpisodeMinlength} {
+		if long := (RipProfile{Minlength: minlength}).Titles(info); len(long.Titles) > 0 {
+			return long
+		}
 	}
+	return info
 }
 
-func loadDiscInfoJson(file string) (infomap map[string]*makemkv.DiscInfo, err error) {
-	bytes, err := os.ReadFile(file)
-	if err != nil {
-		log.Println("Failed to read file:", file, err)
-		return nil, err
-	}
-
-	err = json.Unmarshal(bytes, &infomap)
-	if err != nil {
-		log.Println("failed to unmarshal json:", file, err)
-		return nil, err
-	}
+// genericLabels are volume labels authoring tools leave by default, they say
+// nothing about the disc.
+var genericLabels = []string{"", "BDROM", "BD_ROM", "BLURAY", "CDROM", "DISC", "DVD", "DVDVOLUME", "DVD_VIDEO", "LOGICAL_VOLUME_ID", "VIDEO_TS"}
 
-	return infomap, nil
+// GenericLabel reports whether label is a default that many discs share.
+func GenericLabel(label string) bool {
+	return slices.Contains(genericLabels, strings.ToUpper(strings.TrimSpace(label)))
 }
@@ -1,10 +1,12 @@
 package drive
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 
@@ -23,26 +25,74 @@ const (
 )
 
 type Disc struct {
-	Label     string
-	Uuid      string
-	MkvStatus *makemkv.Status
+	// Id is the Fingerprint of the disc, it is empty until the disc has been
+	// read or found in the DiscDatabase.
+	Id    string
+	Label string
+	Uuid  string
+	// Minlength is the minlength makemkv first read the disc with, title ids
+	// only match when ripping with the same one.
+	Minlength int
+	// Size is the size of the media in bytes, 0 if it's not known. It's
+	// checked along with the uuid and label to find a disc without reading it.
+	Size int64
 }
 
-type DriveManager interface {
+type Drive interface {
+	Id() string
+	Device() string
 	Eject() error
+	// GetDiscInfo reads the titles of the disc, and sets the id of the disc
+	// from them. A disc seen before keeps the titles it was first read with.
 	GetDiscInfo() (*makemkv.DiscInfo, error)
 	GetDisc() *Disc
 	HasDisc() bool
+	Status() DriveStatus
+	// RipFile rips a title with the makemkv options of profile. The disc is
+	// always read with the minlength it was read with for its info.
+	RipFile(ctx context.Context, title *makemkv.TitleInfo, outdir string, profile RipProfile, outchan chan makemkv.Status) (*model.MkvFile, error)
+	// Backup copies the whole disc into a folder in outdir, and returns the
+	// path of the folder.
+	Backup(ctx context.Context, outdir string, profile RipProfile, outchan chan makemkv.Status) (string, error)
+}
+
+type DriveManager interface {
 	Start() error
 	Stop() error
-	Status() DriveStatus
-	RipFile(title *makemkv.TitleInfo, outdir string, outchan chan makemkv.Status) (*model.MkvFile, error)
+	GetDrive(id string) (Drive, bool)
+	GetDrives() []Drive
 }
 
-func NewUdevDriveManager(onDisc func(DriveManager)) DriveManager {
+// FindDisc returns the drive that currently holds the disc with the given id,
+// or nil if the disc is not in any drive.
+func FindDisc(m DriveManager, discId string) Drive {
+	for _, d := range m.GetDrives() {
+		if disc := d.GetDisc(); disc != nil && disc.Id != "" && disc.Id == discId {
+			return d
+		}
+	}
+	return nil
+}
+
+// AutoEject reports whether d is ejected once its queued rips are done, even
+// without the AutoEject config. Drives of a DirDriveManager are, so their
+// sources are moved to the done folder.
+func AutoEject(d Drive) bool {
+	md, ok := d.(*mkvDrive)
+	return ok && md.autoEject
+}
+
+// NewUdevDriveManager returns a DriveManager for the optical drives found by
+// udev. onDisc is called when a disc is inserted or removed, onChange whenever
+// the status of a drive changes. Inserted discs are looked up in discdb, and
+// read with minlength, the shortest title in seconds makemkv should find.
+func NewUdevDriveManager(discdb DiscDatabase, minlength int, onDisc func(Drive), onChange func(Drive)) DriveManager {
 	m := driveManager{
-		status: StatusEmpty,
-		onDisc: onDisc,
+		discdb:    discdb,
+		minlength: minlength,
+		drives:    make(map[string]*mkvDrive),
+		onDisc:    onDisc,
+		onChange:  onChange,
 	}
 	m.udevListener = newUdevListener(m.onDevice)
 	return &m
@@ -50,60 +100,183 @@ func NewUdevDriveManager(onDisc func(DriveManager)) DriveManager {
 
 type driveManager struct {
 	udevListener *udevListener
+	discdb       DiscDatabase
+	minlength    int
 	mutex        sync.Mutex
 	started      bool
-	device       *udevDevice
-	status       DriveStatus
-	disc         *Disc
-	onDisc       func(DriveManager)
+	drives       map[string]*mkvDrive
+	onDisc       func(Drive)
+	onChange     func(Drive)
 }
 
-func (m *driveManager) Status() DriveStatus {
-	return m.status
+func (m *driveManager) GetDrive(id string) (Drive, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d, ok := m.drives[id]
+	if !ok {
+		return nil, false
+	}
+	return d, true
 }
 
-func (m *driveManager) GetDisc() *Disc {
-	return m.disc
+func (m *driveManager) GetDrives() []Drive {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	drives := make([]Drive, 0, len(m.drives))
+	for _, d := range m.drives {
+		drives = append(drives, d)
+	}
+	slices.SortFunc(drives, func(a, b Drive) int {
+		return strings.Compare(a.Id(), b.Id())
+	})
+	return drives
 }
 
-func (m *driveManager) GetDiscInfo() (*makemkv.DiscInfo, error) {
-	if !m.HasDisc() {
-		return nil, fmt.Errorf("no disc available")
+func (m *driveManager) Start() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.started {
+		return nil
 	}
 
-	if m.Status() != StatusReady {
-		return nil, fmt.Errorf("drive is busy")
+	m.udevListener.Start()
+	m.started = true
+	return nil
+}
+
+func (m *driveManager) onDevice(dev device) {
+	m.mutex.Lock()
+	d, ok := m.drives[dev.Id()]
+	if !ok {
+		d = &mkvDrive{
+			id:        dev.Id(),
+			devname:   dev.Device(),
+			status:    StatusEmpty,
+			discdb:    m.discdb,
+			minlength: m.minlength,
+			onChange:  m.onChange,
+		}
+		d.eject = func() error {
+			return ejectDevice(d.devname)
+		}
+		m.drives[dev.Id()] = d
 	}
+	m.mutex.Unlock()
+
+	if dev.Available() {
+		d.setDevice(dev, dev.Label(), dev.Uuid(), dev.Size())
+	} else {
+		d.setDevice(nil, "", "", 0)
+	}
+	go m.onDisc(d)
+}
+
+func (m *driveManager) Stop() error {
+	m.udevListener.Stop()
+	return nil
+}
+
+// mkvDrive runs makemkv against a device, either an optical drive or a file
+// in a watched directory. eject is called with the drive idle and locked.
+type mkvDrive struct {
+	id        string
+	devname   string
+	mutex     sync.Mutex
+	device    makemkv.Device
+	status    DriveStatus
+	disc      *Disc
+	discdb    DiscDatabase
+	minlength int
+	eject     func() error
+	autoEject bool
+	onChange  func(Drive)
+}
+
+func (d *mkvDrive) Id() string {
+	return d.id
+}
+
+func (d *mkvDrive) Device() string {
+	return d.devname
+}
+
+func (d *mkvDrive) Status() DriveStatus {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.status
+}
+
+func (d *mkvDrive) GetDisc() *Disc {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.disc
+}
+
+func (d *mkvDrive) HasDisc() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
-	if err := m.setBusy(StatusReading); err != nil {
+	return d.device != nil && d.device.Available()
+}
+
+func (d *mkvDrive) GetDiscInfo() (*makemkv.DiscInfo, error) {
+	dev, err := d.setBusy(StatusReading)
+	if err != nil {
 		return nil, err
 	}
-	defer m.setIdle()
+	defer d.setIdle()
 
-	job := makemkv.Info(m.device, makemkv.MkvOptions{
-		Minlength: makemkv.Intopt(3600),
+	job := makemkv.Info(dev, makemkv.MkvOptions{
+		Minlength: makemkv.Intopt(d.minlength),
 	})
-	if info, err := job.Run(); err != nil {
+	info, err := job.Run()
+	if err != nil {
 		log.Println("error running makemkv info", err)
 		return info, err
-	} else {
-		return info, nil
 	}
-}
 
-func (m *driveManager) RipFile(title *makemkv.TitleInfo, outdir string, statchan chan makemkv.Status) (*model.MkvFile, error) {
-	if m.device == nil || !m.device.Available() {
-		return nil, fmt.Errorf("no device available")
+	id := Fingerprint(info)
+	info, minlength := d.knownInfo(id, info)
+	d.mutex.Lock()
+	if d.disc != nil && d.device == dev {
+		// copied, so discs that were already handed out don't change
+		disc := *d.disc
+		disc.Id = id
+		disc.Minlength = minlength
+		d.disc = &disc
 	}
+	d.mutex.Unlock()
+	return info, nil
+}
 
-	if m.Status() != StatusReady {
-		return nil, fmt.Errorf("drive is busy")
+// knownInfo returns the info and minlength a disc was first read with if it's
+// been seen before, so that the title ids of its workflows still match, or
+// else info as it was just read.
+func (d *mkvDrive) knownInfo(id string, info *makemkv.DiscInfo) (*makemkv.DiscInfo, int) {
+	if disc, ok := d.discdb.GetDisc(id); ok {
+		if known, ok := d.discdb.GetDiscInfo(id); ok {
+			return known, disc.Minlength
+		}
 	}
+	return info, d.minlength
+}
 
-	if err := m.setBusy(StatusMkv); err != nil {
+func (d *mkvDrive) RipFile(ctx context.Context, title *makemkv.TitleInfo, outdir string, profile RipProfile, statchan chan makemkv.Status) (*model.MkvFile, error) {
+	dev, err := d.setBusy(StatusMkv)
+	if err != nil {
 		return nil, err
 	}
-	defer m.setIdle()
+	defer d.setIdle()
+
+	minlength := d.minlength
+	if disc := d.GetDisc(); disc != nil && disc.Id != "" {
+		minlength = disc.Minlength
+	}
 
 	ripdir, err := os.MkdirTemp(outdir, ".rip")
 	if err != nil {
@@ -112,17 +285,15 @@ func (m *driveManager) RipFile(title *makemkv.TitleInfo, outdir string, statchan
 	}
 	defer os.RemoveAll(ripdir)
 
-	opts := makemkv.MkvOptions{
-		Progress:  makemkv.Stropt("-same"),
-		Minlength: makemkv.Intopt(3600),
-		Noscan:    true,
+	profileFile, err := profile.profileFile(ripdir)
+	if err != nil {
+		log.Println("failed to write makemkv profile", err)
+		return nil, err
 	}
-	log.Println("starting makemkv")
-	mkvjob := makemkv.Mkv(m.device, title.Id, ripdir, opts)
-	mkvjob.Statuschan = statchan
 
-	if err := mkvjob.Run(); err != nil {
-		log.Println("error ripping device", err)
+	log.Println("starting makemkv on", d.id, "with profile", profile.Name)
+	if err := runMakemkv(ctx, statchan, mkvArgs(dev, title.Id, ripdir, minlength, profile.Cache, profileFile)...); err != nil {
+		log.Println("error ripping device", d.id, err)
 		return nil, err
 	}
 
@@ -152,74 +323,141 @@ func (m *driveManager) RipFile(title *makemkv.TitleInfo, outdir string, statchan
 		}
 	}
 
+	var codec, audioFormat string
+	if len(title.VideoStreams) > 0 {
+		codec = title.VideoStreams[0].CodecShort
+	}
+	if len(title.AudioStreams) > 0 {
+		audioFormat = title.AudioStreams[0].CodecShort
+	}
+
 	return &model.MkvFile{
-		Filename:   newfile,
-		Shasum:     shasum,
-		Resolution: resolution,
+		Filename:    newfile,
+		Shasum:      shasum,
+		Resolution:  resolution,
+		Codec:       codec,
+		AudioFormat: audioFormat,
 	}, nil
 }
 
-func (m *driveManager) Start() error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func (d *mkvDrive) Backup(ctx context.Context, outdir string, profile RipProfile, statchan chan makemkv.Status) (string, error) {
+	dev, err := d.setBusy(StatusMkv)
+	if err != nil {
+		return "", err
+	}
+	defer d.setIdle()
 
-	if m.started {
-		return nil
+	ripdir, err := os.MkdirTemp(outdir, ".backup")
+	if err != nil {
+		log.Println("failed to make temp dir", err)
+		return "", err
 	}
 
-	m.udevListener.Start()
-	m.started = true
-	return nil
-}
+	log.Println("starting makemkv backup on", d.id)
+	if err := runMakemkv(ctx, statchan, backupArgs(dev, ripdir, profile.Decrypt, profile.Cache)...); err != nil {
+		log.Println("error backing up device", d.id, err)
+		os.RemoveAll(ripdir)
+		return "", err
+	}
 
-func (m *driveManager) onDevice(dev *udevDevice) {
-	if !dev.Available() {
-		m.device = nil
-		m.disc = nil
-	} else {
-		m.device = dev
-		m.disc = &Disc{
-			Label: dev.Label(),
-			Uuid:  dev.Uuid(),
-		}
+	backupdir := path.Join(outdir, "backup")
+	os.RemoveAll(backupdir)
+	if err := os.Rename(ripdir, backupdir); err != nil {
+		os.RemoveAll(ripdir)
+		return "", err
 	}
-	m.setIdle()
-	go m.onDisc(m)
+	return backupdir, nil
 }
 
-func (m *driveManager) Stop() error {
-	m.udevListener.Stop()
+func (d *mkvDrive) Eject() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.status == StatusMkv || d.status == StatusReading {
+		return fmt.Errorf("drive is busy")
+	}
+
+	log.Println("ejecting", d.id)
+	if err := d.eject(); err != nil {
+		log.Println("error ejecting", d.id, err)
+		return err
+	}
 	return nil
 }
 
-func (m *driveManager) Eject() error {
-	panic("unimplemented")
+// setDevice puts a disc in the drive, or empties it for a nil dev. The disc
+// keeps the id of a known disc with the same uuid, label and size, otherwise
+// it has to be read.
+func (d *mkvDrive) setDevice(dev makemkv.Device, label string, uuid string, size int64) {
+	defer d.changed()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if dev == nil {
+		d.device = nil
+		d.disc = nil
+	} else {
+		d.device = dev
+		d.disc = &Disc{
+			Label: label,
+			Uuid:  uuid,
+			Size:  size,
+		}
+		// some discs share a uuid and label, so they're only a hint. Generic
+		// labels are too common to trust at all.
+		if found, ok := d.discdb.FindDisc(uuid, label); ok && size > 0 && found.Size == size && !GenericLabel(label) {
+			d.disc.Id = found.Id
+			d.disc.Minlength = found.Minlength
+		}
+	}
+	d.resetStatus()
 }
 
-func (m *driveManager) HasDisc() bool {
-	return m.device != nil && m.device.Available()
-}
+// setBusy marks the drive busy with the given status and returns the device
+// to run makemkv against.
+func (d *mkvDrive) setBusy(s DriveStatus) (makemkv.Device, error) {
+	defer d.changed()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
-func (m *driveManager) setBusy(s DriveStatus) error {
-	switch m.status {
+	if d.device == nil || !d.device.Available() {
+		return nil, fmt.Errorf("no disc available")
+	}
+
+	switch d.status {
 	case StatusReady:
-		m.status = s
-		return nil
+		d.status = s
+		return d.device, nil
 	case StatusEmpty:
-		return fmt.Errorf("drive is empty")
+		return nil, fmt.Errorf("drive is empty")
 	case StatusMkv:
 		fallthrough
 	case StatusReading:
-		return fmt.Errorf("drive is busy")
+		return nil, fmt.Errorf("drive is busy")
 	default:
-		return fmt.Errorf("unknown drive status")
+		return nil, fmt.Errorf("unknown drive status")
+	}
+}
+
+func (d *mkvDrive) setIdle() {
+	defer d.changed()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.resetStatus()
+}
+
+// changed must be called without holding the mutex
+func (d *mkvDrive) changed() {
+	if d.onChange != nil {
+		d.onChange(d)
 	}
 }
 
-func (m *driveManager) setIdle() {
-	if m.device != nil && m.device.Available() {
-		m.status = StatusReady
+func (d *mkvDrive) resetStatus() {
+	if d.device != nil && d.device.Available() {
+		d.status = StatusReady
 	} else {
-		m.status = StatusEmpty
+		d.status = StatusEmpty
 	}
 }
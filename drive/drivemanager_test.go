@@ -0,0 +1,194 @@
+package drive
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+)
+
+// Mock device, with a disc in it unless it's empty
+type testDevice struct {
+	devname string
+	label   string
+	empty   bool
+}
+
+func (d testDevice) Id() string      { return path.Base(d.devname) }
+func (d testDevice) Device() string  { return d.devname }
+func (d testDevice) Type() string    { return "dev" }
+func (d testDevice) Available() bool { return !d.empty }
+func (d testDevice) Label() string   { return d.label }
+func (d testDevice) Uuid() string    { return "" }
+func (d testDevice) Size() int64     { return 0 }
+
+func TestMkvDrive_SetDevice(t *testing.T) {
+	discdb, err := NewSqliteDiscDatabase(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	discdb.SaveDiscInfo(&Disc{Id: "f1", Uuid: "u1", Label: "MOVIE", Minlength: 120, Size: 4096}, &makemkv.DiscInfo{Name: "Movie"})
+	discdb.SaveDiscInfo(&Disc{Id: "f2", Uuid: "u2", Label: "DVD_VIDEO", Size: 4096}, &makemkv.DiscInfo{Name: "Generic"})
+	// read before sizes were stored
+	discdb.SaveDiscInfo(&Disc{Id: "f3", Uuid: "u3", Label: "OLD"}, &makemkv.DiscInfo{Name: "Old"})
+
+	tests := []struct {
+		uuid, label string
+		size        int64
+		expected    string
+	}{
+		{"u1", "MOVIE", 4096, "f1"},
+		// another disc with the same uuid and label
+		{"u1", "MOVIE", 8192, ""},
+		{"u1", "MOVIE", 0, ""},
+		{"u2", "DVD_VIDEO", 4096, ""},
+		{"u3", "OLD", 4096, ""},
+	}
+	for _, test := range tests {
+		d := &mkvDrive{id: "sr0", devname: "/dev/sr0", discdb: discdb, minlength: DefaultMinlength}
+		d.setDevice(testDevice{devname: "/dev/sr0"}, test.label, test.uuid, test.size)
+		disc := d.GetDisc()
+		if disc == nil || disc.Id != test.expected {
+			t.Errorf("setDevice(%q, %q, %d) disc = %+v, expected id %q", test.uuid, test.label, test.size, disc, test.expected)
+		}
+		if test.expected != "" && disc.Minlength != 120 {
+			t.Errorf("setDevice(%q, %q, %d) minlength = %d, expected: 120", test.uuid, test.label, test.size, disc.Minlength)
+		}
+		if d.Status() != StatusReady {
+			t.Errorf("setDevice(%q, %q, %d) status = %s, expected: %s", test.uuid, test.label, test.size, d.Status(), StatusReady)
+		}
+	}
+}
+
+func TestDriveManager_OnDevice(t *testing.T) {
+	discdb, err := NewSqliteDiscDatabase(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	discs := make(chan Drive, 10)
+	m := &driveManager{
+		discdb:    discdb,
+		minlength: DefaultMinlength,
+		drives:    make(map[string]*mkvDrive),
+		onDisc:    func(d Drive) { discs <- d },
+	}
+
+	// expect checks which of the drives have a disc in them, with its label
+	expect := func(step string, labels map[string]string) {
+		t.Helper()
+		if drives := m.GetDrives(); len(drives) != len(labels) {
+			t.Fatalf("%s: %d drives, expected: %d", step, len(drives), len(labels))
+		}
+		for id, label := range labels {
+			d, ok := m.GetDrive(id)
+			if !ok {
+				t.Fatalf("%s: no drive %s", step, id)
+			}
+			disc := d.GetDisc()
+			if hasDisc := label != ""; d.HasDisc() != hasDisc || (disc != nil) != hasDisc {
+				t.Fatalf("%s: drive %s HasDisc() = %v, disc = %+v, expected a disc: %v", step, id, d.HasDisc(), disc, hasDisc)
+			}
+			if disc != nil && disc.Label != label {
+				t.Fatalf("%s: drive %s label = %q, expected: %q", step, id, disc.Label, label)
+			}
+		}
+	}
+
+	m.onDevice(testDevice{devname: "/dev/sr0", label: "MOVIE"})
+	expect("sr0 added", map[string]string{"sr0": "MOVIE"})
+	m.onDevice(testDevice{devname: "/dev/sr1", label: "SERIES"})
+	expect("sr1 added", map[string]string{"sr0": "MOVIE", "sr1": "SERIES"})
+
+	m.onDevice(testDevice{devname: "/dev/sr0", empty: true})
+	expect("sr0 removed", map[string]string{"sr0": "", "sr1": "SERIES"})
+	m.onDevice(testDevice{devname: "/dev/sr0", label: "OTHER"})
+	expect("sr0 changed", map[string]string{"sr0": "OTHER", "sr1": "SERIES"})
+	m.onDevice(testDevice{devname: "/dev/sr1", empty: true})
+	expect("sr1 removed", map[string]string{"sr0": "OTHER", "sr1": ""})
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-discs:
+		case <-time.After(time.Second):
+			t.Fatalf("onDisc called %d times, expected: 5", i)
+		}
+	}
+}
+
+// readAt is info as makemkv reports it when reading with minlength, which
+// numbers only the titles it finds.
+func readAt(info *makemkv.DiscInfo, minlength int) *makemkv.DiscInfo {
+	read := RipProfile{Minlength: minlength}.Titles(info)
+	for i := range read.Titles {
+		read.Titles[i].Id = i
+	}
+	return read
+}
+
+func TestFingerprint(t *testing.T) {
+	movie := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Duration: 3 * time.Minute, FileSize: 100, SourceFileName: "00010.mpls", Segments: []int{10}},
+		{Duration: 20 * time.Minute, FileSize: 1000, SourceFileName: "00020.mpls", Segments: []int{20}},
+		{Duration: 2 * time.Hour, FileSize: 30000, SourceFileName: "00800.mpls", Segments: []int{1, 2, 3}},
+	}}
+	series := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Duration: 5 * time.Minute, FileSize: 100, SourceFileName: "00010.mpls", Segments: []int{10}},
+		{Duration: 45 * time.Minute, FileSize: 8000, SourceFileName: "00001.mpls", Segments: []int{1}},
+		{Duration: 44 * time.Minute, FileSize: 7900, SourceFileName: "00002.mpls", Segments: []int{2}},
+	}}
+
+	for _, minlength := range []int{0, 120, EpisodeMinlength, DefaultMinlength} {
+		if got, expected := Fingerprint(readAt(movie, minlength)), Fingerprint(movie); got != expected {
+			t.Errorf("Fingerprint(movie read at %d) = %s, expected: %s", minlength, got, expected)
+		}
+	}
+	for _, minlength := range []int{0, 120, EpisodeMinlength} {
+		if got, expected := Fingerprint(readAt(series, minlength)), Fingerprint(series); got != expected {
+			t.Errorf("Fingerprint(series read at %d) = %s, expected: %s", minlength, got, expected)
+		}
+	}
+	if Fingerprint(movie) == Fingerprint(series) {
+		t.Error("expected different discs to have different fingerprints")
+	}
+}
+
+func TestMkvDrive_KnownInfo(t *testing.T) {
+	discdb, err := NewSqliteDiscDatabase(openTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &makemkv.DiscInfo{Name: "Movie", Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 2 * time.Hour, FileSize: 30000, SourceFileName: "00800.mpls"},
+	}}
+	id := Fingerprint(first)
+	discdb.SaveDiscInfo(&Disc{Id: id, Minlength: DefaultMinlength}, first)
+
+	// read again with extras, the titles it was first read with are kept
+	again := &makemkv.DiscInfo{Name: "Movie", Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 5 * time.Minute, FileSize: 100, SourceFileName: "00010.mpls"},
+		{Id: 1, Duration: 2 * time.Hour, FileSize: 30000, SourceFileName: "00800.mpls"},
+	}}
+	d := &mkvDrive{id: "sr0", discdb: discdb, minlength: 120}
+	if info, minlength := d.knownInfo(Fingerprint(again), again); info != first || minlength != DefaultMinlength {
+		t.Fatalf("knownInfo(again) = %+v, %d, expected the first read at %d", info, minlength, DefaultMinlength)
+	}
+
+	other := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{{Id: 0, Duration: 90 * time.Minute, FileSize: 20000}}}
+	if info, minlength := d.knownInfo(Fingerprint(other), other); info != other || minlength != 120 {
+		t.Fatalf("knownInfo(other) = %+v, %d, expected it as read at 120", info, minlength)
+	}
+}
+
+func TestGenericLabel(t *testing.T) {
+	for _, label := range []string{"", "DVD_VIDEO", "bluray", "BDROM "} {
+		if !GenericLabel(label) {
+			t.Errorf("GenericLabel(%q) = false, expected: true", label)
+		}
+	}
+	for _, label := range []string{"MOVIE", "THE_MATRIX_D1"} {
+		if GenericLabel(label) {
+			t.Errorf("GenericLabel(%q) = true, expected: false", label)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package drive
+
+import (
+	"os"
+	"syscall"
+)
+
+// CDROMEJECT from linux/cdrom.h
+const cdromEject = 0x5309
+
+func ejectDevice(devname string) error {
+	f, err := os.OpenFile(devname, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), cdromEject, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package drive
+
+import (
+	"errors"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestEjectDevice(t *testing.T) {
+	// a regular file opens fine, but isn't a cdrom for the ioctl
+	file := path.Join(t.TempDir(), "sr0")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ejectDevice(file); !errors.Is(err, syscall.ENOTTY) {
+		t.Fatalf("ejectDevice(file) = %v, expected: %v", err, syscall.ENOTTY)
+	}
+
+	if err := ejectDevice(path.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("ejectDevice(missing) = %v, expected: %v", err, os.ErrNotExist)
+	}
+}
+
+func TestMkvDrive_Eject(t *testing.T) {
+	ejected := 0
+	d := &mkvDrive{id: "sr0", devname: "/dev/sr0", eject: func() error {
+		ejected++
+		return nil
+	}}
+
+	d.status = StatusMkv
+	if err := d.Eject(); err == nil || ejected != 0 {
+		t.Fatalf("Eject() of a busy drive = %v, ejected %d times, expected an error", err, ejected)
+	}
+
+	d.status = StatusReady
+	if err := d.Eject(); err != nil || ejected != 1 {
+		t.Fatalf("Eject() = %v, ejected %d times, expected: 1", err, ejected)
+	}
+}
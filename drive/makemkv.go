@@ -0,0 +1,113 @@
+package drive
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/aravance/go-makemkv"
+)
+
+// runMakemkv runs makemkvcon with the given arguments and forwards its
+// progress to statchan. Unlike the jobs in go-makemkv, the process is killed
+// when ctx is cancelled.
+func runMakemkv(ctx context.Context, statchan chan makemkv.Status, args ...string) error {
+	cmd := exec.CommandContext(ctx, "makemkvcon", append([]string{"-r"}, args...)...)
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var title string
+	var channel string
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		prefix, content, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		parts := strings.Split(content, ",")
+		switch prefix {
+		case "PRGT":
+			if len(parts) > 2 {
+				title = parts[2]
+			}
+		case "PRGC":
+			if len(parts) > 2 {
+				channel = parts[2]
+			}
+		case "PRGV":
+			if len(parts) < 3 || statchan == nil {
+				continue
+			}
+			current, _ := strconv.Atoi(parts[0])
+			total, _ := strconv.Atoi(parts[1])
+			max, _ := strconv.Atoi(parts[2])
+			select {
+			case statchan <- makemkv.Status{
+				Title:   title,
+				Channel: channel,
+				Current: current,
+				Total:   total,
+				Max:     max,
+			}:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// mkvArgs are the arguments to rip a title, cache is in MB and profile is a
+// makemkv profile file, either can be left out with a zero value.
+func mkvArgs(dev makemkv.Device, titleId int, destination string, minlength int, cache int, profile string) []string {
+	args := []string{
+		"--progress=-same",
+		"--minlength=" + strconv.Itoa(minlength),
+		"--noscan",
+	}
+	if cache > 0 {
+		args = append(args, "--cache="+strconv.Itoa(cache))
+	}
+	if profile != "" {
+		args = append(args, "--profile="+profile)
+	}
+	return append(args,
+		"mkv",
+		dev.Type()+":"+dev.Device(),
+		strconv.Itoa(titleId),
+		destination,
+	)
+}
+
+func backupArgs(dev makemkv.Device, destination string, decrypt bool, cache int) []string {
+	args := []string{
+		"--progress=-same",
+		"--noscan",
+	}
+	if decrypt {
+		args = append(args, "--decrypt")
+	}
+	if cache > 0 {
+		args = append(args, "--cache="+strconv.Itoa(cache))
+	}
+	return append(args,
+		"backup",
+		dev.Type()+":"+dev.Device(),
+		destination,
+	)
+}
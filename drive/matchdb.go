@@ -0,0 +1,157 @@
+package drive
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+)
+
+// DiscMatch is the movie a user picked or confirmed for a title of a disc.
+type DiscMatch struct {
+	Label       string
+	Fingerprint string
+	TitleId     int
+	Ids         metadata.Ids
+	Updated     time.Time
+}
+
+// MatchDatabase remembers the movies users picked for discs, so the next copy
+// of the same disc is matched without asking.
+type MatchDatabase interface {
+	// GetMatch returns the match for the disc with this fingerprint, or the
+	// latest match for any disc with this label.
+	GetMatch(label string, fingerprint string) (*DiscMatch, bool)
+	SaveMatch(m DiscMatch) error
+}
+
+// FindMatch returns what a user picked for this disc before. Matches of the
+// same pressing keep their title, other pressings with the same label use
+// their best guess at the main title.
+func FindMatch(matches MatchDatabase, label string, info *makemkv.DiscInfo) (*DiscMatch, bool) {
+	fingerprint := Fingerprint(info)
+	m, ok := matches.GetMatch(label, fingerprint)
+	if !ok {
+		return nil, false
+	}
+	if m.Fingerprint == fingerprint && m.TitleId < len(info.Titles) {
+		return m, true
+	}
+	main := util.GuessMainTitle(info)
+	if main == nil {
+		return nil, false
+	}
+	match := *m
+	match.TitleId = main.Id
+	return &match, true
+}
+
+// LabelMatchConfidence is the confidence of a match found only by the label
+// of a disc. Labels like DVD_VIDEO are shared by unrelated discs, so it's low
+// enough to hold the match for review.
+const LabelMatchConfidence = model.ReviewConfidence / 2
+
+// MatchConfidence is the confidence to give a match FindMatch returned for
+// info, nil if it's the same pressing and trusted.
+func MatchConfidence(m *DiscMatch, info *makemkv.DiscInfo) *float64 {
+	if m.Fingerprint == Fingerprint(info) {
+		return nil
+	}
+	confidence := LabelMatchConfidence
+	return &confidence
+}
+
+func NewSqliteMatchDatabase(db *sql.DB) (MatchDatabase, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS disc_matches (
+		fingerprint TEXT PRIMARY KEY,
+		label TEXT NOT NULL,
+		title_id INTEGER NOT NULL,
+		imdb_id TEXT,
+		tmdb_id TEXT,
+		updated INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[string]*DiscMatch)
+
+	rows, err := db.Query("SELECT fingerprint, label, title_id, imdb_id, tmdb_id, updated FROM disc_matches")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m DiscMatch
+		var imdbId, tmdbId sql.NullString
+		var updated int64
+		if err := rows.Scan(&m.Fingerprint, &m.Label, &m.TitleId, &imdbId, &tmdbId, &updated); err != nil {
+			log.Println("error scanning disc_matches row:", err)
+			continue
+		}
+		m.Ids = metadata.Ids{Imdb: imdbId.String, Tmdb: tmdbId.String}
+		m.Updated = time.Unix(updated, 0)
+		matches[m.Fingerprint] = &m
+	}
+
+	return &sqliteMatchDatabase{db: db, matches: matches}, nil
+}
+
+type sqliteMatchDatabase struct {
+	mutex   sync.RWMutex
+	db      *sql.DB
+	matches map[string]*DiscMatch
+}
+
+func (d *sqliteMatchDatabase) GetMatch(label string, fingerprint string) (*DiscMatch, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if m, ok := d.matches[fingerprint]; ok {
+		return m, true
+	}
+	if label == "" {
+		return nil, false
+	}
+	var latest *DiscMatch
+	for _, m := range d.matches {
+		if m.Label == label && (latest == nil || m.Updated.After(latest.Updated)) {
+			latest = m
+		}
+	}
+	return latest, latest != nil
+}
+
+func (d *sqliteMatchDatabase) SaveMatch(m DiscMatch) error {
+	if m.Updated.IsZero() {
+		m.Updated = time.Now()
+	}
+	_, err := d.db.Exec(
+		`INSERT INTO disc_matches (fingerprint, label, title_id, imdb_id, tmdb_id, updated) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(fingerprint) DO UPDATE SET
+			label = excluded.label,
+			title_id = excluded.title_id,
+			imdb_id = excluded.imdb_id,
+			tmdb_id = excluded.tmdb_id,
+			updated = excluded.updated`,
+		m.Fingerprint, m.Label, m.TitleId, nullString(m.Ids.Imdb), nullString(m.Ids.Tmdb), m.Updated.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.matches[m.Fingerprint] = &m
+	d.mutex.Unlock()
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,118 @@
+package drive
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
+)
+
+func TestSqliteMatchDatabase_SaveAndGet(t *testing.T) {
+	db := openTestDB(t)
+	matches, err := NewSqliteMatchDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := DiscMatch{Label: "MOVIE", Fingerprint: "f1", TitleId: 2, Ids: metadata.Ids{Imdb: "tt1"}}
+	if err := matches.SaveMatch(m); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := matches.GetMatch("OTHER", "f1")
+	if !ok || got.TitleId != 2 || got.Ids.Imdb != "tt1" {
+		t.Fatalf("GetMatch(OTHER, f1) = %+v, %v", got, ok)
+	}
+	got, ok = matches.GetMatch("MOVIE", "f2")
+	if !ok || got.Fingerprint != "f1" {
+		t.Fatalf("GetMatch(MOVIE, f2) = %+v, %v, expected the label to match", got, ok)
+	}
+	if _, ok := matches.GetMatch("", "f2"); ok {
+		t.Fatal("expected no match without a label or fingerprint")
+	}
+}
+
+func TestSqliteMatchDatabase_LatestLabel(t *testing.T) {
+	db := openTestDB(t)
+	matches, err := NewSqliteMatchDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	matches.SaveMatch(DiscMatch{Label: "MOVIE", Fingerprint: "f1", Ids: metadata.Ids{Imdb: "tt1"}, Updated: now.Add(-time.Hour)})
+	matches.SaveMatch(DiscMatch{Label: "MOVIE", Fingerprint: "f2", Ids: metadata.Ids{Imdb: "tt2"}, Updated: now})
+
+	got, ok := matches.GetMatch("MOVIE", "f3")
+	if !ok || got.Ids.Imdb != "tt2" {
+		t.Fatalf("GetMatch(MOVIE, f3) = %+v, expected the latest match", got)
+	}
+}
+
+func TestSqliteMatchDatabase_Persistence(t *testing.T) {
+	dbPath := t.TempDir() + "/test.db"
+
+	db1, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches1, err := NewSqliteMatchDatabase(db1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches1.SaveMatch(DiscMatch{Label: "MOVIE", Fingerprint: "f1", TitleId: 1, Ids: metadata.Ids{Tmdb: "603"}})
+	db1.Close()
+
+	db2, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	matches2, err := NewSqliteMatchDatabase(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := matches2.GetMatch("", "f1")
+	if !ok || got.TitleId != 1 || got.Ids.Tmdb != "603" || got.Ids.Imdb != "" {
+		t.Fatalf("GetMatch after reopen = %+v, %v", got, ok)
+	}
+}
+
+func TestFindMatch(t *testing.T) {
+	db := openTestDB(t)
+	matches, err := NewSqliteMatchDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pressing := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 2 * time.Minute, FileSize: 100},
+		{Id: 1, Duration: 2 * time.Hour, FileSize: 1000},
+	}}
+	other := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 2 * time.Hour, FileSize: 1200},
+	}}
+	if Fingerprint(pressing) == Fingerprint(other) {
+		t.Fatal("expected different pressings to have different fingerprints")
+	}
+
+	matches.SaveMatch(DiscMatch{Label: "MOVIE", Fingerprint: Fingerprint(pressing), TitleId: 0, Ids: metadata.Ids{Imdb: "tt1"}})
+
+	if m, ok := FindMatch(matches, "", pressing); !ok || m.TitleId != 0 {
+		t.Fatalf("FindMatch(pressing) = %+v, %v, expected title 0", m, ok)
+	} else if c := MatchConfidence(m, pressing); c != nil {
+		t.Fatalf("MatchConfidence(pressing) = %v, expected the same pressing to be trusted", *c)
+	}
+	if m, ok := FindMatch(matches, "MOVIE", other); !ok || m.TitleId != 0 || m.Ids.Imdb != "tt1" {
+		t.Fatalf("FindMatch(other) = %+v, %v, expected the main title", m, ok)
+	} else if c := MatchConfidence(m, other); c == nil || *c >= model.ReviewConfidence {
+		t.Fatalf("MatchConfidence(other) = %v, expected a match by label to be held for review", c)
+	}
+	if _, ok := FindMatch(matches, "OTHER", other); ok {
+		t.Fatal("expected no match for an unknown disc")
+	}
+}
@@ -0,0 +1,51 @@
+package drive
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// NewMultiDriveManager returns a DriveManager with the drives of all of the
+// managers, their drive ids must not overlap.
+func NewMultiDriveManager(managers ...DriveManager) DriveManager {
+	return multiDriveManager(managers)
+}
+
+type multiDriveManager []DriveManager
+
+func (m multiDriveManager) Start() error {
+	var errs []error
+	for _, dm := range m {
+		errs = append(errs, dm.Start())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiDriveManager) Stop() error {
+	var errs []error
+	for _, dm := range m {
+		errs = append(errs, dm.Stop())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiDriveManager) GetDrive(id string) (Drive, bool) {
+	for _, dm := range m {
+		if d, ok := dm.GetDrive(id); ok {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (m multiDriveManager) GetDrives() []Drive {
+	drives := make([]Drive, 0)
+	for _, dm := range m {
+		drives = append(drives, dm.GetDrives()...)
+	}
+	slices.SortFunc(drives, func(a, b Drive) int {
+		return strings.Compare(a.Id(), b.Id())
+	})
+	return drives
+}
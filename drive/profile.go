@@ -0,0 +1,207 @@
+package drive
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+)
+
+// DefaultMinlength is the shortest title, in seconds, that makemkv reads when
+// no rip profiles are configured.
+const DefaultMinlength = 3600
+
+// EpisodeMinlength is the longest minlength discs are read with, so the titles
+// of tv series discs are read even when every profile only rips movies.
+const EpisodeMinlength = 15 * 60
+
+const DefaultProfileName = "default"
+
+// RipProfile is a named set of makemkv options for ripping titles.
+type RipProfile struct {
+	Name string
+	// Minlength is the shortest title in seconds that is shown or ripped
+	Minlength int
+	// Selection is a makemkv track selection string, like
+	// "-sel:all,+sel:(eng|nolang)". It is passed to makemkv in a profile.
+	Selection string
+	// Profile is a makemkv profile xml file, used instead of Selection
+	Profile string
+	// Decrypt is only for backups, ripped titles are always decrypted
+	Decrypt bool
+	// Cache is the read cache size in MB, 0 for makemkv's default
+	Cache int
+}
+
+var DefaultProfile = RipProfile{Name: DefaultProfileName, Minlength: DefaultMinlength, Decrypt: true}
+
+// Titles returns a copy of info with only the titles that are long enough for
+// this profile. The titles keep their ids.
+func (p RipProfile) Titles(info *makemkv.DiscInfo) *makemkv.DiscInfo {
+	if info == nil {
+		return nil
+	}
+	out := *info
+	out.Titles = slices.DeleteFunc(slices.Clone(info.Titles), func(t makemkv.TitleInfo) bool {
+		return t.Duration < time.Duration(p.Minlength)*time.Second
+	})
+	return &out
+}
+
+// Profiles are the configured rip profiles, and which of them discs use by
+// default. The zero value only has DefaultProfile.
+type Profiles struct {
+	profiles map[string]RipProfile
+	// def is the profile for discs without a disc type default
+	def string
+	// discTypes maps "dvd" and "bluray" to a profile
+	discTypes map[string]string
+}
+
+// NewProfiles checks that def and the disc type defaults name one of the
+// profiles. An empty def is the first profile.
+func NewProfiles(profiles []RipProfile, def string, discTypes map[string]string) (Profiles, error) {
+	if len(profiles) == 0 {
+		profiles = []RipProfile{DefaultProfile}
+	}
+	p := Profiles{
+		profiles:  make(map[string]RipProfile, len(profiles)),
+		def:       def,
+		discTypes: discTypes,
+	}
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return Profiles{}, fmt.Errorf("rip profile has no name")
+		}
+		if profile.Minlength < 0 || profile.Cache < 0 {
+			return Profiles{}, fmt.Errorf("rip profile %s: minlength and cache can't be negative", profile.Name)
+		}
+		p.profiles[profile.Name] = profile
+	}
+	if p.def == "" {
+		p.def = profiles[0].Name
+	}
+	if _, ok := p.profiles[p.def]; !ok {
+		return Profiles{}, fmt.Errorf("unknown default rip profile: %s", p.def)
+	}
+	for discType, name := range discTypes {
+		if _, ok := p.profiles[name]; !ok {
+			return Profiles{}, fmt.Errorf("unknown rip profile for %s discs: %s", discType, name)
+		}
+	}
+	return p, nil
+}
+
+// Get returns the named profile, or the default one if there is no such
+// profile.
+func (p Profiles) Get(name string) RipProfile {
+	if profile, ok := p.profiles[name]; ok {
+		return profile
+	}
+	if profile, ok := p.profiles[p.def]; ok {
+		return profile
+	}
+	return DefaultProfile
+}
+
+// Has reports whether there is a profile with this name.
+func (p Profiles) Has(name string) bool {
+	if len(p.profiles) == 0 {
+		return name == DefaultProfileName
+	}
+	_, ok := p.profiles[name]
+	return ok
+}
+
+// Names returns the names of the profiles, sorted.
+func (p Profiles) Names() []string {
+	if len(p.profiles) == 0 {
+		return []string{DefaultProfileName}
+	}
+	names := make([]string, 0, len(p.profiles))
+	for name := range p.profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ForDisc returns the name of the default profile for the type of the disc.
+func (p Profiles) ForDisc(info *makemkv.DiscInfo) string {
+	if info != nil {
+		if name, ok := p.discTypes[discType(info.DiscType)]; ok {
+			return name
+		}
+	}
+	return p.Get(p.def).Name
+}
+
+// Minlength is the shortest minlength of the profiles, discs are read with it
+// so that every profile can pick from their titles. It's never longer than
+// EpisodeMinlength.
+func (p Profiles) Minlength() int {
+	minlength := EpisodeMinlength
+	for _, profile := range p.profiles {
+		minlength = min(minlength, profile.Minlength)
+	}
+	return minlength
+}
+
+// discType turns makemkv's disc type, like "Blu-ray disc", into the key used
+// for disc type defaults.
+func discType(t string) string {
+	t = strings.ToLower(t)
+	switch {
+	case strings.Contains(t, "blu-ray"):
+		return "bluray"
+	case strings.Contains(t, "dvd"):
+		return "dvd"
+	}
+	return t
+}
+
+// profileFile returns the makemkv profile to rip with, writing one with the
+// selection string to dir if needed. It is empty for makemkv's default.
+func (p RipProfile) profileFile(dir string) (string, error) {
+	if p.Profile != "" || p.Selection == "" {
+		return p.Profile, nil
+	}
+
+	var name, sel bytes.Buffer
+	if err := xml.EscapeText(&name, []byte(p.Name)); err != nil {
+		return "", err
+	}
+	if err := xml.EscapeText(&sel, []byte(p.Selection)); err != nil {
+		return "", err
+	}
+	file := path.Join(dir, "profile.mmcp.xml")
+	content := fmt.Sprintf(selectionProfile, name.String(), sel.String())
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// selectionProfile is makemkv's default profile with only the selection
+// string changed.
+const selectionProfile = `<?xml version="1.0" encoding="utf-8"?>
+<profile>
+    <name lang="eng">%s</name>
+    <profileSettings
+        app_DefaultSelectionString="%s"
+    />
+    <outputSettings name="copy" outputFormat="directCopy">
+        <description lang="eng">Copy track as is</description>
+    </outputSettings>
+    <trackSettings input="default">
+        <output outputSettingsName="copy" defaultSelection="$app_DefaultSelectionString">
+        </output>
+    </trackSettings>
+</profile>
+`
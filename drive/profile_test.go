@@ -0,0 +1,116 @@
+package drive
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProfiles(t *testing.T) {
+	movies := RipProfile{Name: "movies", Minlength: 3600, Selection: "-sel:all,+sel:(eng|nolang)"}
+	extras := RipProfile{Name: "extras", Minlength: 120}
+	profiles, err := NewProfiles([]RipProfile{movies, extras}, "movies", map[string]string{"dvd": "extras"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := profiles.Get("extras"); !cmp.Equal(got, extras) {
+		t.Fatalf(`Get("extras") = %+v, expected: %+v`, got, extras)
+	}
+	if got := profiles.Get("unknown"); !cmp.Equal(got, movies) {
+		t.Fatalf(`Get("unknown") = %+v, expected: %+v`, got, movies)
+	}
+	if got := profiles.Names(); !cmp.Equal(got, []string{"extras", "movies"}) {
+		t.Fatalf("Names() = %v", got)
+	}
+	if got := profiles.Minlength(); got != 120 {
+		t.Fatalf("Minlength() = %d, expected: 120", got)
+	}
+	if got := profiles.ForDisc(&makemkv.DiscInfo{DiscType: "DVD disc"}); got != "extras" {
+		t.Fatalf("ForDisc(dvd) = %s, expected: extras", got)
+	}
+	if got := profiles.ForDisc(&makemkv.DiscInfo{DiscType: "Blu-ray disc"}); got != "movies" {
+		t.Fatalf("ForDisc(bluray) = %s, expected: movies", got)
+	}
+
+	if _, err := NewProfiles([]RipProfile{movies}, "extras", nil); err == nil {
+		t.Fatal("expected an unknown default to fail")
+	}
+	if _, err := NewProfiles([]RipProfile{movies}, "", map[string]string{"bluray": "extras"}); err == nil {
+		t.Fatal("expected an unknown disc type profile to fail")
+	}
+}
+
+func TestProfiles_Zero(t *testing.T) {
+	var profiles Profiles
+	if got := profiles.Get(""); !cmp.Equal(got, DefaultProfile) {
+		t.Fatalf(`Get("") = %+v, expected: %+v`, got, DefaultProfile)
+	}
+	if got := profiles.Minlength(); got != EpisodeMinlength {
+		t.Fatalf("Minlength() = %d, expected: %d", got, EpisodeMinlength)
+	}
+	if got := profiles.ForDisc(nil); got != DefaultProfileName {
+		t.Fatalf("ForDisc(nil) = %s, expected: %s", got, DefaultProfileName)
+	}
+}
+
+func TestRipProfile_Titles(t *testing.T) {
+	info := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 2 * time.Hour},
+		{Id: 1, Duration: 5 * time.Minute},
+		{Id: 2, Duration: 45 * time.Minute},
+	}}
+	got := RipProfile{Minlength: 1800}.Titles(info)
+	if len(got.Titles) != 2 || got.Titles[0].Id != 0 || got.Titles[1].Id != 2 {
+		t.Fatalf("Titles() = %+v, expected titles 0 and 2", got.Titles)
+	}
+	if len(info.Titles) != 3 {
+		t.Fatal("Titles() changed the disc info")
+	}
+}
+
+func TestRipProfile_ProfileFile(t *testing.T) {
+	dir := t.TempDir()
+	if file, err := (RipProfile{Profile: "/etc/makemkv/eng.mmcp.xml"}).profileFile(dir); err != nil || file != "/etc/makemkv/eng.mmcp.xml" {
+		t.Fatalf("profileFile() = %s, %v, expected the configured profile", file, err)
+	}
+	if file, err := (RipProfile{}).profileFile(dir); err != nil || file != "" {
+		t.Fatalf("profileFile() = %s, %v, expected makemkv's default", file, err)
+	}
+
+	file, err := RipProfile{Name: "eng", Selection: "-sel:all,+sel:(eng&audio)"}.profileFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != path.Join(dir, "profile.mmcp.xml") {
+		t.Fatalf("profileFile() = %s", file)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `app_DefaultSelectionString="-sel:all,+sel:(eng&amp;audio)"`) {
+		t.Fatalf("profile doesn't have the escaped selection:\n%s", content)
+	}
+}
+
+func TestMkvArgs(t *testing.T) {
+	dev := &fileDevice{path: "/discs/MOVIE.iso", kind: "iso"}
+	expected := []string{"--progress=-same", "--minlength=120", "--noscan", "--cache=1024", "--profile=/tmp/p.xml", "mkv", "iso:/discs/MOVIE.iso", "3", "/out"}
+	if got := mkvArgs(dev, 3, "/out", 120, 1024, "/tmp/p.xml"); !cmp.Equal(got, expected) {
+		t.Fatalf("mkvArgs() = %+v, expected: %+v", got, expected)
+	}
+	expected = []string{"--progress=-same", "--minlength=3600", "--noscan", "mkv", "iso:/discs/MOVIE.iso", "0", "/out"}
+	if got := mkvArgs(dev, 0, "/out", 3600, 0, ""); !cmp.Equal(got, expected) {
+		t.Fatalf("mkvArgs() = %+v, expected: %+v", got, expected)
+	}
+	expected = []string{"--progress=-same", "--noscan", "backup", "iso:/discs/MOVIE.iso", "/out"}
+	if got := backupArgs(dev, "/out", false, 0); !cmp.Equal(got, expected) {
+		t.Fatalf("backupArgs() = %+v, expected: %+v", got, expected)
+	}
+}
@@ -3,69 +3,134 @@ package drive
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sync"
 
 	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/util"
 )
 
 func NewSqliteDiscDatabase(db *sql.DB) (DiscDatabase, error) {
 	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS disc_info (
-		uuid TEXT PRIMARY KEY,
+		id TEXT PRIMARY KEY,
+		uuid TEXT,
+		label TEXT,
 		info_json TEXT NOT NULL
 	)`)
 	if err != nil {
 		return nil, err
 	}
+	// discs read before rip profiles were read with a minlength of 3600
+	if err := util.AddColumn(db, "disc_info", "minlength INTEGER"); err != nil {
+		return nil, err
+	}
+	if err := util.AddColumn(db, "disc_info", "size INTEGER"); err != nil {
+		return nil, err
+	}
 
 	discInfoMap := make(map[string]*makemkv.DiscInfo)
+	discs := make(map[string]Disc)
 
-	rows, err := db.Query("SELECT uuid, info_json FROM disc_info")
+	rows, err := db.Query("SELECT id, uuid, label, info_json, minlength, size FROM disc_info")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var uuid, infoJson string
-		if err := rows.Scan(&uuid, &infoJson); err != nil {
+		var id, infoJson string
+		var uuid, label sql.NullString
+		var minlength, size sql.NullInt64
+		if err := rows.Scan(&id, &uuid, &label, &infoJson, &minlength, &size); err != nil {
 			log.Println("error scanning disc_info row:", err)
 			continue
 		}
 		var info makemkv.DiscInfo
 		if err := json.Unmarshal([]byte(infoJson), &info); err != nil {
-			log.Println("error unmarshaling disc info for", uuid, err)
+			log.Println("error unmarshaling disc info for", id, err)
 			continue
 		}
-		discInfoMap[uuid] = &info
+		discInfoMap[id] = &info
+		disc := Disc{Id: id, Uuid: uuid.String, Label: label.String, Minlength: DefaultMinlength, Size: size.Int64}
+		if minlength.Valid {
+			disc.Minlength = int(minlength.Int64)
+		}
+		discs[id] = disc
 	}
 
-	return &sqliteDiscDatabase{db: db, discInfoMap: discInfoMap}, nil
+	return &sqliteDiscDatabase{db: db, discInfoMap: discInfoMap, discs: discs}, nil
 }
 
 type sqliteDiscDatabase struct {
+	mutex       sync.RWMutex
 	db          *sql.DB
 	discInfoMap map[string]*makemkv.DiscInfo
+	discs       map[string]Disc
 }
 
 func (d *sqliteDiscDatabase) GetDiscInfo(id string) (info *makemkv.DiscInfo, ok bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
 	info, ok = d.discInfoMap[id]
 	return info, ok
 }
 
-func (d *sqliteDiscDatabase) SaveDiscInfo(id string, info *makemkv.DiscInfo) error {
+func (d *sqliteDiscDatabase) GetDisc(id string) (Disc, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	disc, ok := d.discs[id]
+	return disc, ok
+}
+
+func (d *sqliteDiscDatabase) SaveDiscInfo(disc *Disc, info *makemkv.DiscInfo) error {
+	if disc.Id == "" {
+		return fmt.Errorf("disc has no id")
+	}
 	bytes, err := json.Marshal(info)
 	if err != nil {
 		return err
 	}
 
 	_, err = d.db.Exec(
-		"INSERT INTO disc_info (uuid, info_json) VALUES (?, ?) ON CONFLICT(uuid) DO UPDATE SET info_json = excluded.info_json",
-		id, string(bytes),
+		`INSERT INTO disc_info (id, uuid, label, info_json, minlength, size) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET
+			uuid = excluded.uuid,
+			label = excluded.label,
+			info_json = excluded.info_json,
+			minlength = excluded.minlength,
+			size = excluded.size`,
+		disc.Id, disc.Uuid, disc.Label, string(bytes), disc.Minlength, disc.Size,
 	)
 	if err != nil {
 		return err
 	}
 
-	d.discInfoMap[id] = info
+	d.mutex.Lock()
+	d.discInfoMap[disc.Id] = info
+	d.discs[disc.Id] = *disc
+	d.mutex.Unlock()
 	return nil
 }
+
+func (d *sqliteDiscDatabase) FindDisc(uuid string, label string) (Disc, bool) {
+	if uuid == "" {
+		return Disc{}, false
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var found []Disc
+	for _, disc := range d.discs {
+		// discs migrated from before ids were fingerprints may not have a label
+		if disc.Uuid == uuid && (label == "" || disc.Label == label || disc.Label == "") {
+			found = append(found, disc)
+		}
+	}
+	if len(found) != 1 {
+		return Disc{}, false
+	}
+	return found[0], true
+}
@@ -33,11 +33,11 @@ func TestSqliteDiscDatabase_SaveAndGet(t *testing.T) {
 		},
 	}
 
-	if err := discdb.SaveDiscInfo("uuid-1", info); err != nil {
+	if err := discdb.SaveDiscInfo(&Disc{Id: "disc-1"}, info); err != nil {
 		t.Fatal(err)
 	}
 
-	got, ok := discdb.GetDiscInfo("uuid-1")
+	got, ok := discdb.GetDiscInfo("disc-1")
 	if !ok {
 		t.Fatal("expected ok=true")
 	}
@@ -56,10 +56,10 @@ func TestSqliteDiscDatabase_Upsert(t *testing.T) {
 	info1 := &makemkv.DiscInfo{Name: "Original"}
 	info2 := &makemkv.DiscInfo{Name: "Updated"}
 
-	discdb.SaveDiscInfo("uuid-1", info1)
-	discdb.SaveDiscInfo("uuid-1", info2)
+	discdb.SaveDiscInfo(&Disc{Id: "disc-1"}, info1)
+	discdb.SaveDiscInfo(&Disc{Id: "disc-1"}, info2)
 
-	got, ok := discdb.GetDiscInfo("uuid-1")
+	got, ok := discdb.GetDiscInfo("disc-1")
 	if !ok {
 		t.Fatal("expected ok=true")
 	}
@@ -95,7 +95,7 @@ func TestSqliteDiscDatabase_Persistence(t *testing.T) {
 		t.Fatal(err)
 	}
 	info := &makemkv.DiscInfo{Name: "Persistent"}
-	discdb1.SaveDiscInfo("uuid-p", info)
+	discdb1.SaveDiscInfo(&Disc{Id: "disc-p"}, info)
 	db1.Close()
 
 	// Second open: verify data
@@ -109,7 +109,7 @@ func TestSqliteDiscDatabase_Persistence(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got, ok := discdb2.GetDiscInfo("uuid-p")
+	got, ok := discdb2.GetDiscInfo("disc-p")
 	if !ok {
 		t.Fatal("expected data to persist across reopen")
 	}
@@ -117,3 +117,51 @@ func TestSqliteDiscDatabase_Persistence(t *testing.T) {
 		t.Fatalf("expected Persistent, got %s", got.Name)
 	}
 }
+
+func TestSqliteDiscDatabase_FindDisc(t *testing.T) {
+	db := openTestDB(t)
+	discdb, err := NewSqliteDiscDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discdb.SaveDiscInfo(&Disc{Id: "f1", Uuid: "u1", Label: "MOVIE", Minlength: 120, Size: 4096}, &makemkv.DiscInfo{Name: "Movie"})
+	discdb.SaveDiscInfo(&Disc{Id: "f2", Uuid: "u2", Label: "DVD_VIDEO"}, &makemkv.DiscInfo{Name: "One"})
+	discdb.SaveDiscInfo(&Disc{Id: "f3", Uuid: "u2", Label: "DVD_VIDEO"}, &makemkv.DiscInfo{Name: "Two"})
+
+	if disc, ok := discdb.FindDisc("u1", "MOVIE"); !ok || disc.Id != "f1" || disc.Minlength != 120 || disc.Size != 4096 {
+		t.Fatalf(`FindDisc("u1", "MOVIE") = %+v, %v, expected f1 read with minlength 120 and size 4096`, disc, ok)
+	}
+	if _, ok := discdb.FindDisc("u1", "OTHER"); ok {
+		t.Fatal("expected a different label not to match")
+	}
+	if disc, ok := discdb.FindDisc("u1", ""); !ok || disc.Id != "f1" {
+		t.Fatalf(`FindDisc("u1", "") = %+v, %v, expected f1 by its uuid alone`, disc, ok)
+	}
+	if _, ok := discdb.FindDisc("u2", "DVD_VIDEO"); ok {
+		t.Fatal("expected a shared uuid not to match")
+	}
+	if _, ok := discdb.FindDisc("", ""); ok {
+		t.Fatal("expected no uuid not to match")
+	}
+}
+
+func TestSqliteDiscDatabase_OldMinlength(t *testing.T) {
+	db := openTestDB(t)
+	// a disc read before rip profiles
+	_, err := db.Exec(`CREATE TABLE disc_info (id TEXT PRIMARY KEY, uuid TEXT, label TEXT, info_json TEXT NOT NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO disc_info VALUES ('f1', 'u1', 'MOVIE', '{}')`); err != nil {
+		t.Fatal(err)
+	}
+
+	discdb, err := NewSqliteDiscDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disc, ok := discdb.FindDisc("u1", "MOVIE"); !ok || disc.Minlength != DefaultMinlength {
+		t.Fatalf(`FindDisc("u1", "MOVIE") = %+v, %v, expected minlength %d`, disc, ok, DefaultMinlength)
+	}
+}
@@ -3,13 +3,38 @@ package drive
 import (
 	"context"
 	"log"
+	"path"
+	"strconv"
 	"sync"
 
+	"github.com/aravance/go-makemkv"
 	udev "github.com/jochenvg/go-udev"
 )
 
+// device is an optical drive as udev reports it, with the media in it if
+// it's Available.
+type device interface {
+	makemkv.Device
+	Id() string
+	Label() string
+	Uuid() string
+	Size() int64
+}
+
 type udevDevice struct {
-	udev *udev.Device
+	udev    *udev.Device
+	devname string
+}
+
+func newUdevDevice(d *udev.Device) *udevDevice {
+	return &udevDevice{
+		udev:    d,
+		devname: d.PropertyValue("DEVNAME"),
+	}
+}
+
+func (t *udevDevice) Id() string {
+	return path.Base(t.devname)
 }
 
 func (t *udevDevice) Label() string {
@@ -28,12 +53,21 @@ func (t *udevDevice) Uuid() string {
 	}
 }
 
-func (t *udevDevice) Device() string {
+// Size is the size of the media in bytes, 0 if it's not known.
+func (t *udevDevice) Size() int64 {
 	if t.udev == nil {
-		return ""
-	} else {
-		return t.udev.PropertyValue("DEVNAME")
+		return 0
+	}
+	// in 512 byte sectors, whatever the block size of the device
+	sectors, err := strconv.ParseInt(t.udev.SysattrValue("size"), 10, 64)
+	if err != nil {
+		return 0
 	}
+	return sectors * 512
+}
+
+func (t *udevDevice) Device() string {
+	return t.devname
 }
 
 func (t *udevDevice) Type() string {
@@ -41,12 +75,14 @@ func (t *udevDevice) Type() string {
 }
 
 func (t *udevDevice) Available() bool {
-	return t.udev != nil && t.udev.PropertyValue("SYSTEMD_READY") != "0"
+	return t.udev != nil &&
+		t.udev.PropertyValue("ID_CDROM_MEDIA") == "1" &&
+		t.udev.PropertyValue("SYSTEMD_READY") != "0"
 }
 
 type udevListener struct {
-	devices map[string]udevDevice
-	notify  func(*udevDevice)
+	devices map[string]*udevDevice
+	notify  func(device)
 	started bool
 	stopped bool
 	wg      sync.WaitGroup
@@ -54,9 +90,9 @@ type udevListener struct {
 	cancel  context.CancelFunc
 }
 
-func newUdevListener(notify func(*udevDevice)) *udevListener {
+func newUdevListener(notify func(device)) *udevListener {
 	return &udevListener{
-		devices: make(map[string]udevDevice),
+		devices: make(map[string]*udevDevice),
 		notify:  notify,
 		started: false,
 		wg:      sync.WaitGroup{},
@@ -81,9 +117,11 @@ func (t *udevListener) run() {
 	defer t.wg.Done()
 	u := udev.Udev{}
 
+	// enumerate every optical drive, not just the ones with media, so empty
+	// drives still show up in the drive list
 	e := u.NewEnumerate()
 	e.AddMatchSubsystem("block")
-	e.AddMatchProperty("ID_CDROM_MEDIA", "1")
+	e.AddMatchProperty("ID_CDROM", "1")
 	e.AddMatchIsInitialized()
 	devices, err := e.Devices()
 	if err != nil {
@@ -91,7 +129,9 @@ func (t *udevListener) run() {
 	} else {
 		for _, d := range devices {
 			if d.Devtype() == "disk" {
-				go t.notify(&udevDevice{d})
+				dev := newUdevDevice(d)
+				t.devices[dev.devname] = dev
+				go t.notify(dev)
 			}
 		}
 	}
@@ -110,17 +150,18 @@ func (t *udevListener) run() {
 
 	log.Println("udev channel opened")
 	for d := range devchan {
+		devname := d.PropertyValue("DEVNAME")
 		if d.PropertyValue("ID_CDROM_MEDIA") == "1" {
 			log.Println("found media:", d.Sysname(), "name:", d.PropertyValue("ID_FS_LABEL"))
-			dev := udevDevice{d}
-			t.devices[d.PropertyValue("DEVNAME")] = dev
-			go t.notify(&dev)
+			dev := newUdevDevice(d)
+			t.devices[devname] = dev
+			go t.notify(dev)
 		} else if d.PropertyValue("SYSTEMD_READY") == "0" {
-			dev, ok := t.devices[d.PropertyValue("DEVNAME")]
-			if ok {
-				delete(t.devices, d.PropertyValue("DEVNAME"))
-				dev.udev = nil
-				go t.notify(&dev)
+			dev, ok := t.devices[devname]
+			if ok && dev.udev != nil {
+				dev = &udevDevice{udev: nil, devname: devname}
+				t.devices[devname] = dev
+				go t.notify(dev)
 			}
 		}
 	}
@@ -0,0 +1,165 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Type string
+
+const (
+	TypeDrive    Type = "drive"
+	TypeWorkflow Type = "workflow"
+	TypeProgress Type = "progress"
+)
+
+// Event tells subscribers that something changed, they look up the current
+// state themselves. Id is the drive id for drive events, and the disc id and
+// title id for workflow and progress events.
+type Event struct {
+	Type    Type
+	Id      string
+	DiscId  string
+	TitleId int
+}
+
+func Drive(driveId string) Event {
+	return Event{Type: TypeDrive, Id: driveId}
+}
+
+func Workflow(discId string, titleId int) Event {
+	return Event{Type: TypeWorkflow, Id: WorkflowId(discId, titleId), DiscId: discId, TitleId: titleId}
+}
+
+func Progress(discId string, titleId int) Event {
+	return Event{Type: TypeProgress, Id: WorkflowId(discId, titleId), DiscId: discId, TitleId: titleId}
+}
+
+func WorkflowId(discId string, titleId int) string {
+	return fmt.Sprintf("%s-%d", discId, titleId)
+}
+
+// Hub fans events out to every subscriber. Publishing never blocks, events
+// that a slow subscriber hasn't received yet are coalesced by Type and Id
+// instead, since subscribers only use them to look up the current state.
+type Hub struct {
+	mutex       sync.Mutex
+	closed      bool
+	subscribers map[chan Event]*subscriber
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[chan Event]*subscriber)}
+}
+
+// subscriber queues events until its goroutine sends them on ch, an event
+// already in the queue isn't queued again.
+type subscriber struct {
+	mutex   sync.Mutex
+	pending []Event
+	queued  map[Event]struct{}
+	notify  chan struct{}
+	done    chan struct{}
+	ch      chan Event
+}
+
+func (s *subscriber) push(e Event) {
+	s.mutex.Lock()
+	if _, ok := s.queued[e]; !ok {
+		s.queued[e] = struct{}{}
+		s.pending = append(s.pending, e)
+	}
+	s.mutex.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) take() []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := s.pending
+	s.pending = nil
+	clear(s.queued)
+	return events
+}
+
+func (s *subscriber) run() {
+	defer close(s.ch)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.notify:
+		}
+		for _, e := range s.take() {
+			select {
+			case <-s.done:
+				return
+			case s.ch <- e:
+			}
+		}
+	}
+}
+
+func (h *Hub) Subscribe() chan Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ch := make(chan Event)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	s := &subscriber{
+		queued: make(map[Event]struct{}),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		ch:     ch,
+	}
+	h.subscribers[ch] = s
+	go s.run()
+	return ch
+}
+
+// Unsubscribe stops sending events to ch, it is closed once pending sends are
+// abandoned.
+func (h *Hub) Unsubscribe(ch chan Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if s, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(s.done)
+	}
+}
+
+// Publish sends e to every subscriber, it is safe to call on a nil Hub.
+func (h *Hub) Publish(e Event) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, s := range h.subscribers {
+		s.push(e)
+	}
+}
+
+// Close closes every subscriber channel, so long lived subscribers like event
+// streams end on shutdown.
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for ch, s := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(s.done)
+	}
+}
@@ -0,0 +1,92 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHubPublish(t *testing.T) {
+	h := NewHub()
+	a := h.Subscribe()
+	b := h.Subscribe()
+	defer h.Unsubscribe(b)
+
+	e := Workflow("disc", 1)
+	h.Publish(e)
+
+	for _, ch := range []chan Event{a, b} {
+		select {
+		case got := <-ch:
+			if got != e {
+				t.Fatalf("got %v, expected: %v", got, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event was not delivered")
+		}
+	}
+
+	h.Unsubscribe(a)
+	if _, ok := <-a; ok {
+		t.Fatal("expected channel to be closed after Unsubscribe")
+	}
+	h.Unsubscribe(a)
+	h.Publish(Drive("sr0"))
+	if got := <-b; got.Type != TypeDrive || got.Id != "sr0" {
+		t.Fatalf("got %v, expected drive event", got)
+	}
+}
+
+func TestHubCoalescesForSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	ch := h.Subscribe()
+	defer h.Unsubscribe(ch)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			h.Publish(Progress("disc", 0))
+		}
+		h.Publish(Workflow("disc", 0))
+		h.Publish(Drive("sr0"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a slow subscriber")
+	}
+
+	var got []Event
+	for len(got) == 0 || got[len(got)-1] != Drive("sr0") {
+		select {
+		case e := <-ch:
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatalf("got %v, expected the final workflow and drive events", got)
+		}
+	}
+	// the first progress event may already be waiting to be sent
+	if len(got) > 4 || got[len(got)-2] != Workflow("disc", 0) {
+		t.Fatalf("got %v, expected coalesced progress then workflow and drive events", got)
+	}
+}
+
+func TestNilHubPublish(t *testing.T) {
+	var h *Hub
+	h.Publish(Drive("sr0"))
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub()
+	ch := h.Subscribe()
+	h.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("expected channel to be closed after Close")
+	}
+	h.Unsubscribe(ch)
+	if _, ok := <-h.Subscribe(); ok {
+		t.Fatal("expected Subscribe to return a closed channel after Close")
+	}
+	h.Publish(Drive("sr0"))
+}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/workflow"
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed openapi.yaml
+var openapi []byte
+
+// ApiHandler serves the json api under /api/v1, see openapi.yaml.
+type ApiHandler struct {
+	driveManager    drive.DriveManager
+	workflowManager workflow.WorkflowManager
+	discdb          drive.DiscDatabase
+	matches         drive.MatchDatabase
+	provider        metadata.Provider
+}
+
+func NewApiHandler(discdb drive.DiscDatabase, matches drive.MatchDatabase, driveManager drive.DriveManager, workflowManager workflow.WorkflowManager, provider metadata.Provider) ApiHandler {
+	return ApiHandler{driveManager, workflowManager, discdb, matches, provider}
+}
+
+type apiError struct {
+	Error string
+}
+
+type apiDrive struct {
+	Id       string
+	Device   string
+	Status   drive.DriveStatus
+	Disc     *drive.Disc     `json:",omitempty"`
+	Progress *makemkv.Status `json:",omitempty"`
+}
+
+type apiWorkflow struct {
+	*model.Workflow
+	Progress *makemkv.Status `json:",omitempty"`
+}
+
+func jsonError(c echo.Context, err error) error {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return c.JSON(he.Code, apiError{fmt.Sprintf("%v", he.Message)})
+	}
+	return c.JSON(http.StatusInternalServerError, apiError{err.Error()})
+}
+
+func (h ApiHandler) OpenApi(c echo.Context) error {
+	return c.Blob(http.StatusOK, "application/yaml", openapi)
+}
+
+func (h ApiHandler) getWorkflow(c echo.Context) (*model.Workflow, error) {
+	titleId, err := strconv.Atoi(c.Param("titleId"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "workflow not found")
+	}
+	w := h.workflowManager.GetWorkflow(c.Param("discId"), titleId)
+	if w == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "workflow not found")
+	}
+	return w, nil
+}
+
+func (h ApiHandler) workflow(w *model.Workflow) apiWorkflow {
+	return apiWorkflow{w, h.workflowManager.Progress(w)}
+}
+
+func (h ApiHandler) ListWorkflows(c echo.Context) error {
+	var wfs []*model.Workflow
+	if discId := c.QueryParam("disc"); discId != "" {
+		wfs = h.workflowManager.GetWorkflows(discId)
+	} else {
+		wfs = h.workflowManager.GetAllWorkflows()
+	}
+	if status := c.QueryParam("status"); status != "" {
+		filtered := make([]*model.Workflow, 0, len(wfs))
+		for _, w := range wfs {
+			if string(w.Status) == status {
+				filtered = append(filtered, w)
+			}
+		}
+		wfs = filtered
+	}
+
+	out := make([]apiWorkflow, len(wfs))
+	for i, w := range wfs {
+		out[i] = h.workflow(w)
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func (h ApiHandler) GetWorkflow(c echo.Context) error {
+	w, err := h.getWorkflow(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusOK, h.workflow(w))
+}
+
+func (h ApiHandler) SetMetadata(c echo.Context) error {
+	w, err := h.getWorkflow(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	var md titleMetadata
+	if err := c.Bind(&md); err != nil {
+		return jsonError(c, echo.NewHTTPError(http.StatusBadRequest, "invalid request body"))
+	}
+	if err := setMetadata(h.workflowManager, h.provider, w, md); err != nil {
+		return jsonError(c, err)
+	}
+	rememberMatch(h.discdb, h.matches, w)
+	return c.JSON(http.StatusOK, h.workflow(w))
+}
+
+func (h ApiHandler) RipTitle(c echo.Context) error {
+	titleId, err := strconv.Atoi(c.Param("titleId"))
+	if err != nil {
+		return jsonError(c, echo.NewHTTPError(http.StatusNotFound, "no title found"))
+	}
+	w, err := ripTitle(h.driveManager, h.discdb, h.matches, h.workflowManager, h.provider, c.Param("discId"), titleId, c.QueryParam("profile"))
+	if err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, h.workflow(w))
+}
+
+func (h ApiHandler) Ingest(c echo.Context) error {
+	w, err := h.getWorkflow(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	if err := startIngest(h.workflowManager, w); err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, h.workflow(w))
+}
+
+func (h ApiHandler) Confirm(c echo.Context) error {
+	w, err := h.getWorkflow(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	if err := confirmMatch(h.workflowManager, w); err != nil {
+		return jsonError(c, err)
+	}
+	rememberMatch(h.discdb, h.matches, w)
+	return c.JSON(http.StatusOK, h.workflow(w))
+}
+
+func (h ApiHandler) Cancel(c echo.Context) error {
+	w, err := h.getWorkflow(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	if err := h.workflowManager.Cancel(w); err != nil {
+		return jsonError(c, echo.NewHTTPError(http.StatusConflict, err.Error()))
+	}
+	return c.JSON(http.StatusAccepted, h.workflow(w))
+}
+
+func (h ApiHandler) drive(dr drive.Drive) apiDrive {
+	return apiDrive{
+		Id:       dr.Id(),
+		Device:   dr.Device(),
+		Status:   dr.Status(),
+		Disc:     dr.GetDisc(),
+		Progress: driveProgress(h.workflowManager, dr),
+	}
+}
+
+func (h ApiHandler) ListDrives(c echo.Context) error {
+	drives := h.driveManager.GetDrives()
+	out := make([]apiDrive, len(drives))
+	for i, dr := range drives {
+		out[i] = h.drive(dr)
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func (h ApiHandler) GetDrive(c echo.Context) error {
+	dr, ok := h.driveManager.GetDrive(c.Param("driveId"))
+	if !ok {
+		return jsonError(c, echo.NewHTTPError(http.StatusNotFound, "drive not found"))
+	}
+	return c.JSON(http.StatusOK, h.drive(dr))
+}
+
+func (h ApiHandler) GetDiscInfo(c echo.Context) error {
+	dr, ok := h.driveManager.GetDrive(c.Param("driveId"))
+	if !ok {
+		return jsonError(c, echo.NewHTTPError(http.StatusNotFound, "drive not found"))
+	}
+	disc := dr.GetDisc()
+	if disc == nil {
+		return jsonError(c, echo.NewHTTPError(http.StatusNotFound, "drive is empty"))
+	}
+	info, ok := h.discdb.GetDiscInfo(disc.Id)
+	if !ok {
+		return jsonError(c, echo.NewHTTPError(http.StatusNotFound, "disc info not found"))
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h ApiHandler) Eject(c echo.Context) error {
+	dr, ok := h.driveManager.GetDrive(c.Param("driveId"))
+	if !ok {
+		return jsonError(c, echo.NewHTTPError(http.StatusNotFound, "drive not found"))
+	}
+	if err := dr.Eject(); err != nil {
+		return jsonError(c, echo.NewHTTPError(http.StatusConflict, err.Error()))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h ApiHandler) Backup(c echo.Context) error {
+	w, err := backupDisc(h.driveManager, h.discdb, h.matches, h.workflowManager, h.provider, c.Param("driveId"), c.QueryParam("profile"))
+	if err != nil {
+		return jsonError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, h.workflow(w))
+}
+
+func (h ApiHandler) Search(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	titles, err := search(h.provider, c.QueryParam("q"), c.QueryParam("type"), limit)
+	if err != nil {
+		return jsonError(c, echo.NewHTTPError(http.StatusBadGateway, err.Error()))
+	}
+	return c.JSON(http.StatusOK, titles)
+}
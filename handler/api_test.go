@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/workflow"
+	"github.com/labstack/echo/v4"
+	_ "modernc.org/sqlite"
+)
+
+type testDrive struct {
+	id   string
+	disc *drive.Disc
+}
+
+func (d *testDrive) Id() string                              { return d.id }
+func (d *testDrive) Device() string                          { return "/dev/" + d.id }
+func (d *testDrive) Eject() error                            { return nil }
+func (d *testDrive) GetDiscInfo() (*makemkv.DiscInfo, error) { return nil, nil }
+func (d *testDrive) GetDisc() *drive.Disc                    { return d.disc }
+func (d *testDrive) HasDisc() bool                           { return d.disc != nil }
+func (d *testDrive) Status() drive.DriveStatus               { return drive.StatusReady }
+func (d *testDrive) RipFile(context.Context, *makemkv.TitleInfo, string, drive.RipProfile, chan makemkv.Status) (*model.MkvFile, error) {
+	return nil, nil
+}
+func (d *testDrive) Backup(context.Context, string, drive.RipProfile, chan makemkv.Status) (string, error) {
+	return "", nil
+}
+
+type testDriveManager struct {
+	drives []drive.Drive
+}
+
+func (m *testDriveManager) Start() error { return nil }
+func (m *testDriveManager) Stop() error  { return nil }
+func (m *testDriveManager) GetDrives() []drive.Drive {
+	return m.drives
+}
+func (m *testDriveManager) GetDrive(id string) (drive.Drive, bool) {
+	for _, d := range m.drives {
+		if d.Id() == id {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// testProvider knows one movie, by either id
+type testProvider struct{}
+
+var testMovie = &metadata.Title{Ids: metadata.Ids{Imdb: "tt0133093", Tmdb: "603"}, Kind: metadata.Movie, Title: "The Matrix", Year: "1999"}
+
+func (testProvider) Search(query string, kind metadata.Kind, limit int) ([]*metadata.Title, error) {
+	return []*metadata.Title{testMovie}, nil
+}
+func (testProvider) Get(ids metadata.Ids, kind metadata.Kind) (*metadata.Title, error) {
+	if ids.Imdb == testMovie.Ids.Imdb || ids.Tmdb == testMovie.Ids.Tmdb {
+		return testMovie, nil
+	}
+	return nil, metadata.ErrNotFound
+}
+func (testProvider) FindTitle(name string, kind metadata.Kind) (*metadata.Title, error) {
+	return nil, metadata.ErrNotFound
+}
+func (testProvider) Season(ids metadata.Ids, season int) ([]metadata.Episode, error) {
+	return nil, metadata.ErrNotFound
+}
+
+func newTestApi(t *testing.T) (*echo.Echo, workflow.WorkflowManager) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	discdb, err := drive.NewSqliteDiscDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discdb.SaveDiscInfo(&drive.Disc{Id: "d1"}, &makemkv.DiscInfo{Name: "MOVIE", Titles: []makemkv.TitleInfo{{Id: 0, FileName: "title_t00.mkv"}}})
+
+	wfman, err := workflow.NewSqliteWorkflowManager(db, discdb, nil, workflow.Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driveman := &testDriveManager{[]drive.Drive{
+		&testDrive{"sr0", &drive.Disc{Id: "d1", Label: "MOVIE", Uuid: "u1"}},
+		&testDrive{"sr1", nil},
+	}}
+
+	matches, err := drive.NewSqliteMatchDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewApiHandler(discdb, matches, driveman, wfman, testProvider{})
+	e := echo.New()
+	api := e.Group("/api/v1")
+	api.GET("/openapi.yaml", h.OpenApi)
+	api.GET("/workflows", h.ListWorkflows)
+	api.GET("/workflows/:discId/:titleId", h.GetWorkflow)
+	api.PUT("/workflows/:discId/:titleId/metadata", h.SetMetadata)
+	api.POST("/workflows/:discId/:titleId/rip", h.RipTitle)
+	api.POST("/workflows/:discId/:titleId/ingest", h.Ingest)
+	api.POST("/workflows/:discId/:titleId/confirm", h.Confirm)
+	api.GET("/drives", h.ListDrives)
+	api.GET("/drives/:driveId", h.GetDrive)
+	api.GET("/drives/:driveId/info", h.GetDiscInfo)
+	api.POST("/drives/:driveId/backup", h.Backup)
+	api.GET("/search", h.Search)
+	return e, wfman
+}
+
+func doRequest(e *echo.Echo, method string, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestApiWorkflows(t *testing.T) {
+	e, wfman := newTestApi(t)
+	wf, _ := wfman.NewWorkflow("d1", 0, "MOVIE", "movie")
+	wf.Status = model.StatusDone
+	wfman.Save(wf)
+	other, _ := wfman.NewWorkflow("d2", 1, "OTHER", "other")
+	wfman.Save(other)
+
+	rec := doRequest(e, http.MethodGet, "/api/v1/workflows?status=Done", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /workflows = %d, expected: %d", rec.Code, http.StatusOK)
+	}
+	var wfs []model.Workflow
+	if err := json.Unmarshal(rec.Body.Bytes(), &wfs); err != nil {
+		t.Fatal(err)
+	}
+	if len(wfs) != 1 || wfs[0].DiscId != "d1" || wfs[0].Status != model.StatusDone {
+		t.Fatalf("GET /workflows?status=Done = %+v", wfs)
+	}
+
+	rec = doRequest(e, http.MethodGet, "/api/v1/workflows/d2/1", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Label":"OTHER"`) {
+		t.Fatalf("GET /workflows/d2/1 = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(e, http.MethodGet, "/api/v1/workflows/d2/9", "")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `"Error"`) {
+		t.Fatalf("GET /workflows/d2/9 = %d %s, expected a json 404", rec.Code, rec.Body.String())
+	}
+}
+
+func TestApiWorkflowErrors(t *testing.T) {
+	e, wfman := newTestApi(t)
+	wf, _ := wfman.NewWorkflow("d1", 0, "MOVIE", "movie")
+	wfman.Save(wf)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{http.MethodPut, "/api/v1/workflows/d1/0/metadata", `{}`, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/api/v1/workflows/d1/0/metadata", `{"ImdbId":"tt1","Season":1}`, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/api/v1/workflows/d9/0/metadata", `{"ImdbId":"tt1"}`, http.StatusNotFound},
+		{http.MethodPost, "/api/v1/workflows/d1/0/ingest", "", http.StatusConflict},
+		{http.MethodPost, "/api/v1/workflows/d9/0/rip", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/workflows/d1/5/rip", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/workflows/d1/0/rip?profile=extras", "", http.StatusUnprocessableEntity},
+		{http.MethodPost, "/api/v1/drives/sr0/backup", "", http.StatusConflict},
+		{http.MethodPost, "/api/v1/drives/sr1/backup", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/drives/sr9/backup", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := doRequest(e, tt.method, tt.target, tt.body)
+		if rec.Code != tt.code {
+			t.Fatalf("%s %s = %d %s, expected: %d", tt.method, tt.target, rec.Code, rec.Body.String(), tt.code)
+		}
+	}
+}
+
+func TestApiSetMetadata(t *testing.T) {
+	e, wfman := newTestApi(t)
+	wf, _ := wfman.NewWorkflow("d1", 0, "MOVIE", "movie")
+	wfman.Save(wf)
+
+	rec := doRequest(e, http.MethodPut, "/api/v1/workflows/d1/0/metadata", `{"TmdbId":"603","Edition":"Director's Cut"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT /metadata = %d %s", rec.Code, rec.Body.String())
+	}
+	wf = wfman.GetWorkflow("d1", 0)
+	if *wf.Name != "The Matrix" || *wf.Year != "1999" || *wf.ImdbId != "tt0133093" || *wf.TmdbId != "603" || *wf.Edition != "Director's Cut" {
+		t.Fatalf("PUT /metadata saved %+v", wf)
+	}
+
+	rec = doRequest(e, http.MethodPut, "/api/v1/workflows/d1/0/metadata", `{"ImdbId":"tt0000001"}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("PUT /metadata with an unknown id = %d, expected: %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	rec = doRequest(e, http.MethodGet, "/api/v1/search?q=matrix", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Tmdb":"603"`) {
+		t.Fatalf("GET /search = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestApiSetMetadataBusy(t *testing.T) {
+	e, wfman := newTestApi(t)
+	wf, _ := wfman.NewWorkflow("d1", 0, "MOVIE", "movie")
+	wf.TargetStatus("/movies").Status = model.IngestCopying
+	wfman.Save(wf)
+
+	for _, status := range []model.WorkflowStatus{model.StatusRipping, model.StatusTranscoding, model.StatusImporting} {
+		wf.Status = status
+		wfman.Save(wf)
+		rec := doRequest(e, http.MethodPut, "/api/v1/workflows/d1/0/metadata", `{"TmdbId":"603"}`)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("PUT /metadata while %s = %d, expected: %d", status, rec.Code, http.StatusConflict)
+		}
+	}
+	wf = wfman.GetWorkflow("d1", 0)
+	if wf.Name != nil || len(wf.Targets) != 1 || wf.Targets[0].Status != model.IngestCopying {
+		t.Fatalf("PUT /metadata while busy saved %+v", wf)
+	}
+}
+
+func TestApiConfirm(t *testing.T) {
+	e, wfman := newTestApi(t)
+	wf, _ := wfman.NewWorkflow("d1", 0, "MOVIE", "movie")
+	wfman.Save(wf)
+
+	if rec := doRequest(e, http.MethodPost, "/api/v1/workflows/d1/0/confirm", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("POST /confirm without a movie = %d, expected: %d", rec.Code, http.StatusConflict)
+	}
+
+	confidence := 0.3
+	wf.Name, wf.Year, wf.Confidence = &testMovie.Title, &testMovie.Year, &confidence
+	wfman.Save(wf)
+	rec := doRequest(e, http.MethodPost, "/api/v1/workflows/d1/0/confirm", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Confidence") {
+		t.Fatalf("POST /confirm = %d %s", rec.Code, rec.Body.String())
+	}
+	if wfman.GetWorkflow("d1", 0).NeedsReview() {
+		t.Fatal("expected workflow to no longer need review")
+	}
+}
+
+func TestApiDrives(t *testing.T) {
+	e, _ := newTestApi(t)
+
+	rec := doRequest(e, http.MethodGet, "/api/v1/drives", "")
+	var drives []apiDrive
+	if err := json.Unmarshal(rec.Body.Bytes(), &drives); err != nil {
+		t.Fatal(err)
+	}
+	if len(drives) != 2 || drives[0].Id != "sr0" || drives[0].Disc == nil || drives[0].Disc.Id != "d1" || drives[1].Disc != nil {
+		t.Fatalf("GET /drives = %+v", drives)
+	}
+
+	rec = doRequest(e, http.MethodGet, "/api/v1/drives/sr0/info", "")
+	var info makemkv.DiscInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "MOVIE" || len(info.Titles) != 1 {
+		t.Fatalf("GET /drives/sr0/info = %+v", info)
+	}
+
+	if rec := doRequest(e, http.MethodGet, "/api/v1/drives/sr1/info", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET /drives/sr1/info = %d, expected: %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := doRequest(e, http.MethodGet, "/api/v1/drives/sr9", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET /drives/sr9 = %d, expected: %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := doRequest(e, http.MethodGet, "/api/v1/openapi.yaml", ""); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "openapi:") {
+		t.Fatalf("GET /openapi.yaml = %d", rec.Code)
+	}
+}
+
+func TestRememberMatch(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	discdb, _ := drive.NewSqliteDiscDatabase(db)
+	matches, _ := drive.NewSqliteMatchDatabase(db)
+	wfman, err := workflow.NewSqliteWorkflowManager(db, discdb, nil, workflow.Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &makemkv.DiscInfo{Name: "MOVIE", Titles: []makemkv.TitleInfo{{Id: 0, FileName: "title_t00.mkv"}, {Id: 1, FileName: "title_t01.mkv"}}}
+	discdb.SaveDiscInfo(&drive.Disc{Id: "d1"}, info)
+	wf, _ := wfman.NewWorkflow("d1", 1, "MOVIE", "movie")
+	wfman.Save(wf)
+	if err := setMetadata(wfman, testProvider{}, wf, titleMetadata{ImdbId: "tt0133093"}); err != nil {
+		t.Fatal(err)
+	}
+	rememberMatch(discdb, matches, wf)
+
+	// another copy of the same disc
+	again, err := titleWorkflow(wfman, matches, testProvider{}, &drive.Disc{Id: "d2", Label: "MOVIE"}, info, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Name == nil || *again.Name != "The Matrix" || again.Confidence != nil {
+		t.Fatalf("titleWorkflow() of a remembered disc = %+v", again)
+	}
+
+	// other titles of the disc are still guessed
+	other, _ := titleWorkflow(wfman, matches, testProvider{}, &drive.Disc{Id: "d2", Label: "MOVIE"}, info, 0)
+	if other.Confidence == nil {
+		t.Fatalf("titleWorkflow() of another title = %+v, expected a guessed match", other)
+	}
+
+	// a different disc with the same label is held for review
+	pressing := &makemkv.DiscInfo{Name: "MOVIE", Titles: []makemkv.TitleInfo{{Id: 0, FileName: "title_t00.mkv", Duration: 2 * time.Hour}}}
+	labeled, _ := titleWorkflow(wfman, matches, testProvider{}, &drive.Disc{Id: "d3", Label: "MOVIE"}, pressing, 0)
+	if labeled.Name == nil || *labeled.Name != "The Matrix" || !labeled.NeedsReview() {
+		t.Fatalf("titleWorkflow() of a disc matched by label = %+v, expected it to need review", labeled)
+	}
+}
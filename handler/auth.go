@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aravance/mkv-ripper/auth"
+	authview "github.com/aravance/mkv-ripper/view/auth"
+	"github.com/labstack/echo/v4"
+)
+
+type AuthHandler struct {
+	store        auth.AuthStore
+	sessionTtl   time.Duration
+	secureCookie bool
+}
+
+func NewAuthHandler(store auth.AuthStore, sessionTtl time.Duration, secureCookie bool) AuthHandler {
+	return AuthHandler{store, sessionTtl, secureCookie}
+}
+
+func (h AuthHandler) GetLogin(c echo.Context) error {
+	return render(c, authview.Login("", false))
+}
+
+func (h AuthHandler) PostLogin(c echo.Context) error {
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+	if !h.store.CheckPassword(username, password) {
+		log.Println("failed login for", username, "from", c.RealIP())
+		c.Response().WriteHeader(http.StatusUnauthorized)
+		return render(c, authview.Login(username, true))
+	}
+
+	session, err := h.store.NewSession(username, h.sessionTtl)
+	if err != nil {
+		log.Println("error creating session", err)
+		return c.String(http.StatusInternalServerError, "error creating session")
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    session.Id,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   h.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (h AuthHandler) PostLogout(c echo.Context) error {
+	if cookie, err := c.Cookie(auth.SessionCookie); err == nil {
+		h.store.DeleteSession(cookie.Value)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
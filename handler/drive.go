@@ -1,16 +1,17 @@
 package handler
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/aravance/go-makemkv"
 	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/metadata"
 	"github.com/aravance/mkv-ripper/util"
 	driveview "github.com/aravance/mkv-ripper/view/drive"
 	"github.com/aravance/mkv-ripper/workflow"
-	"github.com/eefret/gomdb"
 	"github.com/labstack/echo/v4"
 )
 
@@ -18,30 +19,51 @@ type DriveHandler struct {
 	driveManager    drive.DriveManager
 	workflowManager workflow.WorkflowManager
 	discdb          drive.DiscDatabase
-	omdbapi         *gomdb.OmdbApi
+	matches         drive.MatchDatabase
+	provider        metadata.Provider
 }
 
-func NewDriveHandler(discdb drive.DiscDatabase, driveManager drive.DriveManager, workflowManager workflow.WorkflowManager, omdbapi *gomdb.OmdbApi) DriveHandler {
-	return DriveHandler{driveManager, workflowManager, discdb, omdbapi}
+func NewDriveHandler(discdb drive.DiscDatabase, matches drive.MatchDatabase, driveManager drive.DriveManager, workflowManager workflow.WorkflowManager, provider metadata.Provider) DriveHandler {
+	return DriveHandler{driveManager, workflowManager, discdb, matches, provider}
+}
+
+func (d DriveHandler) GetDrives(c echo.Context) error {
+	drives := d.driveManager.GetDrives()
+	if len(drives) == 1 {
+		return c.Redirect(http.StatusSeeOther, util.DriveUrl(drives[0].Id()))
+	}
+	return render(c, driveview.List(drives))
 }
 
 func (d DriveHandler) GetDrive(c echo.Context) error {
-	status := d.driveManager.Status()
-	disc := d.driveManager.GetDisc()
-	var movie *gomdb.MovieResult
+	dr, ok := d.driveManager.GetDrive(c.Param("driveId"))
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	status := dr.Status()
+	disc := dr.GetDisc()
+	var movie *metadata.Title
 	var info *makemkv.DiscInfo
-	if status == drive.StatusReady || status == drive.StatusMkv {
+	var ranking []util.TitleScore
+	profiles := d.workflowManager.Profiles()
+	profile := c.QueryParam("profile")
+	if disc != nil && (status == drive.StatusReady || status == drive.StatusMkv) {
 		var found bool
-		info, found = d.discdb.GetDiscInfo(disc.Uuid)
+		info, found = d.discdb.GetDiscInfo(disc.Id)
 		if found {
-			main := util.GuessMainTitle(info)
-			if main != nil {
-				var err error
+			if !profiles.Has(profile) {
+				profile = profiles.ForDisc(info)
+			}
+			// only list the titles that are long enough for the profile
+			ranking = util.RankTitles(profiles.Get(profile).Titles(info))
+			if len(ranking) > 0 {
+				main := ranking[0].Title
 				name := util.GuessName(info, main)
-				movie, err = util.GetMovie(name, d.omdbapi)
-				if err != nil {
+				if match, err := util.MatchMovie(d.provider, name, main.Duration, disc.Label, info.VolumeName); err != nil {
 					log.Println("error fetching movie:", name, "err:", err)
-					movie = nil
+				} else {
+					movie = match.Title
 				}
 			} else {
 				log.Println("failed to guess title and name")
@@ -53,55 +75,84 @@ func (d DriveHandler) GetDrive(c echo.Context) error {
 			}
 		}
 	}
-	return render(c, driveview.Show(status, disc, movie, info))
+	return render(c, driveview.Show(dr.Id(), status, disc, movie, info, ranking, profiles.Names(), profile, driveProgress(d.workflowManager, dr)))
 }
 
 func (d DriveHandler) GetDriveStatus(c echo.Context) error {
-	status := d.driveManager.Status()
+	dr, ok := d.driveManager.GetDrive(c.Param("driveId"))
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	status := dr.Status()
 	var disc *drive.Disc
-	if d.driveManager.HasDisc() {
-		disc = d.driveManager.GetDisc()
+	if dr.HasDisc() {
+		disc = dr.GetDisc()
 	}
-	return render(c, driveview.Status(status, disc))
+	return render(c, driveview.Status(dr.Id(), status, disc, driveProgress(d.workflowManager, dr)))
 }
 
-func (d DriveHandler) RipTitle(c echo.Context) error {
-	discId := c.Param("discId")
-	titleId, err := strconv.Atoi(c.Param("titleId"))
-	status := d.driveManager.Status()
-	if err != nil {
-		return c.String(http.StatusNotFound, "no title found")
+func (d DriveHandler) Eject(c echo.Context) error {
+	dr, ok := d.driveManager.GetDrive(c.Param("driveId"))
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
 	}
-	if status == drive.StatusEmpty {
-		return c.String(http.StatusNotFound, "drive is empty")
+
+	if err := dr.Eject(); err != nil {
+		return c.String(http.StatusConflict, fmt.Sprintf("error ejecting drive, %v", err))
 	}
-	if status != drive.StatusReady {
-		return c.String(http.StatusNotFound, "drive is busy")
+	return c.Redirect(http.StatusSeeOther, util.DriveUrl(dr.Id()))
+}
+
+func (d DriveHandler) QueueTitles(c echo.Context) error {
+	dr, ok := d.driveManager.GetDrive(c.Param("driveId"))
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
 	}
-	disc := d.driveManager.GetDisc()
-	if disc.Uuid != discId {
-		return c.String(http.StatusNotFound, "disc changed")
+	disc := dr.GetDisc()
+	if disc == nil {
+		return c.String(http.StatusNotFound, "drive is empty")
 	}
-	discInfo, ok := d.discdb.GetDiscInfo(discId)
+	discInfo, ok := d.discdb.GetDiscInfo(disc.Id)
 	if !ok {
 		return c.String(http.StatusNotFound, "disc info not found")
 	}
-	titleInfo := discInfo.Titles[titleId]
-	name := util.GuessName(discInfo, &titleInfo)
 
-	wf, ok := d.workflowManager.NewWorkflow(discId, titleId, disc.Label, name)
+	form, err := c.FormParams()
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("%v", err))
+	}
+	titles := form["title"]
+	if len(titles) == 0 {
+		return c.String(http.StatusUnprocessableEntity, "no titles selected")
+	}
+	profile := form.Get("profile")
+	if profile == "" {
+		profile = d.workflowManager.Profiles().ForDisc(discInfo)
+	} else if !d.workflowManager.Profiles().Has(profile) {
+		return c.String(http.StatusUnprocessableEntity, "unknown rip profile")
+	}
 
-	if wf.Name == nil || *wf.Name == "" || wf.Year == nil || *wf.Year == "" {
-		if movie, err := util.GetMovie(wf.OriginalName, d.omdbapi); err != nil {
-			log.Println("failed to GetMovie", err)
-		} else {
-			wf.Name = &movie.Title
-			wf.Year = &movie.Year
-			wf.ImdbId = &movie.ImdbID
-			d.workflowManager.Save(wf)
+	for _, t := range titles {
+		titleId, err := strconv.Atoi(t)
+		if err != nil {
+			return c.String(http.StatusNotFound, "no title found")
+		}
+		wf, err := titleWorkflow(d.workflowManager, d.matches, d.provider, disc, discInfo, titleId)
+		if err != nil {
+			return c.String(http.StatusNotFound, fmt.Sprintf("%v", err))
+		}
+		if err := enqueue(d.workflowManager, dr, wf, profile); err != nil {
+			log.Println("error queueing workflow", wf, "err:", err)
 		}
 	}
+	return c.Redirect(http.StatusSeeOther, "/")
+}
 
-	go d.workflowManager.Start(wf)
-	return c.Redirect(http.StatusSeeOther, util.WorkflowUrl(wf.DiscId, wf.TitleId))
+func (d DriveHandler) Backup(c echo.Context) error {
+	w, err := backupDisc(d.driveManager, d.discdb, d.matches, d.workflowManager, d.provider, c.Param("driveId"), c.FormValue("profile"))
+	if err != nil {
+		return errorString(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, util.WorkflowUrl(w.DiscId, w.TitleId))
 }
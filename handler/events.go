@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/event"
+	driveview "github.com/aravance/mkv-ripper/view/drive"
+	workflowview "github.com/aravance/mkv-ripper/view/workflow"
+	"github.com/aravance/mkv-ripper/workflow"
+	"github.com/labstack/echo/v4"
+)
+
+const keepaliveInterval = 30 * time.Second
+
+type EventHandler struct {
+	hub             *event.Hub
+	driveManager    drive.DriveManager
+	workflowManager workflow.WorkflowManager
+}
+
+func NewEventHandler(hub *event.Hub, driveManager drive.DriveManager, workflowManager workflow.WorkflowManager) EventHandler {
+	return EventHandler{hub, driveManager, workflowManager}
+}
+
+// Stream sends server-sent events with the rendered status of the drives and
+// workflows named by the "drive" and "workflow" query params. Events are
+// named "drive-<driveId>" and "workflow-<discId>-<titleId>", for use with the
+// htmx sse extension.
+func (h EventHandler) Stream(c echo.Context) error {
+	drives := c.QueryParams()["drive"]
+	workflows := c.QueryParams()["workflow"]
+
+	ch := h.hub.Subscribe()
+	defer h.hub.Unsubscribe(ch)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(res, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case e, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			for _, msg := range h.messages(e, drives, workflows) {
+				if err := writeEvent(ctx, res, msg.name, msg.component); err != nil {
+					log.Println("error writing event", msg.name, "err:", err)
+					return nil
+				}
+			}
+			res.Flush()
+		}
+	}
+}
+
+type message struct {
+	name      string
+	component templ.Component
+}
+
+func (h EventHandler) messages(e event.Event, drives []string, workflows []string) []message {
+	msgs := make([]message, 0, 2)
+	switch e.Type {
+	case event.TypeDrive:
+		if dr, ok := h.driveManager.GetDrive(e.Id); ok && slices.Contains(drives, e.Id) {
+			msgs = append(msgs, h.driveMessage(dr))
+		}
+
+	case event.TypeWorkflow, event.TypeProgress:
+		if slices.Contains(workflows, e.Id) {
+			if wf := h.workflowManager.GetWorkflow(e.DiscId, e.TitleId); wf != nil {
+				msgs = append(msgs, message{
+					name:      "workflow-" + e.Id,
+					component: workflowview.Status(wf, h.workflowManager.Progress(wf)),
+				})
+			}
+		}
+		if e.Type == event.TypeProgress {
+			// the drive page shows the rip progress too
+			if dr := drive.FindDisc(h.driveManager, e.DiscId); dr != nil && slices.Contains(drives, dr.Id()) {
+				msgs = append(msgs, h.driveMessage(dr))
+			}
+		}
+	}
+	return msgs
+}
+
+func (h EventHandler) driveMessage(dr drive.Drive) message {
+	return message{
+		name:      "drive-" + dr.Id(),
+		component: driveview.Status(dr.Id(), dr.Status(), dr.GetDisc(), driveProgress(h.workflowManager, dr)),
+	}
+}
+
+// driveProgress returns the progress of the rip running on the drive, if any.
+func driveProgress(wfman workflow.WorkflowManager, dr drive.Drive) *makemkv.Status {
+	disc := dr.GetDisc()
+	if disc == nil {
+		return nil
+	}
+	for _, wf := range wfman.GetWorkflows(disc.Id) {
+		if stat := wfman.Progress(wf); stat != nil {
+			return stat
+		}
+	}
+	return nil
+}
+
+func writeEvent(ctx context.Context, w io.Writer, name string, component templ.Component) error {
+	var b bytes.Buffer
+	if err := component.Render(ctx, &b); err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	out.WriteString("event: ")
+	out.WriteString(name)
+	out.WriteString("\n")
+	for _, line := range strings.Split(b.String(), "\n") {
+		out.WriteString("data: ")
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	out.WriteString("\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
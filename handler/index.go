@@ -27,14 +27,20 @@ func (i IndexHandler) GetIndex(c echo.Context) error {
 	done := make([]*model.Workflow, 0)
 	for _, wf := range all {
 		switch wf.Status {
+		case model.StatusQueued:
+			fallthrough
 		case model.StatusPending:
 			fallthrough
 		case model.StatusImporting:
 			fallthrough
+		case model.StatusTranscoding:
+			fallthrough
 		case model.StatusRipping:
 			active = append(active, wf)
 
 		case model.StatusError:
+			fallthrough
+		case model.StatusCancelled:
 			errored = append(errored, wf)
 
 		case model.StatusDone:
@@ -43,6 +49,10 @@ func (i IndexHandler) GetIndex(c echo.Context) error {
 	}
 
 	done = slices.DeleteFunc(done, func(wf *model.Workflow) bool {
+		// every episode on a disc is its own title
+		if wf.IsEpisode() {
+			return false
+		}
 		return slices.ContainsFunc(done, func(o *model.Workflow) bool {
 			return o.DiscId == wf.DiscId && o.TitleId > wf.TitleId
 		})
@@ -53,7 +63,13 @@ func (i IndexHandler) GetIndex(c echo.Context) error {
 			if wf == nil {
 				return o == nil
 			}
-			return wf.ImdbId != nil && o.ImdbId != nil && *wf.ImdbId == *o.ImdbId
+			if wf.IsEpisode() != o.IsEpisode() {
+				return false
+			}
+			if wf.IsEpisode() && (*wf.Season != *o.Season || *wf.Episode != *o.Episode) {
+				return false
+			}
+			return wf.SameTitle(o)
 		}
 		return slices.ContainsFunc(done, matches) || slices.ContainsFunc(active, matches)
 	})
@@ -62,8 +78,8 @@ func (i IndexHandler) GetIndex(c echo.Context) error {
 	slices.SortFunc(errored, compareWorkflows)
 	slices.SortFunc(done, compareWorkflows)
 
-	drstatus := i.driveManager.Status()
-	return render(c, indexview.Show(drstatus, active, errored, done))
+	drives := i.driveManager.GetDrives()
+	return render(c, indexview.Show(drives, active, errored, done))
 }
 
 func normalizeTitle(t string) string {
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aravance/mkv-ripper/metadata"
+	searchview "github.com/aravance/mkv-ripper/view/search"
+	"github.com/labstack/echo/v4"
+)
+
+type SearchHandler struct {
+	provider metadata.Provider
+}
+
+func NewSearchHandler(provider metadata.Provider) SearchHandler {
+	return SearchHandler{
+		provider: provider,
+	}
+}
+
+func (h SearchHandler) Search(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	titles, err := search(h.provider, c.QueryParam("q"), c.QueryParam("type"), limit)
+	if err != nil {
+		return err
+	}
+	return render(c, searchview.Search(titles))
+}
+
+// search returns the full details of up to limit search results, or all of
+// them if limit is 0. searchType is "series" for series, and movies
+// otherwise.
+func search(provider metadata.Provider, q string, searchType string, limit int) ([]*metadata.Title, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return make([]*metadata.Title, 0), nil
+	}
+	kind := metadata.Movie
+	if searchType == string(metadata.Series) {
+		kind = metadata.Series
+	}
+	titles, err := provider.Search(q, kind, limit)
+	if err != nil {
+		log.Println("error searching q:", q, "err:", err)
+		return nil, err
+	}
+	return titles, nil
+}
@@ -1,6 +1,10 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+
 	"github.com/a-h/templ"
 	"github.com/labstack/echo/v4"
 )
@@ -8,3 +12,13 @@ import (
 func render(c echo.Context, component templ.Component) error {
 	return component.Render(c.Request().Context(), c.Response())
 }
+
+// errorString responds with the message of an echo.HTTPError as plain text,
+// for the html handlers.
+func errorString(c echo.Context, err error) error {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return c.String(he.Code, fmt.Sprintf("%v", he.Message))
+	}
+	return c.String(http.StatusInternalServerError, fmt.Sprintf("%v", err))
+}
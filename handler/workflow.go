@@ -1,17 +1,20 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/aravance/go-makemkv"
 	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/metadata"
 	"github.com/aravance/mkv-ripper/model"
 	"github.com/aravance/mkv-ripper/util"
 	workflowview "github.com/aravance/mkv-ripper/view/workflow"
 	"github.com/aravance/mkv-ripper/workflow"
-	"github.com/eefret/gomdb"
 	"github.com/labstack/echo/v4"
 )
 
@@ -19,27 +22,30 @@ type WorkflowHandler struct {
 	wfman    workflow.WorkflowManager
 	driveman drive.DriveManager
 	discdb   drive.DiscDatabase
-	omdbapi  *gomdb.OmdbApi
+	matches  drive.MatchDatabase
+	provider metadata.Provider
 }
 
 func NewWorkflowHandler(
 	wfman workflow.WorkflowManager,
 	driveman drive.DriveManager,
 	discdb drive.DiscDatabase,
-	omdbapi *gomdb.OmdbApi,
+	matches drive.MatchDatabase,
+	provider metadata.Provider,
 ) WorkflowHandler {
 	return WorkflowHandler{
 		wfman:    wfman,
 		driveman: driveman,
 		discdb:   discdb,
-		omdbapi:  omdbapi,
+		matches:  matches,
+		provider: provider,
 	}
 }
 
 func (h WorkflowHandler) GetWorkflow(c echo.Context) error {
 	var w *model.Workflow
 	var d *drive.Disc
-	var m *gomdb.MovieResult
+	var m *metadata.Title
 
 	discId := c.Param("discId")
 	titleId, err := strconv.Atoi(c.Param("titleId"))
@@ -53,6 +59,9 @@ func (h WorkflowHandler) GetWorkflow(c echo.Context) error {
 		if !ok {
 			return c.NoContent(http.StatusNotFound)
 		}
+		if titleId < 0 || titleId >= len(di.Titles) {
+			return c.NoContent(http.StatusNotFound)
+		}
 		ti := &di.Titles[titleId]
 		name := util.GuessName(di, ti)
 
@@ -60,29 +69,38 @@ func (h WorkflowHandler) GetWorkflow(c echo.Context) error {
 		h.wfman.Save(w)
 	}
 
-	d = h.driveman.GetDisc()
-	if d != nil && d.Uuid != discId {
-		d = nil
+	if dr := drive.FindDisc(h.driveman, discId); dr != nil {
+		d = dr.GetDisc()
+	}
+	profiles := h.wfman.Profiles()
+	profile := w.Profile
+	if !profiles.Has(profile) {
+		di, _ := h.discdb.GetDiscInfo(discId)
+		profile = profiles.ForDisc(di)
 	}
 
-	if w.ImdbId != nil {
-		m, err = h.omdbapi.MovieByImdbID(*w.ImdbId)
+	if ids := util.TitleIds(w); !ids.IsEmpty() {
+		m, err = h.provider.Get(ids, titleKind(w))
 	} else {
 		name := w.Name
 		if name == nil {
 			name = &w.OriginalName
 		}
-		m, err = util.GetMovie(*name, h.omdbapi)
-		if err != nil {
-			log.Println("error getting movie:", *name, "err:", err)
-			m = nil
+		if w.IsEpisode() {
+			m, err = util.GetSeries(*name, h.provider)
+		} else {
+			m, err = util.GetMovie(*name, h.provider)
 		}
 	}
+	if err != nil {
+		log.Println("error getting movie:", w, "err:", err)
+		m = nil
+	}
 
 	if w == nil && d == nil {
 		return c.NoContent(http.StatusNotFound)
 	}
-	return render(c, workflowview.Show(w, d, m))
+	return render(c, workflowview.Show(w, d, m, profiles.Names(), profile, h.wfman.Progress(w)))
 }
 
 func (h WorkflowHandler) EditWorkflow(c echo.Context) error {
@@ -111,28 +129,82 @@ func (h WorkflowHandler) PostWorkflow(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
 	}
 
-	imdbid := c.FormValue("imdbid")
-	if imdbid == "" {
-		return c.String(http.StatusUnprocessableEntity, "imdbid cannot be empty")
+	md := titleMetadata{
+		ImdbId:  c.FormValue("imdbid"),
+		TmdbId:  c.FormValue("tmdbid"),
+		Edition: c.FormValue("edition"),
+	}
+	if c.FormValue("season") != "" || c.FormValue("episode") != "" {
+		season, err := strconv.Atoi(c.FormValue("season"))
+		if err != nil {
+			return c.String(http.StatusUnprocessableEntity, "invalid season")
+		}
+		episode, err := strconv.Atoi(c.FormValue("episode"))
+		if err != nil {
+			return c.String(http.StatusUnprocessableEntity, "invalid episode")
+		}
+		md.Season = &season
+		md.Episode = &episode
 	}
 
-	mov, err := h.omdbapi.MovieByImdbID(imdbid)
-	if err != nil {
-		log.Println("error fetching movie", imdbid, "err:", err)
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("error fetching movie, %v", err))
+	if err := setMetadata(h.wfman, h.provider, w, md); err != nil {
+		return errorString(c, err)
+	}
+	rememberMatch(h.discdb, h.matches, w)
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (h WorkflowHandler) CancelWorkflow(c echo.Context) error {
+	discId := c.Param("discId")
+	titleId, err := strconv.Atoi(c.Param("titleId"))
+	var w *model.Workflow
+	if err == nil {
+		w = h.wfman.GetWorkflow(discId, titleId)
+	}
+	if w == nil {
+		return c.NoContent(http.StatusNotFound)
 	}
 
-	w.Name = &mov.Title
-	w.Year = &mov.Year
-	w.ImdbId = &imdbid
-	if err := h.wfman.Save(w); err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("%v", err))
+	if err := h.wfman.Cancel(w); err != nil {
+		return c.String(http.StatusConflict, fmt.Sprintf("%v", err))
 	}
+	return c.Redirect(http.StatusSeeOther, util.WorkflowUrl(w.DiscId, w.TitleId))
+}
 
-	if w.File != nil {
-		go h.wfman.Ingest(w)
+// RetryIngest ingests the workflow again, targets that were already verified
+// are skipped.
+func (h WorkflowHandler) RetryIngest(c echo.Context) error {
+	discId := c.Param("discId")
+	titleId, err := strconv.Atoi(c.Param("titleId"))
+	var w *model.Workflow
+	if err == nil {
+		w = h.wfman.GetWorkflow(discId, titleId)
 	}
-	return c.Redirect(http.StatusSeeOther, "/")
+	if w == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if err := startIngest(h.wfman, w); err != nil {
+		return errorString(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, util.WorkflowUrl(w.DiscId, w.TitleId))
+}
+
+// ConfirmWorkflow accepts the automatic match of a workflow held for review.
+func (h WorkflowHandler) ConfirmWorkflow(c echo.Context) error {
+	discId := c.Param("discId")
+	titleId, err := strconv.Atoi(c.Param("titleId"))
+	var w *model.Workflow
+	if err == nil {
+		w = h.wfman.GetWorkflow(discId, titleId)
+	}
+	if w == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if err := confirmMatch(h.wfman, w); err != nil {
+		return errorString(c, err)
+	}
+	rememberMatch(h.discdb, h.matches, w)
+	return c.Redirect(http.StatusSeeOther, util.WorkflowUrl(w.DiscId, w.TitleId))
 }
 
 func (h WorkflowHandler) Status(c echo.Context) error {
@@ -145,46 +217,353 @@ func (h WorkflowHandler) Status(c echo.Context) error {
 	if w == nil {
 		return c.NoContent(http.StatusNotFound)
 	}
-	return render(c, workflowview.Status(w))
+	return render(c, workflowview.Status(w, h.wfman.Progress(w)))
 }
 
 func (h WorkflowHandler) RipTitle(c echo.Context) error {
 	discId := c.Param("discId")
 	titleId, err := strconv.Atoi(c.Param("titleId"))
-	status := h.driveman.Status()
 	if err != nil {
 		return c.String(http.StatusNotFound, "no title found")
 	}
-	if status == drive.StatusEmpty {
-		return c.String(http.StatusNotFound, "drive is empty")
+
+	wf, err := ripTitle(h.driveman, h.discdb, h.matches, h.wfman, h.provider, discId, titleId, c.FormValue("profile"))
+	if err != nil {
+		return errorString(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, util.WorkflowUrl(wf.DiscId, wf.TitleId))
+}
+
+// titleMetadata is what a user sets on a workflow, only one of the ids is
+// needed. Season and Episode are only set for episodes.
+type titleMetadata struct {
+	ImdbId  string
+	TmdbId  string
+	Season  *int
+	Episode *int
+	Edition string
+}
+
+// setMetadata looks up the movie or series by id and saves it on the
+// workflow, then ingests the file if it has already been ripped. Copies
+// already ingested under the old name are left on the targets.
+func setMetadata(wfman workflow.WorkflowManager, provider metadata.Provider, w *model.Workflow, md titleMetadata) error {
+	switch w.Status {
+	case model.StatusRipping, model.StatusTranscoding, model.StatusImporting:
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("workflow is %s", w.Status))
 	}
-	if status != drive.StatusReady {
-		return c.String(http.StatusNotFound, "drive is busy")
+	ids := metadata.Ids{Imdb: strings.TrimSpace(md.ImdbId), Tmdb: strings.TrimSpace(md.TmdbId)}
+	if ids.IsEmpty() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "imdbid or tmdbid must be set")
 	}
-	disc := h.driveman.GetDisc()
-	if disc.Uuid != discId {
-		return c.String(http.StatusNotFound, "disc changed")
+	if (md.Season == nil) != (md.Episode == nil) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "season and episode must be set together")
 	}
-	discInfo, ok := h.discdb.GetDiscInfo(discId)
+	if md.Season != nil && *md.Season < 0 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid season")
+	}
+	if md.Episode != nil && *md.Episode < 1 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid episode")
+	}
+	if md.Season != nil && w.IsBackup() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "disc backups cannot be episodes")
+	}
+
+	kind := titleKind(w)
+	if md.Season != nil {
+		kind = metadata.Series
+	}
+	mov, err := provider.Get(ids, kind)
+	if err != nil {
+		log.Println("error fetching movie", ids, "err:", err)
+		if errors.Is(err, metadata.ErrNoId) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("error fetching movie, %v", err))
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("error fetching movie, %v", err))
+	}
+
+	old, _ := w.Media()
+	if md.Season != nil {
+		w.Season = md.Season
+		w.Episode = md.Episode
+	}
+
+	if edition := strings.TrimSpace(md.Edition); edition != "" {
+		w.Edition = &edition
+	} else {
+		w.Edition = nil
+	}
+
+	util.SetTitle(w, mov)
+	w.Confidence = nil
+	// the file is named differently now, so ingest to every target again
+	logLeftBehind(w, old, w.Targets)
+	w.Targets = nil
+	for _, v := range w.Variants {
+		logLeftBehind(w, old, v.Targets)
+		v.Targets = nil
+	}
+	if err := wfman.Save(w); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%v", err))
+	}
+
+	if w.File != nil {
+		go wfman.Ingest(w)
+	}
+	return nil
+}
+
+// logLeftBehind logs the targets that still have a copy of the workflow's file
+// named after the old movie, they aren't removed when it is renamed.
+func logLeftBehind(w *model.Workflow, old model.Media, targets []*model.TargetStatus) {
+	for _, ts := range targets {
+		if ts.Status != model.IngestPending {
+			log.Println("leaving copy named", old.Name, old.Year, "on", ts.Target, "for renamed workflow", w)
+		}
+	}
+}
+
+// confirmMatch marks an automatic match as checked by a user, and ingests the
+// file if it was held for review.
+func confirmMatch(wfman workflow.WorkflowManager, w *model.Workflow) error {
+	if w.Name == nil || w.Year == nil {
+		return echo.NewHTTPError(http.StatusConflict, "no movie to confirm")
+	}
+	held := w.NeedsReview()
+	w.Confidence = nil
+	if err := wfman.Save(w); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%v", err))
+	}
+	if held && w.File != nil && w.Status == model.StatusPending {
+		go wfman.Ingest(w)
+	}
+	return nil
+}
+
+// rememberMatch records the movie a user picked or confirmed for the disc, so
+// the next copy of it is matched without asking.
+func rememberMatch(discdb drive.DiscDatabase, matches drive.MatchDatabase, w *model.Workflow) {
+	ids := util.TitleIds(w)
+	if w.IsEpisode() || w.IsBackup() || ids.IsEmpty() {
+		return
+	}
+	info, ok := discdb.GetDiscInfo(w.DiscId)
+	if !ok {
+		log.Println("no disc info to remember match for", w.DiscId)
+		return
+	}
+	m := drive.DiscMatch{
+		Label:       w.Label,
+		Fingerprint: drive.Fingerprint(info),
+		TitleId:     w.TitleId,
+		Ids:         ids,
+	}
+	if err := matches.SaveMatch(m); err != nil {
+		log.Println("error saving disc match", m, "err:", err)
+	}
+}
+
+// startIngest ingests the workflow in the background, targets that were
+// already verified are skipped.
+func startIngest(wfman workflow.WorkflowManager, w *model.Workflow) error {
+	if w.File == nil {
+		return echo.NewHTTPError(http.StatusConflict, "no file to ingest")
+	}
+	if w.Status != model.StatusError && w.Status != model.StatusCancelled && w.Status != model.StatusPending {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("workflow is %s", w.Status))
+	}
+
+	go wfman.Ingest(w)
+	return nil
+}
+
+// backupDisc queues a full backup of the disc in a drive, see ripTitle for the
+// profile.
+func backupDisc(
+	driveman drive.DriveManager,
+	discdb drive.DiscDatabase,
+	matches drive.MatchDatabase,
+	wfman workflow.WorkflowManager,
+	provider metadata.Provider,
+	driveId string,
+	profile string,
+) (*model.Workflow, error) {
+	dr, ok := driveman.GetDrive(driveId)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "drive not found")
+	}
+	disc := dr.GetDisc()
+	if disc == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "drive is empty")
+	}
+	if disc.Id == "" {
+		return nil, echo.NewHTTPError(http.StatusConflict, "disc has not been read yet")
+	}
+	return ripTitle(driveman, discdb, matches, wfman, provider, disc.Id, model.BackupTitle, profile)
+}
+
+// ripTitle queues a title of a disc that is in one of the drives, or a backup
+// of the whole disc for model.BackupTitle. An empty profile keeps the one the
+// workflow was ripped with before, or the default for the disc.
+func ripTitle(
+	driveman drive.DriveManager,
+	discdb drive.DiscDatabase,
+	matches drive.MatchDatabase,
+	wfman workflow.WorkflowManager,
+	provider metadata.Provider,
+	discId string,
+	titleId int,
+	profile string,
+) (*model.Workflow, error) {
+	if profile != "" && !wfman.Profiles().Has(profile) {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "unknown rip profile")
+	}
+	dr := drive.FindDisc(driveman, discId)
+	if dr == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "disc not found")
+	}
+	disc := dr.GetDisc()
+	if disc == nil || disc.Id != discId {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "disc changed")
+	}
+	discInfo, ok := discdb.GetDiscInfo(discId)
 	if !ok {
-		return c.String(http.StatusNotFound, "disc info not found")
+		return nil, echo.NewHTTPError(http.StatusNotFound, "disc info not found")
+	}
+
+	var wf *model.Workflow
+	if titleId == model.BackupTitle {
+		wf = backupWorkflow(wfman, matches, provider, disc, discInfo)
+	} else {
+		var err error
+		wf, err = titleWorkflow(wfman, matches, provider, disc, discInfo, titleId)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%v", err))
+		}
+	}
+	if profile == "" && !wfman.Profiles().Has(wf.Profile) {
+		profile = wfman.Profiles().ForDisc(discInfo)
+	}
+	if err := enqueue(wfman, dr, wf, profile); err != nil {
+		return nil, echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("%v", err))
+	}
+	return wf, nil
+}
+
+// enqueue queues the workflow to be ripped with the profile, or with the one
+// it already has for an empty profile.
+func enqueue(wfman workflow.WorkflowManager, dr drive.Drive, wf *model.Workflow, profile string) error {
+	prev := wf.Profile
+	if profile != "" {
+		wf.Profile = profile
+	}
+	if err := wfman.Enqueue(dr, wf); err != nil {
+		wf.Profile = prev
+		return err
+	}
+	return nil
+}
+
+// titleWorkflow returns the workflow for a title on the disc, looking up the
+// movie details if they haven't been set yet.
+func titleWorkflow(
+	wfman workflow.WorkflowManager,
+	matches drive.MatchDatabase,
+	provider metadata.Provider,
+	disc *drive.Disc,
+	discInfo *makemkv.DiscInfo,
+	titleId int,
+) (*model.Workflow, error) {
+	if titleId < 0 || titleId >= len(discInfo.Titles) {
+		return nil, fmt.Errorf("no title found")
 	}
 	titleInfo := discInfo.Titles[titleId]
 	name := util.GuessName(discInfo, &titleInfo)
 
-	wf, ok := h.wfman.NewWorkflow(discId, titleId, disc.Label, name)
+	wf, _ := wfman.NewWorkflow(disc.Id, titleId, disc.Label, name)
 
 	if wf.Name == nil || *wf.Name == "" || wf.Year == nil || *wf.Year == "" {
-		if movie, err := util.GetMovie(wf.OriginalName, h.omdbapi); err != nil {
-			log.Println("failed to GetMovie", err)
+		matchWorkflow(wfman, matches, provider, disc, discInfo, &titleInfo, wf)
+	}
+	return wf, nil
+}
+
+// backupWorkflow returns the workflow that backs up the whole disc. It is
+// named after the movie of the disc's main title.
+func backupWorkflow(
+	wfman workflow.WorkflowManager,
+	matches drive.MatchDatabase,
+	provider metadata.Provider,
+	disc *drive.Disc,
+	discInfo *makemkv.DiscInfo,
+) *model.Workflow {
+	wf, _ := wfman.NewWorkflow(disc.Id, model.BackupTitle, disc.Label, discInfo.VolumeName)
+	if wf.Name != nil && *wf.Name != "" && wf.Year != nil && *wf.Year != "" {
+		return wf
+	}
+	main := util.GuessMainTitle(discInfo)
+	if main == nil {
+		return wf
+	}
+
+	if mwf := wfman.GetWorkflow(disc.Id, main.Id); mwf != nil && !mwf.IsEpisode() && mwf.Name != nil && mwf.Year != nil {
+		name, year := *mwf.Name, *mwf.Year
+		wf.Name, wf.Year = &name, &year
+		wf.ImdbId, wf.TmdbId, wf.Confidence = nil, nil, nil
+		if mwf.ImdbId != nil {
+			imdbId := *mwf.ImdbId
+			wf.ImdbId = &imdbId
+		}
+		if mwf.TmdbId != nil {
+			tmdbId := *mwf.TmdbId
+			wf.TmdbId = &tmdbId
+		}
+		if mwf.Confidence != nil {
+			confidence := *mwf.Confidence
+			wf.Confidence = &confidence
+		}
+		wfman.Save(wf)
+		return wf
+	}
+
+	wf.OriginalName = util.GuessName(discInfo, main)
+	matchWorkflow(wfman, matches, provider, disc, discInfo, main, wf)
+	return wf
+}
+
+// matchWorkflow sets the movie of a workflow for a title of the disc. A movie
+// picked for the same disc before is used over guessing one.
+func matchWorkflow(
+	wfman workflow.WorkflowManager,
+	matches drive.MatchDatabase,
+	provider metadata.Provider,
+	disc *drive.Disc,
+	discInfo *makemkv.DiscInfo,
+	titleInfo *makemkv.TitleInfo,
+	wf *model.Workflow,
+) {
+	if m, ok := drive.FindMatch(matches, disc.Label, discInfo); ok && m.TitleId == titleInfo.Id {
+		if mov, err := provider.Get(m.Ids, metadata.Movie); err != nil {
+			log.Println("error fetching remembered movie", m.Ids, "err:", err)
 		} else {
-			wf.Name = &movie.Title
-			wf.Year = &movie.Year
-			wf.ImdbId = &movie.ImdbID
-			h.wfman.Save(wf)
+			util.SetTitle(wf, mov)
+			wf.Confidence = drive.MatchConfidence(m, discInfo)
+			wfman.Save(wf)
+			return
 		}
 	}
+	if match, err := util.MatchMovie(provider, wf.OriginalName, titleInfo.Duration, disc.Label, discInfo.VolumeName); err != nil {
+		log.Println("failed to MatchMovie", err)
+	} else {
+		util.SetTitle(wf, match.Title)
+		wf.Confidence = &match.Confidence
+		wfman.Save(wf)
+	}
+}
 
-	go h.wfman.Start(wf)
-	return c.Redirect(http.StatusSeeOther, util.WorkflowUrl(wf.DiscId, wf.TitleId))
+func titleKind(w *model.Workflow) metadata.Kind {
+	if w.IsEpisode() {
+		return metadata.Series
+	}
+	return metadata.Movie
 }
@@ -0,0 +1,49 @@
+package ingest
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sameDevice is true if a and b are on the same filesystem.
+func sameDevice(a string, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	da, ok := sa.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	db, ok := sb.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return da.Dev == db.Dev
+}
+
+// reflink makes dst a copy on write clone of src, on filesystems that support
+// it like btrfs and xfs. dst must not exist yet.
+func reflink(src string, dst string) error {
+	i, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer i.Close()
+	o, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0664)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(o.Fd()), int(i.Fd())); err != nil {
+		o.Close()
+		os.Remove(dst)
+		return err
+	}
+	return o.Close()
+}
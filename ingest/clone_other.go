@@ -0,0 +1,13 @@
+//go:build !linux
+
+package ingest
+
+import "errors"
+
+func sameDevice(a string, b string) bool {
+	return false
+}
+
+func reflink(src string, dst string) error {
+	return errors.ErrUnsupported
+}
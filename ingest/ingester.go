@@ -1,26 +1,126 @@
 package ingest
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"net/url"
 
 	"github.com/aravance/mkv-ripper/model"
 )
 
+// Progress is told how many of the total bytes of a file are at the target,
+// and how many of those were resumed from an interrupted copy rather than
+// copied now.
+type Progress func(copied int64, resumed int64, total int64)
+
 type Ingester interface {
-	Ingest(mkv model.MkvFile, name string, year string) error
+	Ingest(ctx context.Context, mkv model.MkvFile, media model.Media, progress Progress) error
+}
+
+// Target is a location that ripped files are ingested to, and how the files
+// are named there. Key and KnownHosts are the private key file and known_hosts
+// file of sftp targets, both default to the ones in ~/.ssh. S3 is how to reach
+// the store of s3 targets.
+type Target struct {
+	Url        *url.URL
+	Naming     *Naming
+	Key        string
+	KnownHosts string
+	Password   string
+	Verify     bool
+	S3         S3Config
 }
 
-func NewIngester(u *url.URL, useMovieDir bool, shafile string) (Ingester, error) {
-	switch u.Scheme {
+func NewIngester(t Target, shafile string) (Ingester, error) {
+	naming := t.Naming
+	if naming == nil {
+		naming = DefaultNaming(false)
+	}
+	switch t.Url.Scheme {
 	case "", "file":
-		log.Println("file ingester", u)
-		return &LocalIngester{u, useMovieDir, shafile}, nil
+		log.Println("file ingester", t.Url)
+		return &LocalIngester{t.Url, naming, shafile, t.Verify}, nil
 	case "ssh":
-		log.Println("ssh ingester", u)
-		return &SshIngester{u, useMovieDir, shafile}, nil
+		log.Println("ssh ingester", t.Url)
+		return &SshIngester{t.Url, naming, shafile}, nil
+	case "sftp":
+		log.Println("sftp ingester", t.Url)
+		return &SftpIngester{t.Url, naming, shafile, t.Key, t.KnownHosts}, nil
+	case "s3":
+		log.Println("s3 ingester", t.Url)
+		return newS3Ingester(t.Url, naming, shafile, t.S3)
+	case "webdav", "webdavs":
+		log.Println("webdav ingester", t.Url)
+		return &WebdavIngester{t.Url, naming, shafile, t.Password, http.DefaultClient}, nil
 	default:
-		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+		return nil, fmt.Errorf("unsupported scheme: %s", t.Url.Scheme)
+	}
+}
+
+// contextReader stops reading once its context is cancelled, so long copies
+// can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// copyProgress counts the bytes copied of one or more files, and reports them
+// about every tenth of a percent.
+type copyProgress struct {
+	progress Progress
+	copied   int64
+	resumed  int64
+	total    int64
+	next     int64
+}
+
+func newCopyProgress(progress Progress, total int64) *copyProgress {
+	return &copyProgress{progress: progress, total: total}
+}
+
+func (p *copyProgress) add(n int) {
+	if p == nil || p.progress == nil || n == 0 {
+		return
 	}
+	p.copied += int64(n)
+	p.report()
+}
+
+// resume counts n bytes that an interrupted copy left at the target.
+func (p *copyProgress) resume(n int64) {
+	if p == nil || p.progress == nil || n == 0 {
+		return
+	}
+	p.copied += n
+	p.resumed += n
+	p.report()
+}
+
+func (p *copyProgress) report() {
+	if p.copied >= p.next || p.copied >= p.total {
+		p.progress(p.copied, p.resumed, p.total)
+		p.next = p.copied + max(p.total/1000, 1)
+	}
+}
+
+// progressReader adds everything read to its copyProgress.
+type progressReader struct {
+	r io.Reader
+	p *copyProgress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.add(n)
+	return n, err
 }
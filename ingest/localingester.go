@@ -2,6 +2,8 @@ package ingest
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -17,37 +20,60 @@ import (
 	"github.com/aravance/mkv-ripper/util"
 )
 
+// LocalIngester copies files to a local or mounted folder. When the folder is
+// on the same filesystem as the rip, files are cloned or hard linked instead,
+// and only read back to check their shasum with verify set.
 type LocalIngester struct {
-	uri         *url.URL
-	useMovieDir bool
-	shafile     string
+	uri     *url.URL
+	naming  *Naming
+	shafile string
+	verify  bool
 }
 
 func readShasums(shafile string) (map[string]string, error) {
-	_, err := os.Stat(shafile)
-	var lines []string
-	if err == nil {
-		b, err := os.ReadFile(shafile)
-		if err != nil {
-			return nil, err
-		}
-		lines = strings.Split(string(b), "\n")
-	} else if errors.Is(err, fs.ErrNotExist) {
-		err = nil
-		lines = []string{}
-	} else {
+	b, err := os.ReadFile(shafile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, err
 	}
+	return parseShasums(b), nil
+}
+
+// parseShasums reads a sha256sum listing into sums by file name.
+func parseShasums(b []byte) map[string]string {
+	lines := strings.Split(string(b), "\n")
 	shasums := make(map[string]string, len(lines)+1)
 	for _, line := range lines {
 		if len(line) > 66 {
 			shasums[line[66:]] = line[0:64]
 		}
 	}
-	return shasums, nil
+	return shasums
 }
 
+// writeShasums writes the shasums next to shafile, syncs it, and renames it
+// over shafile, so it's never left half written.
 func writeShasums(shafile string, shasums map[string]string) error {
+	tmp := path.Join(path.Dir(shafile), "."+path.Base(shafile)+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(formatShasums(shasums)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, shafile)
+}
+
+// formatShasums writes sums as a sha256sum listing sorted by file name.
+func formatShasums(shasums map[string]string) []byte {
 	keys := make([]string, 0, len(shasums))
 	for k := range shasums {
 		keys = append(keys, k)
@@ -60,20 +86,17 @@ func writeShasums(shafile string, shasums map[string]string) error {
 		buffer.WriteString(key)
 		buffer.WriteString("\n")
 	}
-	os.WriteFile(shafile, buffer.Bytes(), 0644)
-	return nil
+	return buffer.Bytes()
 }
 
-func (t *LocalIngester) Ingest(mkv model.MkvFile, name string, year string) error {
-	moviedir := fmt.Sprintf("%s (%s)", name, year)
-	mkvfile := fmt.Sprintf("%s (%s) [%s].mkv", name, year, mkv.Resolution)
-
-	var newdir string
-	if t.useMovieDir {
-		newdir = path.Join(t.uri.Path, moviedir)
-	} else {
-		newdir = path.Join(t.uri.Path)
+func (t *LocalIngester) Ingest(ctx context.Context, mkv model.MkvFile, media model.Media, progress Progress) error {
+	moviedir, mkvfile, err := t.naming.Path(mkv, media)
+	if err != nil {
+		log.Println("error naming file", err)
+		return err
 	}
+
+	newdir := path.Join(t.uri.Path, moviedir)
 	newfile := path.Join(newdir, mkvfile)
 	ingestfile := path.Join(t.uri.Path, ".input", path.Base(mkv.Filename))
 	var shafile string
@@ -83,34 +106,80 @@ func (t *LocalIngester) Ingest(mkv model.MkvFile, name string, year string) erro
 		shafile = path.Join(t.uri.Path, t.shafile)
 	}
 
-	err := os.MkdirAll(path.Join(t.uri.Path, ".input"), 0775)
+	inputdir := path.Join(t.uri.Path, ".input")
+	err = os.MkdirAll(inputdir, 0775)
 	if err != nil {
 		log.Println("error making input dir", err)
 		return err
 	}
-	i, err := os.Open(mkv.Filename)
+	stat, err := os.Stat(mkv.Filename)
 	if err != nil {
-		log.Println("error opening existing file", i, err)
+		log.Println("error opening existing file", mkv.Filename, err)
 		return err
 	}
-	o, err := os.Create(ingestfile)
-	if err != nil {
-		log.Println("error opening write file", i, err)
-		return err
+
+	var shasum string
+	var sums map[string]string
+	linked, hardlinked := false, false
+	if canLink(mkv.Filename, inputdir) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// linked next to the ingest file, so a partial copy from before is
+		// only replaced once the link worked
+		linkfile := ingestfile + ".link"
+		os.RemoveAll(linkfile)
+		if stat.IsDir() {
+			hardlinked, err = linkDir(mkv.Filename, linkfile)
+		} else {
+			hardlinked, err = linkFile(mkv.Filename, linkfile)
+		}
+		if err == nil {
+			if err = os.RemoveAll(ingestfile); err == nil {
+				err = os.Rename(linkfile, ingestfile)
+			}
+		}
+		if err != nil {
+			log.Println("error linking file, copying instead", mkv.Filename, err)
+			os.RemoveAll(linkfile)
+			hardlinked = false
+		} else {
+			linked = true
+		}
+	}
+
+	if linked {
+		// the shasums file needs the sum of every file in a folder, a
+		// single file is only read back to verify it
+		if stat.IsDir() {
+			sums, err = util.Sha256sums(ingestfile)
+			shasum = util.Sha256sumListing(sums)
+		} else if t.verify {
+			shasum, err = util.Sha256sum(ingestfile)
+		} else {
+			shasum = mkv.Shasum
+		}
+		if err == nil && progress != nil {
+			progress(stat.Size(), stat.Size(), stat.Size())
+		}
+	} else if stat.IsDir() {
+		// the sha256sum is taken while copying, a partial copy left by a
+		// crash or cancel is picked up where it stopped
+		sums, err = copyDir(ctx, mkv.Filename, ingestfile, progress)
+		shasum = util.Sha256sumListing(sums)
+	} else {
+		p := newCopyProgress(progress, stat.Size())
+		shasum, err = copyFile(ctx, mkv.Filename, ingestfile, p)
 	}
-	_, err = io.Copy(o, i)
 	if err != nil {
-		log.Println("error copying file", i, err)
+		log.Println("error copying file", mkv.Filename, err)
 		return err
 	}
 
 	// check sha256sum
 	log.Println("Checking shasum", ingestfile)
-	shasum, err := util.Sha256sum(ingestfile)
-	if err != nil {
-		return err
-	}
 	if shasum != mkv.Shasum {
+		os.RemoveAll(ingestfile)
 		return fmt.Errorf("shasum does not match expected: %s, actual: %s", mkv.Shasum, shasum)
 	}
 
@@ -120,8 +189,12 @@ func (t *LocalIngester) Ingest(mkv model.MkvFile, name string, year string) erro
 		return err
 	}
 
-	// fix permissions
-	err = os.Chmod(ingestfile, 0664)
+	// fix permissions, hard linked files share them with the rip
+	if stat.IsDir() {
+		err = chmodDir(ingestfile, !hardlinked)
+	} else if !hardlinked {
+		err = os.Chmod(ingestfile, 0664)
+	}
 	if err != nil {
 		return err
 	}
@@ -133,13 +206,14 @@ func (t *LocalIngester) Ingest(mkv model.MkvFile, name string, year string) erro
 		return err
 	}
 
-	var shakey string
-	if t.useMovieDir {
-		shakey = path.Join(moviedir, mkvfile)
+	shakey := path.Join(moviedir, mkvfile)
+	if stat.IsDir() {
+		for name, sum := range sums {
+			shasums[path.Join(shakey, name)] = sum
+		}
 	} else {
-		shakey = path.Join(mkvfile)
+		shasums[shakey] = shasum
 	}
-	shasums[shakey] = shasum
 
 	err = writeShasums(shafile, shasums)
 	if err != nil {
@@ -152,7 +226,232 @@ func (t *LocalIngester) Ingest(mkv model.MkvFile, name string, year string) erro
 	if err != nil {
 		return err
 	}
+	if err := syncDir(newdir); err != nil {
+		return err
+	}
 
 	log.Println("Done.")
 	return nil
 }
+
+// canLink is true if files can be cloned or linked from src to dst, rather
+// than copied.
+var canLink = sameDevice
+
+// linkFile makes dst a reflink clone of src, or a hard link to it if the
+// filesystem can't clone, and returns true if it's a hard link. dst must not
+// exist yet.
+func linkFile(src string, dst string) (bool, error) {
+	err := reflink(src, dst)
+	if err == nil {
+		log.Println("cloned", src, "to", dst)
+		return false, nil
+	}
+	if err := os.Link(src, dst); err != nil {
+		return false, err
+	}
+	log.Println("linked", src, "to", dst)
+	return true, nil
+}
+
+// linkDir links every file of a disc backup folder with linkFile, and returns
+// true if any of them is a hard link. dst must not exist yet.
+func linkDir(src string, dst string) (bool, error) {
+	hardlinked := false
+	err := filepath.WalkDir(src, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, f)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.Mkdir(out, 0775)
+		}
+		hard, err := linkFile(f, out)
+		hardlinked = hardlinked || hard
+		return err
+	})
+	return hardlinked, err
+}
+
+// copyFile copies src to dst and returns the sha256sum of the copy. If dst
+// already holds the start of src it's kept, and only the rest is copied. dst
+// is synced before it's closed.
+func copyFile(ctx context.Context, src string, dst string, p *copyProgress) (string, error) {
+	i, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer i.Close()
+	o, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		return "", err
+	}
+	defer o.Close()
+
+	h := sha256.New()
+	n, err := matchPrefix(ctx, i, o, h)
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		log.Println("resuming copy of", src, "at", n)
+		p.resume(n)
+	}
+	if _, err := i.Seek(n, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := o.Seek(n, io.SeekStart); err != nil {
+		return "", err
+	}
+	if err := o.Truncate(n); err != nil {
+		return "", err
+	}
+
+	w := io.MultiWriter(o, h)
+	if _, err := io.Copy(w, progressReader{contextReader{ctx, i}, p}); err != nil {
+		return "", err
+	}
+	if err := o.Sync(); err != nil {
+		return "", err
+	}
+	if err := o.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// matchPrefix compares src and dst a block at a time from the start, and
+// returns how many bytes they share up to the first block that differs. The
+// shared bytes are written to h.
+func matchPrefix(ctx context.Context, src io.Reader, dst io.Reader, h io.Writer) (int64, error) {
+	const blockSize = 1 << 20
+	a := make([]byte, blockSize)
+	b := make([]byte, blockSize)
+	var n int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		na, err := io.ReadFull(dst, a)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if na == 0 {
+			return n, nil
+		}
+		nb, err := io.ReadFull(src, b[:na])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if nb != na || !bytes.Equal(a[:na], b[:nb]) {
+			return n, nil
+		}
+		h.Write(a[:na])
+		n += int64(na)
+	}
+}
+
+// copyDir copies a disc backup folder, and returns the sha256sum of every
+// file by its path relative to dst like util.Sha256sums. Files left in dst by
+// an interrupted copy are resumed, or removed if they're not in src.
+func copyDir(ctx context.Context, src string, dst string, progress Progress) (map[string]string, error) {
+	var total int64
+	err := filepath.WalkDir(src, func(f string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p := newCopyProgress(progress, total)
+
+	sums := make(map[string]string)
+	err = filepath.WalkDir(src, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, f)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(out, 0775)
+		}
+		sum, err := copyFile(ctx, f, out, p)
+		if err != nil {
+			return err
+		}
+		sums["./"+filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// clear out anything a previous copy left that isn't in src
+	var extra []string
+	err = filepath.WalkDir(dst, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, f)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); errors.Is(err, fs.ErrNotExist) {
+			extra = append(extra, f)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range extra {
+		if err := os.RemoveAll(f); err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// syncDir syncs dir so the files renamed into it are on disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// chmodDir fixes the permissions of dir and its folders, and of its files
+// with files set.
+func chmodDir(dir string, files bool) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(p, 0775)
+		}
+		if !files {
+			return nil
+		}
+		return os.Chmod(p, 0664)
+	})
+}
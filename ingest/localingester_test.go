@@ -1,6 +1,7 @@
 package ingest
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
 )
 
 const testdir = "localingester_test"
@@ -29,6 +31,8 @@ const name = "bar"
 const year = "1989"
 const res = "1080p"
 
+var media = model.Media{Name: name, Year: year}
+
 var mkvfile = model.MkvFile{
 	Filename:   fmt.Sprintf("%s/bar.mkv", testdir),
 	Shasum:     shasum,
@@ -43,8 +47,8 @@ func TestIngest_movieDir(t *testing.T) {
 	createMkvFile(t)
 	createShaFile(t, useMovieDir)
 
-	ingester := LocalIngester{&url.URL{Path: testdir}, useMovieDir, "movies.sha256"}
-	if err := ingester.Ingest(mkvfile, name, year); err != nil {
+	ingester := LocalIngester{&url.URL{Path: testdir}, DefaultNaming(useMovieDir), "movies.sha256", false}
+	if err := ingester.Ingest(context.Background(), mkvfile, media, nil); err != nil {
 		t.Fatalf("ingester.Ingest(m, %s, %s) error: %v", name, year, err)
 	}
 
@@ -65,8 +69,8 @@ func TestIngest_noMovieDir(t *testing.T) {
 	createMkvFile(t)
 	createShaFile(t, useMovieDir)
 
-	ingester := LocalIngester{&url.URL{Path: testdir}, useMovieDir, "movies.sha256"}
-	if err := ingester.Ingest(mkvfile, name, year); err != nil {
+	ingester := LocalIngester{&url.URL{Path: testdir}, DefaultNaming(useMovieDir), "movies.sha256", false}
+	if err := ingester.Ingest(context.Background(), mkvfile, media, nil); err != nil {
 		t.Fatalf("ingester.Ingest(m, %s, %s) error: %v", name, year, err)
 	}
 
@@ -86,8 +90,8 @@ func TestIngestNoShafile(t *testing.T) {
 	useMovieDir := false
 	createMkvFile(t)
 
-	ingester := LocalIngester{&url.URL{Path: testdir}, useMovieDir, "movies.sha256"}
-	if err := ingester.Ingest(mkvfile, name, year); err != nil {
+	ingester := LocalIngester{&url.URL{Path: testdir}, DefaultNaming(useMovieDir), "movies.sha256", false}
+	if err := ingester.Ingest(context.Background(), mkvfile, media, nil); err != nil {
 		t.Fatalf("ingester.Ingest(m, %s, %s) error: %v", name, year, err)
 	}
 
@@ -145,3 +149,231 @@ func compareShaFile(t *testing.T, expected string) {
 		t.Fatalf("shafile mismatch\n  --- actual ---\n%s\n\n  --- expected ---\n%s", strings.TrimSpace(content), strings.TrimSpace(expected))
 	}
 }
+
+func TestIngestCancelled(t *testing.T) {
+	createTestDir(t)
+	defer os.RemoveAll(testdir)
+
+	useMovieDir := false
+	createMkvFile(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ingester := LocalIngester{&url.URL{Path: testdir}, DefaultNaming(useMovieDir), "movies.sha256", false}
+	if err := ingester.Ingest(ctx, mkvfile, media, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ingester.Ingest(ctx, m, %s, %s) = %v, expected: %v", name, year, err, context.Canceled)
+	}
+
+	// the partial copy is kept to be resumed
+	if err := ingester.Ingest(context.Background(), mkvfile, media, nil); err != nil {
+		t.Fatalf("ingester.Ingest(m, %s, %s) error: %v", name, year, err)
+	}
+	statMkvFile(t, useMovieDir)
+}
+
+// noLink makes the ingester copy files for the rest of the test.
+func noLink(t *testing.T) {
+	canLink = func(string, string) bool { return false }
+	t.Cleanup(func() { canLink = sameDevice })
+}
+
+func TestIngestResume(t *testing.T) {
+	noLink(t)
+	for partial, expected := range map[string]int64{"foo": 3, "fxx": 0, "foobarbaz": 0} {
+		createTestDir(t)
+
+		useMovieDir := false
+		createMkvFile(t)
+		ingestfile := fmt.Sprintf("%s/.input/bar.mkv", testdir)
+		if err := os.MkdirAll(testdir+"/.input", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(ingestfile, []byte(partial), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var copied, resumed, total int64
+		progress := func(c int64, r int64, tot int64) { copied, resumed, total = c, r, tot }
+		ingester := LocalIngester{&url.URL{Path: testdir}, DefaultNaming(useMovieDir), "movies.sha256", false}
+		if err := ingester.Ingest(context.Background(), mkvfile, media, progress); err != nil {
+			t.Fatalf("ingester.Ingest() with partial %q error: %v", partial, err)
+		}
+		if copied != int64(len(mkvfileContent)) || total != copied {
+			t.Fatalf("progress with partial %q = %d/%d, expected: %d/%d", partial, copied, total, len(mkvfileContent), len(mkvfileContent))
+		}
+		// the kept prefix isn't counted as copied now
+		if resumed != expected {
+			t.Fatalf("progress resumed with partial %q = %d, expected: %d", partial, resumed, expected)
+		}
+
+		outfile := fmt.Sprintf("%s/bar (1989) [1080p].mkv", testdir)
+		if b, err := os.ReadFile(outfile); err != nil || string(b) != mkvfileContent {
+			t.Fatalf("os.ReadFile(%s) with partial %q = %q, %v, expected: %q", outfile, partial, b, err, mkvfileContent)
+		}
+		os.RemoveAll(testdir)
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		expected int64
+	}{
+		{"foobar", "", 0},
+		{"foobar", "foo", 3},
+		{"foobar", "foobar", 6},
+		{"foobar", "fxx", 0},
+		{"foobar", "foobarbaz", 0},
+	}
+	for _, test := range tests {
+		var h strings.Builder
+		n, err := matchPrefix(context.Background(), strings.NewReader(test.src), strings.NewReader(test.dst), &h)
+		if err != nil || n != test.expected || h.String() != test.src[:n] {
+			t.Errorf("matchPrefix(%q, %q) = %d, %q, %v, expected: %d", test.src, test.dst, n, h.String(), err, test.expected)
+		}
+	}
+}
+
+func TestIngestEpisode(t *testing.T) {
+	createTestDir(t)
+	defer os.RemoveAll(testdir)
+
+	useMovieDir := false
+	createMkvFile(t)
+
+	season, episode := 1, 2
+	episodeMedia := model.Media{Name: "Show", Year: "2001", Season: &season, Episode: &episode}
+
+	ingester := LocalIngester{&url.URL{Path: testdir}, DefaultNaming(useMovieDir), "movies.sha256", false}
+	if err := ingester.Ingest(context.Background(), mkvfile, episodeMedia, nil); err != nil {
+		t.Fatalf("ingester.Ingest(m, %v) error: %v", episodeMedia, err)
+	}
+
+	outfile := fmt.Sprintf("%s/Show (2001)/Season 01/Show - S01E02.mkv", testdir)
+	if _, err := os.Stat(outfile); err != nil {
+		t.Fatalf("os.Stat(%s) failed: %v", outfile, err)
+	}
+	compareShaFile(t, `c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2  Show (2001)/Season 01/Show - S01E02.mkv`)
+}
+
+func TestIngestNamingTemplate(t *testing.T) {
+	createTestDir(t)
+	defer os.RemoveAll(testdir)
+
+	createMkvFile(t)
+
+	naming, err := NewNaming(`{{.Name}} ({{.Year}})/{{.Name}} [{{.Resolution}}] {{.Codec}}.mkv`, DefaultEpisodeTemplate)
+	if err != nil {
+		t.Fatalf("NewNaming() error: %v", err)
+	}
+	file := mkvfile
+	file.Codec = "MpegH"
+
+	ingester := LocalIngester{&url.URL{Path: testdir}, naming, "movies.sha256", false}
+	if err := ingester.Ingest(context.Background(), file, media, nil); err != nil {
+		t.Fatalf("ingester.Ingest(m, %s, %s) error: %v", name, year, err)
+	}
+
+	outfile := fmt.Sprintf("%s/bar (1989)/bar [1080p] MpegH.mkv", testdir)
+	if _, err := os.Stat(outfile); err != nil {
+		t.Fatalf("os.Stat(%s) failed: %v", outfile, err)
+	}
+	compareShaFile(t, `c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2  bar (1989)/bar [1080p] MpegH.mkv`)
+}
+
+func TestIngestBackupDir(t *testing.T) {
+	createTestDir(t)
+	defer os.RemoveAll(testdir)
+
+	backupdir := fmt.Sprintf("%s/backup", testdir)
+	if err := os.MkdirAll(backupdir+"/BDMV/STREAM", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/index.bdmv", []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/STREAM/00000.m2ts", []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := util.Sha256sumDir(backupdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	naming, err := NewNaming(DefaultBackupTemplate, DefaultEpisodeTemplate)
+	if err != nil {
+		t.Fatalf("NewNaming() error: %v", err)
+	}
+	backup := model.MkvFile{Filename: backupdir, Shasum: sum}
+
+	ingester := LocalIngester{&url.URL{Path: testdir}, naming, "movies.sha256", false}
+	if err := ingester.Ingest(context.Background(), backup, media, nil); err != nil {
+		t.Fatalf("ingester.Ingest(m, %s, %s) error: %v", name, year, err)
+	}
+
+	outfile := fmt.Sprintf("%s/bar (1989)/BDMV/STREAM/00000.m2ts", testdir)
+	if _, err := os.Stat(outfile); err != nil {
+		t.Fatalf("os.Stat(%s) failed: %v", outfile, err)
+	}
+	compareShaFile(t, `
+fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9  bar (1989)/BDMV/STREAM/00000.m2ts
+2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  bar (1989)/BDMV/index.bdmv
+`)
+
+	backup.Shasum = shasum
+	if err := ingester.Ingest(context.Background(), backup, media, nil); err == nil {
+		t.Fatal("expected error ingesting a backup with the wrong shasum")
+	}
+}
+
+func TestIngestLinked(t *testing.T) {
+	defer os.RemoveAll(testdir)
+	for _, verify := range []bool{false, true} {
+		createTestDir(t)
+
+		useMovieDir := false
+		createMkvFile(t)
+		if !sameDevice(mkvfile.Filename, testdir) {
+			t.Skip("test dir is not on one filesystem")
+		}
+		if err := os.Chmod(mkvfile.Filename, 0600); err != nil {
+			t.Fatal(err)
+		}
+		// a partial copy from before is replaced by the link
+		if err := os.MkdirAll(testdir+"/.input", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(testdir+"/.input/bar.mkv", []byte("foo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var copied int64
+		progress := func(c int64, _ int64, _ int64) { copied = c }
+		ingester := LocalIngester{&url.URL{Path: testdir}, DefaultNaming(useMovieDir), "movies.sha256", verify}
+		if err := ingester.Ingest(context.Background(), mkvfile, media, progress); err != nil {
+			t.Fatalf("ingester.Ingest() with verify %v error: %v", verify, err)
+		}
+		if copied != int64(len(mkvfileContent)) {
+			t.Fatalf("progress copied with verify %v = %d, expected: %d", verify, copied, len(mkvfileContent))
+		}
+		statMkvFile(t, useMovieDir)
+		// a hard linked rip keeps its permissions
+		if stat, err := os.Stat(mkvfile.Filename); err != nil {
+			t.Fatal(err)
+		} else if stat.Mode().Perm() != 0600 {
+			t.Fatalf("os.Stat(%s) mode = %v, expected: %v", mkvfile.Filename, stat.Mode(), os.FileMode(0600))
+		}
+
+		// the stored shasum is only trusted without verify
+		bad := mkvfile
+		bad.Shasum = strings.Repeat("0", 64)
+		err := ingester.Ingest(context.Background(), bad, media, nil)
+		if verify && (err == nil || !strings.Contains(err.Error(), "shasum does not match")) {
+			t.Fatalf("ingester.Ingest() with verify = %v, expected a shasum error", err)
+		} else if !verify && err != nil {
+			t.Fatalf("ingester.Ingest() without verify error: %v", err)
+		}
+		os.RemoveAll(testdir)
+	}
+}
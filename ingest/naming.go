@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/aravance/mkv-ripper/model"
+)
+
+const DefaultMovieTemplate = `{{.Name}} ({{.Year}}) [{{.Resolution}}].mkv`
+const DefaultMovieDirTemplate = `{{.Name}} ({{.Year}})/{{.Name}} ({{.Year}}) [{{.Resolution}}].mkv`
+const DefaultEpisodeTemplate = `{{.Name}} ({{.Year}})/Season {{printf "%02d" .Season}}/{{.Name}} - S{{printf "%02d" .Season}}E{{printf "%02d" .Episode}}.mkv`
+const DefaultBackupTemplate = `{{.Name}} ({{.Year}}){{.Ext}}`
+const DefaultVariantMovieTemplate = `{{.Name}} ({{.Year}}) - {{.Variant}}{{.Ext}}`
+const DefaultVariantEpisodeTemplate = `{{.Name}} ({{.Year}})/Season {{printf "%02d" .Season}}/{{.Name}} - S{{printf "%02d" .Season}}E{{printf "%02d" .Episode}} - {{.Variant}}{{.Ext}}`
+
+// NameData is what naming templates are rendered with.
+type NameData struct {
+	Name        string
+	Year        string
+	ImdbId      string
+	TmdbId      string
+	Resolution  string
+	Edition     string
+	Codec       string
+	AudioFormat string
+	Season      int
+	Episode     int
+	// Ext is the extension of the ripped file, including the dot, or empty
+	// for a disc backup folder.
+	Ext string
+	// Variant is the name of the encoder of a transcoded file
+	Variant string
+}
+
+// Naming renders the path, relative to the target, that an ingested file is
+// stored at. Slashes in the rendered path create directories.
+type Naming struct {
+	movie   *template.Template
+	episode *template.Template
+}
+
+func NewNaming(movie string, episode string) (*Naming, error) {
+	m, err := template.New("movie").Option("missingkey=error").Parse(movie)
+	if err != nil {
+		return nil, fmt.Errorf("invalid movie template: %w", err)
+	}
+	e, err := template.New("episode").Option("missingkey=error").Parse(episode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid episode template: %w", err)
+	}
+	return &Naming{m, e}, nil
+}
+
+// DefaultNaming returns the naming used when no templates are configured.
+func DefaultNaming(useMovieDir bool) *Naming {
+	movie := DefaultMovieTemplate
+	if useMovieDir {
+		movie = DefaultMovieDirTemplate
+	}
+	n, _ := NewNaming(movie, DefaultEpisodeTemplate)
+	return n
+}
+
+// Path returns the directory and file name, relative to the target, that the
+// file should be stored as.
+func (n *Naming) Path(mkv model.MkvFile, media model.Media) (dir string, file string, err error) {
+	data := NameData{
+		Name:        cleanName(media.Name),
+		Year:        cleanName(media.Year),
+		ImdbId:      cleanName(media.ImdbId),
+		TmdbId:      cleanName(media.TmdbId),
+		Resolution:  cleanName(mkv.Resolution),
+		Edition:     cleanName(media.Edition),
+		Codec:       cleanName(mkv.Codec),
+		AudioFormat: cleanName(mkv.AudioFormat),
+		Ext:         path.Ext(mkv.Filename),
+		Variant:     cleanName(mkv.Variant),
+	}
+
+	tmpl := n.movie
+	if media.IsEpisode() {
+		tmpl = n.episode
+		data.Season = *media.Season
+		data.Episode = *media.Episode
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", "", err
+	}
+
+	p := strings.TrimSpace(b.String())
+	if p == "" || path.IsAbs(p) {
+		return "", "", fmt.Errorf("invalid rendered path: %q", p)
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", "", fmt.Errorf("invalid rendered path: %q", p)
+		}
+	}
+
+	dir, file = path.Split(path.Clean(p))
+	return path.Clean(dir), file, nil
+}
+
+// cleanName keeps values like "Face/Off" from creating directories
+func cleanName(s string) string {
+	return strings.ReplaceAll(s, "/", "-")
+}
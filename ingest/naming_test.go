@@ -0,0 +1,93 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/aravance/mkv-ripper/model"
+)
+
+func TestNamingPath(t *testing.T) {
+	season, episode := 2, 7
+	mkv := model.MkvFile{Resolution: "4k", Codec: "MpegH", AudioFormat: "TrueHD"}
+	movie := model.Media{Name: "Face/Off", Year: "1997", ImdbId: "tt0119094", Edition: "Director's Cut"}
+	show := model.Media{Name: "Show", Year: "2001", Season: &season, Episode: &episode}
+
+	tests := []struct {
+		name    string
+		naming  *Naming
+		media   model.Media
+		dir     string
+		file    string
+		wantErr bool
+	}{
+		{"default", DefaultNaming(false), movie, ".", "Face-Off (1997) [4k].mkv", false},
+		{"movie dir", DefaultNaming(true), movie, "Face-Off (1997)", "Face-Off (1997) [4k].mkv", false},
+		{"episode", DefaultNaming(false), show, "Show (2001)/Season 02", "Show - S02E07.mkv", false},
+		{
+			"custom",
+			mustNaming(t, `{{.Name}} ({{.Year}}) {imdb-{{.ImdbId}}}/{{.Name}}{{if .Edition}} {edition-{{.Edition}}}{{end}} [{{.Resolution}} {{.Codec}} {{.AudioFormat}}].mkv`, DefaultEpisodeTemplate),
+			movie,
+			"Face-Off (1997) {imdb-tt0119094}",
+			"Face-Off {edition-Director's Cut} [4k MpegH TrueHD].mkv",
+			false,
+		},
+		{"absolute", mustNaming(t, `/{{.Name}}.mkv`, DefaultEpisodeTemplate), movie, "", "", true},
+		{"escape", mustNaming(t, `../{{.Name}}.mkv`, DefaultEpisodeTemplate), movie, "", "", true},
+		{"empty", mustNaming(t, ` `, DefaultEpisodeTemplate), movie, "", "", true},
+		{"unknown field", mustNaming(t, `{{.Title}}.mkv`, DefaultEpisodeTemplate), movie, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, file, err := tt.naming.Path(mkv, tt.media)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Path() = %q, %q, expected error", dir, file)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Path() error: %v", err)
+			}
+			if dir != tt.dir || file != tt.file {
+				t.Fatalf("Path() = %q, %q, expected: %q, %q", dir, file, tt.dir, tt.file)
+			}
+		})
+	}
+}
+
+func TestNamingPathBackup(t *testing.T) {
+	naming := mustNaming(t, DefaultBackupTemplate, DefaultEpisodeTemplate)
+	movie := model.Media{Name: "Face/Off", Year: "1997"}
+
+	for filename, expected := range map[string]string{
+		"/rip/d1/backup":     "Face-Off (1997)",
+		"/rip/d1/backup.iso": "Face-Off (1997).iso",
+	} {
+		dir, file, err := naming.Path(model.MkvFile{Filename: filename}, movie)
+		if err != nil {
+			t.Fatalf("Path(%s) error: %v", filename, err)
+		}
+		if dir != "." || file != expected {
+			t.Fatalf("Path(%s) = %q, %q, expected: %q, %q", filename, dir, file, ".", expected)
+		}
+	}
+}
+
+func TestNewNamingInvalid(t *testing.T) {
+	if _, err := NewNaming(`{{.Name`, DefaultEpisodeTemplate); err == nil {
+		t.Fatal("NewNaming() expected error for invalid movie template")
+	}
+	if _, err := NewNaming(DefaultMovieTemplate, `{{end}}`); err == nil {
+		t.Fatal("NewNaming() expected error for invalid episode template")
+	}
+}
+
+func mustNaming(t *testing.T, movie string, episode string) *Naming {
+	t.Helper()
+	n, err := NewNaming(movie, episode)
+	if err != nil {
+		t.Fatalf("NewNaming(%q, %q) error: %v", movie, episode, err)
+	}
+	return n
+}
@@ -0,0 +1,392 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// S3Config is how to reach an s3 compatible object store. Endpoint is only
+// needed for stores other than AWS, like MinIO, which are reached with path
+// style urls. The keys default to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// and Region to us-east-1.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// defaultPartSize is the size of the parts of multipart uploads, smaller files
+// are uploaded in one request.
+const defaultPartSize = 64 << 20
+
+// S3Ingester uploads files to the bucket of an s3://bucket/prefix url, named
+// like LocalIngester names them under the prefix. Every upload sends the
+// sha256 of what it uploads, for the store to check. Large files are uploaded
+// in parts, and an interrupted upload is resumed from the parts that were
+// already uploaded. A manifest object named like the shasums file takes its
+// place.
+type S3Ingester struct {
+	uri      *url.URL
+	naming   *Naming
+	shafile  string
+	client   *s3.Client
+	partSize int64
+}
+
+func newS3Ingester(uri *url.URL, naming *Naming, shafile string, config S3Config) (*S3Ingester, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("s3 target has no bucket: %s", uri)
+	}
+	creds := aws.Credentials{
+		AccessKeyID:     config.AccessKey,
+		SecretAccessKey: config.SecretKey,
+	}
+	if creds.AccessKeyID == "" {
+		creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	client := s3.New(s3.Options{
+		Region: region,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return creds, nil
+		}),
+		// uploads carry their own sha256, so the body isn't read twice to
+		// sign it
+		APIOptions:                 []func(*middleware.Stack) error{v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware},
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}, func(o *s3.Options) {
+		if config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Ingester{uri, naming, shafile, client, defaultPartSize}, nil
+}
+
+func (t *S3Ingester) bucket() *string {
+	return aws.String(t.uri.Host)
+}
+
+// key returns the object key of p under the prefix of the target
+func (t *S3Ingester) key(p string) string {
+	return strings.TrimPrefix(path.Join(t.uri.Path, p), "/")
+}
+
+func (t *S3Ingester) manifestKey() string {
+	if path.IsAbs(t.shafile) {
+		return strings.TrimPrefix(t.shafile, "/")
+	}
+	return t.key(t.shafile)
+}
+
+func (t *S3Ingester) Ingest(ctx context.Context, mkv model.MkvFile, media model.Media, progress Progress) error {
+	moviedir, mkvfile, err := t.naming.Path(mkv, media)
+	if err != nil {
+		log.Println("error naming file", err)
+		return err
+	}
+	shakey := path.Join(moviedir, mkvfile)
+
+	stat, err := os.Stat(mkv.Filename)
+	if err != nil {
+		log.Println("error opening existing file", mkv.Filename, err)
+		return err
+	}
+
+	// the files to upload and their sums, by their name in the manifest. The
+	// sums are checked before anything is uploaded.
+	files := map[string]string{shakey: mkv.Filename}
+	sums := map[string]string{shakey: mkv.Shasum}
+	total := stat.Size()
+	if !stat.IsDir() {
+		shasum, err := util.Sha256sum(mkv.Filename)
+		if err != nil {
+			return err
+		}
+		if shasum != mkv.Shasum {
+			return fmt.Errorf("shasum does not match expected: %s, actual: %s", mkv.Shasum, shasum)
+		}
+	} else {
+		dirsums, err := util.Sha256sums(mkv.Filename)
+		if err != nil {
+			return err
+		}
+		if shasum := util.Sha256sumListing(dirsums); shasum != mkv.Shasum {
+			return fmt.Errorf("shasum does not match expected: %s, actual: %s", mkv.Shasum, shasum)
+		}
+		files = make(map[string]string, len(dirsums))
+		sums = make(map[string]string, len(dirsums))
+		total = 0
+		for name, sum := range dirsums {
+			f := filepath.Join(mkv.Filename, filepath.FromSlash(name))
+			info, err := os.Stat(f)
+			if err != nil {
+				return err
+			}
+			files[path.Join(shakey, name)] = f
+			sums[path.Join(shakey, name)] = sum
+			total += info.Size()
+		}
+	}
+
+	p := newCopyProgress(progress, total)
+	for name, f := range files {
+		key := t.key(name)
+		log.Println("starting s3 upload", f, t.uri.Host, key)
+		if err := t.upload(ctx, key, f, sums[name], p); err != nil {
+			log.Println("error uploading", f, err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+
+	log.Println("Adding shasum to manifest")
+	if err := t.updateManifest(ctx, sums); err != nil {
+		return err
+	}
+
+	log.Println("Done.")
+	return nil
+}
+
+// upload puts file at key, in parts if it's larger than a part.
+func (t *S3Ingester) upload(ctx context.Context, key string, file string, shasum string, p *copyProgress) error {
+	sum, err := hex.DecodeString(shasum)
+	if err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("invalid shasum: %q", shasum)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() > t.partSize {
+		return t.uploadParts(ctx, key, f, info.Size(), shasum, p)
+	}
+	_, err = t.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:         t.bucket(),
+		Key:            aws.String(key),
+		Body:           progressReader{contextReader{ctx, f}, p},
+		ContentLength:  aws.Int64(info.Size()),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+		Metadata:       map[string]string{"sha256": shasum},
+	})
+	return err
+}
+
+// uploadParts does a multipart upload of f, resuming an unfinished upload of
+// key if there is one. Every part is read to sum it before it's uploaded, so
+// parts that were uploaded before are only kept if they still match.
+func (t *S3Ingester) uploadParts(ctx context.Context, key string, f *os.File, size int64, shasum string, p *copyProgress) error {
+	uploadId, uploaded, err := t.resumeUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+	if uploadId == nil {
+		out, err := t.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:            t.bucket(),
+			Key:               aws.String(key),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			Metadata:          map[string]string{"sha256": shasum},
+		})
+		if err != nil {
+			return err
+		}
+		uploadId = out.UploadId
+	} else {
+		log.Println("resuming upload of", key, "with", len(uploaded), "parts")
+	}
+
+	whole := sha256.New()
+	var parts []types.CompletedPart
+	for n, off := int32(1), int64(0); off < size; n, off = n+1, off+t.partSize {
+		length := min(t.partSize, size-off)
+		section := io.NewSectionReader(f, off, length)
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(h, whole), contextReader{ctx, section}); err != nil {
+			return err
+		}
+		partSum := aws.String(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+
+		if part, ok := uploaded[n]; ok && aws.ToInt64(part.Size) == length && aws.ToString(part.ChecksumSHA256) == *partSum {
+			p.resume(length)
+			parts = append(parts, types.CompletedPart{PartNumber: aws.Int32(n), ETag: part.ETag, ChecksumSHA256: partSum})
+			continue
+		}
+
+		if _, err := section.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		out, err := t.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:         t.bucket(),
+			Key:            aws.String(key),
+			UploadId:       uploadId,
+			PartNumber:     aws.Int32(n),
+			Body:           progressReader{contextReader{ctx, section}, p},
+			ContentLength:  aws.Int64(length),
+			ChecksumSHA256: partSum,
+		})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, types.CompletedPart{PartNumber: aws.Int32(n), ETag: out.ETag, ChecksumSHA256: partSum})
+	}
+
+	if actual := fmt.Sprintf("%x", whole.Sum(nil)); actual != shasum {
+		t.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   t.bucket(),
+			Key:      aws.String(key),
+			UploadId: uploadId,
+		})
+		return fmt.Errorf("shasum does not match expected: %s, actual: %s", shasum, actual)
+	}
+
+	_, err = t.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          t.bucket(),
+		Key:             aws.String(key),
+		UploadId:        uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// resumeUpload finds the latest unfinished upload of key, and the parts it
+// has by number.
+func (t *S3Ingester) resumeUpload(ctx context.Context, key string) (*string, map[int32]types.Part, error) {
+	var latest *types.MultipartUpload
+	list := &s3.ListMultipartUploadsInput{
+		Bucket: t.bucket(),
+		Prefix: aws.String(key),
+	}
+	for {
+		out, err := t.client.ListMultipartUploads(ctx, list)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, u := range out.Uploads {
+			if aws.ToString(u.Key) != key {
+				continue
+			}
+			if latest == nil || aws.ToTime(u.Initiated).After(aws.ToTime(latest.Initiated)) {
+				latest = &out.Uploads[i]
+			}
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		list.KeyMarker = out.NextKeyMarker
+		list.UploadIdMarker = out.NextUploadIdMarker
+	}
+	if latest == nil {
+		return nil, nil, nil
+	}
+
+	parts := make(map[int32]types.Part)
+	in := &s3.ListPartsInput{
+		Bucket:   t.bucket(),
+		Key:      aws.String(key),
+		UploadId: latest.UploadId,
+	}
+	for {
+		out, err := t.client.ListParts(ctx, in)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, part := range out.Parts {
+			parts[aws.ToInt32(part.PartNumber)] = part
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.PartNumberMarker = out.NextPartNumberMarker
+	}
+	return latest.UploadId, parts, nil
+}
+
+// updateManifest adds sums to the manifest. It's only replaced if nobody else
+// changed it since it was read, otherwise it's read again and retried.
+func (t *S3Ingester) updateManifest(ctx context.Context, sums map[string]string) error {
+	key := aws.String(t.manifestKey())
+	for attempt := 1; ; attempt++ {
+		shasums, etag, err := t.readManifest(ctx, key)
+		if err != nil {
+			return err
+		}
+		maps.Copy(shasums, sums)
+
+		in := &s3.PutObjectInput{
+			Bucket:      t.bucket(),
+			Key:         key,
+			Body:        bytes.NewReader(formatShasums(shasums)),
+			ContentType: aws.String("text/plain"),
+		}
+		if etag == nil {
+			in.IfNoneMatch = aws.String("*")
+		} else {
+			in.IfMatch = etag
+		}
+		_, err = t.client.PutObject(ctx, in)
+
+		var apiErr smithy.APIError
+		if attempt < 5 && errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+			log.Println("manifest changed while updating, retrying")
+			continue
+		}
+		return err
+	}
+}
+
+func (t *S3Ingester) readManifest(ctx context.Context, key *string) (map[string]string, *string, error) {
+	out, err := t.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: t.bucket(),
+		Key:    key,
+	})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		return parseShasums(nil), nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer out.Body.Close()
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseShasums(b), out.ETag, nil
+}
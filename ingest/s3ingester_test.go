@@ -0,0 +1,389 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+)
+
+type fakePart struct {
+	data []byte
+	sum  string
+}
+
+type fakeUpload struct {
+	key       string
+	parts     map[int]fakePart
+	initiated time.Time
+}
+
+// fakeS3 is an in memory, path style s3 bucket with just what S3Ingester
+// uses. It checks the sha256 sent with uploads, like s3 does.
+type fakeS3 struct {
+	mutex       sync.Mutex
+	bucket      string
+	objects     map[string][]byte
+	uploads     map[string]*fakeUpload
+	nextUpload  int
+	partUploads map[int]int
+	// maxUploads is how many uploads are listed at a time, 1000 if unset
+	maxUploads int
+}
+
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, string) {
+	t.Helper()
+	s := &fakeS3{
+		bucket:      bucket,
+		objects:     make(map[string][]byte),
+		uploads:     make(map[string]*fakeUpload),
+		partUploads: make(map[int]int),
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server.URL
+}
+
+func etag(b []byte) string {
+	return fmt.Sprintf(`"%x"`, sha256.Sum256(b))
+}
+
+func sha256Base64(b []byte) string {
+	sum := sha256.Sum256(b)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func writeXml(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	q := r.URL.Query()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if sum := r.Header.Get("X-Amz-Checksum-Sha256"); sum != "" && sum != sha256Base64(body) {
+		s3Error(w, http.StatusBadRequest, "BadDigest")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && q.Has("uploads"):
+		type upload struct {
+			Key       string
+			UploadId  string
+			Initiated string
+		}
+		var res struct {
+			XMLName            xml.Name `xml:"ListMultipartUploadsResult"`
+			Bucket             string
+			IsTruncated        bool
+			NextKeyMarker      string
+			NextUploadIdMarker string
+			Upload             []upload
+		}
+		res.Bucket = s.bucket
+		ids := slices.Sorted(maps.Keys(s.uploads))
+		slices.SortStableFunc(ids, func(a, b string) int {
+			return strings.Compare(s.uploads[a].key, s.uploads[b].key)
+		})
+		limit := s.maxUploads
+		if limit == 0 {
+			limit = 1000
+		}
+		keyMarker, idMarker := q.Get("key-marker"), q.Get("upload-id-marker")
+		for _, id := range ids {
+			u := s.uploads[id]
+			if !strings.HasPrefix(u.key, q.Get("prefix")) {
+				continue
+			}
+			if keyMarker != "" && (u.key < keyMarker || u.key == keyMarker && id <= idMarker) {
+				continue
+			}
+			if len(res.Upload) == limit {
+				res.IsTruncated = true
+				break
+			}
+			res.Upload = append(res.Upload, upload{u.key, id, u.initiated.UTC().Format(time.RFC3339)})
+			res.NextKeyMarker, res.NextUploadIdMarker = u.key, id
+		}
+		writeXml(w, res)
+
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.nextUpload++
+		id := strconv.Itoa(s.nextUpload)
+		s.uploads[id] = &fakeUpload{key, make(map[int]fakePart), time.Now()}
+		writeXml(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: s.bucket, Key: key, UploadId: id})
+
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		u, ok := s.uploads[q.Get("uploadId")]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		u.parts[n] = fakePart{body, sha256Base64(body)}
+		s.partUploads[n]++
+		w.Header().Set("ETag", etag(body))
+
+	case r.Method == http.MethodGet && q.Has("uploadId"):
+		u, ok := s.uploads[q.Get("uploadId")]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		type part struct {
+			PartNumber     int
+			ETag           string
+			Size           int
+			ChecksumSHA256 string
+		}
+		var res struct {
+			XMLName     xml.Name `xml:"ListPartsResult"`
+			Bucket      string
+			Key         string
+			UploadId    string
+			IsTruncated bool
+			Part        []part
+		}
+		res.Bucket, res.Key, res.UploadId = s.bucket, u.key, q.Get("uploadId")
+		for n, p := range u.parts {
+			res.Part = append(res.Part, part{n, etag(p.data), len(p.data), p.sum})
+		}
+		sort.Slice(res.Part, func(i, j int) bool { return res.Part[i].PartNumber < res.Part[j].PartNumber })
+		writeXml(w, res)
+
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		u, ok := s.uploads[q.Get("uploadId")]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var req struct {
+			Part []struct {
+				PartNumber     int
+				ETag           string
+				ChecksumSHA256 string
+			}
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			s3Error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for _, p := range req.Part {
+			part, ok := u.parts[p.PartNumber]
+			if !ok || p.ETag != etag(part.data) || p.ChecksumSHA256 != part.sum {
+				s3Error(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, part.data...)
+		}
+		s.objects[u.key] = data
+		delete(s.uploads, q.Get("uploadId"))
+		writeXml(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: s.bucket, Key: u.key, ETag: etag(data)})
+
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		existing, ok := s.objects[key]
+		if (r.Header.Get("If-None-Match") == "*" && ok) || (r.Header.Get("If-Match") != "" && (!ok || r.Header.Get("If-Match") != etag(existing))) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		s.objects[key] = body
+		w.Header().Set("ETag", etag(body))
+
+	case r.Method == http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		w.Write(data)
+
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func newTestS3Ingester(t *testing.T, endpoint string, naming *Naming) *S3Ingester {
+	t.Helper()
+	i, err := newS3Ingester(&url.URL{Scheme: "s3", Host: "rips", Path: "/movies"}, naming, "movies.sha256", S3Config{Endpoint: endpoint, AccessKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestS3Ingest(t *testing.T) {
+	s, endpoint := newFakeS3(t, "rips")
+	ingester := newTestS3Ingester(t, endpoint, DefaultNaming(true))
+	s.objects["movies/movies.sha256"] = []byte(strings.TrimLeft(movieDirShafileContent, "\n"))
+
+	src := path.Join(t.TempDir(), "bar.mkv")
+	if err := os.WriteFile(src, []byte(mkvfileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var copied int64
+	progress := func(c int64, _ int64, _ int64) { copied = c }
+	mkv := model.MkvFile{Filename: src, Shasum: shasum, Resolution: res}
+	if err := ingester.Ingest(context.Background(), mkv, media, progress); err != nil {
+		t.Fatalf("ingester.Ingest() error: %v", err)
+	}
+	if copied != int64(len(mkvfileContent)) {
+		t.Fatalf("progress copied = %d, expected: %d", copied, len(mkvfileContent))
+	}
+
+	if b := s.objects["movies/bar (1989)/bar (1989) [1080p].mkv"]; string(b) != mkvfileContent {
+		t.Fatalf("uploaded object = %q, expected: %q", b, mkvfileContent)
+	}
+	expected := `c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2  bar (1989)/bar (1989) [1080p].mkv
+97df3588b5a3f24babc3851b372f0ba71a9dcdded43b14b9d06961bfc1707d9d  bar (1989)/bar (1989) [4k].mkv
+1b8e84ccf80aae39e1ca16393920c801a8fb78c5ae8ce5e6a5d636baa3d9386d  baz (2000)/baz (2000) [4k].mkv
+5ecf8d2cc410094e8b82dd0bc178a57f3aa1e80916689beb00fe56148b1b1256  foo (1990)/foo (1990) [480p].mkv
+`
+	if b := s.objects["movies/movies.sha256"]; string(b) != expected {
+		t.Fatalf("manifest = %q, expected: %q", b, expected)
+	}
+
+	// a file that doesn't match its shasum is never uploaded
+	mkv.Shasum = strings.Repeat("0", 64)
+	mkv.Resolution = "720p"
+	if err := ingester.Ingest(context.Background(), mkv, media, nil); err == nil || !strings.Contains(err.Error(), "shasum does not match") {
+		t.Fatalf("ingester.Ingest() = %v, expected a shasum error", err)
+	}
+	if _, ok := s.objects["movies/bar (1989)/bar (1989) [720p].mkv"]; ok {
+		t.Fatal("object with a bad shasum was uploaded")
+	}
+}
+
+func TestS3IngestMultipartResume(t *testing.T) {
+	s, endpoint := newFakeS3(t, "rips")
+	ingester := newTestS3Ingester(t, endpoint, DefaultNaming(false))
+	ingester.partSize = 4
+
+	content := []byte("0123456789")
+	src := path.Join(t.TempDir(), "bar.mkv")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	shasum, err := util.Sha256sum(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an interrupted upload with the first part done, and a bad second part.
+	// It's listed after an older one, on a page of its own.
+	key := "movies/bar (1989) [1080p].mkv"
+	s.uploads["0"] = &fakeUpload{key, map[int]fakePart{}, time.Now().Add(-time.Hour)}
+	s.uploads["1"] = &fakeUpload{key, map[int]fakePart{
+		1: {content[:4], sha256Base64(content[:4])},
+		2: {[]byte("xxxx"), sha256Base64([]byte("xxxx"))},
+	}, time.Now()}
+	s.nextUpload = 1
+	s.maxUploads = 1
+
+	var copied, resumed int64
+	progress := func(c int64, r int64, _ int64) { copied, resumed = c, r }
+	mkv := model.MkvFile{Filename: src, Shasum: shasum, Resolution: res}
+	if err := ingester.Ingest(context.Background(), mkv, media, progress); err != nil {
+		t.Fatalf("ingester.Ingest() error: %v", err)
+	}
+	if string(s.objects[key]) != string(content) {
+		t.Fatalf("uploaded object = %q, expected: %q", s.objects[key], content)
+	}
+	if s.partUploads[1] != 0 || s.partUploads[2] != 1 || s.partUploads[3] != 1 {
+		t.Fatalf("part uploads = %v, expected only parts 2 and 3", s.partUploads)
+	}
+	if copied != int64(len(content)) || resumed != 4 {
+		t.Fatalf("progress copied = %d, resumed = %d, expected: %d, 4", copied, resumed, len(content))
+	}
+	if len(s.uploads) != 1 {
+		t.Fatalf("%d uploads left unfinished, expected only the older one", len(s.uploads))
+	}
+}
+
+func TestS3IngestBackupDir(t *testing.T) {
+	s, endpoint := newFakeS3(t, "rips")
+	naming, err := NewNaming(DefaultBackupTemplate, DefaultEpisodeTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingester := newTestS3Ingester(t, endpoint, naming)
+
+	backupdir := path.Join(t.TempDir(), "backup")
+	if err := os.MkdirAll(backupdir+"/BDMV/STREAM", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/index.bdmv", []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/STREAM/00000.m2ts", []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := util.Sha256sumDir(backupdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ingester.Ingest(context.Background(), model.MkvFile{Filename: backupdir, Shasum: sum}, media, nil); err != nil {
+		t.Fatalf("ingester.Ingest() error: %v", err)
+	}
+	if b := s.objects["movies/bar (1989)/BDMV/STREAM/00000.m2ts"]; string(b) != "bar" {
+		t.Fatalf("backup object = %q, expected: bar", b)
+	}
+	expected := `fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9  bar (1989)/BDMV/STREAM/00000.m2ts
+2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  bar (1989)/BDMV/index.bdmv
+`
+	if b := s.objects["movies/movies.sha256"]; string(b) != expected {
+		t.Fatalf("manifest = %q, expected: %q", b, expected)
+	}
+}
@@ -0,0 +1,387 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SftpIngester copies files over sftp without running any commands on the
+// server. The copy is verified by reading it back, and the shasums file is
+// replaced atomically.
+type SftpIngester struct {
+	uri        *url.URL
+	naming     *Naming
+	shafile    string
+	key        string
+	knownHosts string
+}
+
+// defaultKeys are tried in ~/.ssh when the target has no key
+var defaultKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+func (t *SftpIngester) clientConfig() (*ssh.ClientConfig, error) {
+	home, _ := os.UserHomeDir()
+
+	keys := []string{t.key}
+	if t.key == "" {
+		keys = nil
+		for _, k := range defaultKeys {
+			keys = append(keys, path.Join(home, ".ssh", k))
+		}
+	}
+	var signers []ssh.Signer
+	for _, k := range keys {
+		b, err := os.ReadFile(k)
+		if t.key == "" && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no ssh key found")
+	}
+
+	knownHosts := t.knownHosts
+	if knownHosts == "" {
+		knownHosts = path.Join(home, ".ssh", "known_hosts")
+	}
+	hostKey, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	username := t.uri.User.Username()
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		username = u.Username
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKey,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+// dial connects to the target. The connection is closed if ctx is cancelled,
+// which interrupts a running copy.
+func (t *SftpIngester) dial(ctx context.Context) (*sftp.Client, func(), error) {
+	config, err := t.clientConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	addr := t.uri.Host
+	if t.uri.Port() == "" {
+		addr = net.JoinHostPort(t.uri.Hostname(), "22")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		stop()
+		client.Close()
+		return nil, nil, err
+	}
+	return sc, func() {
+		stop()
+		sc.Close()
+		client.Close()
+	}, nil
+}
+
+func (t *SftpIngester) Ingest(ctx context.Context, mkv model.MkvFile, media model.Media, progress Progress) error {
+	moviedir, mkvfile, err := t.naming.Path(mkv, media)
+	if err != nil {
+		log.Println("error naming file", err)
+		return err
+	}
+
+	newdir := path.Join(t.uri.Path, moviedir)
+	newfile := path.Join(newdir, mkvfile)
+	ingestfile := path.Join(t.uri.Path, ".input", path.Base(mkv.Filename))
+	var shafile string
+	if path.IsAbs(t.shafile) {
+		shafile = t.shafile
+	} else {
+		shafile = path.Join(t.uri.Path, t.shafile)
+	}
+
+	stat, err := os.Stat(mkv.Filename)
+	if err != nil {
+		log.Println("error opening existing file", mkv.Filename, err)
+		return err
+	}
+
+	client, closeClient, err := t.dial(ctx)
+	if err != nil {
+		log.Println("error connecting to", t.uri.Redacted(), err)
+		return err
+	}
+	defer closeClient()
+
+	if err := client.MkdirAll(path.Join(t.uri.Path, ".input")); err != nil {
+		log.Println("error making input dir", err)
+		return err
+	}
+	// clear out what's left of an interrupted copy
+	client.RemoveAll(ingestfile)
+
+	log.Println("starting sftp", mkv.Filename, ingestfile)
+	if err := sftpUpload(ctx, client, mkv.Filename, ingestfile, progress); err != nil {
+		log.Println("error copying file", mkv.Filename, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		client.RemoveAll(ingestfile)
+		return err
+	}
+
+	// check sha256sum by reading the copy back
+	log.Println("Checking shasum", ingestfile)
+	var shasum string
+	var sums map[string]string
+	if stat.IsDir() {
+		sums, err = sftpSha256sums(ctx, client, ingestfile)
+		shasum = util.Sha256sumListing(sums)
+	} else {
+		shasum, err = sftpSha256sum(ctx, client, ingestfile)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	if shasum != mkv.Shasum {
+		return fmt.Errorf("shasum does not match expected: %s, actual: %s", mkv.Shasum, shasum)
+	}
+
+	// create directory
+	if err := client.MkdirAll(newdir); err != nil {
+		return err
+	}
+
+	// fix permissions
+	if err := client.Chmod(newdir, 0775); err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		err = sftpChmodDir(client, ingestfile)
+	} else {
+		err = client.Chmod(ingestfile, 0664)
+	}
+	if err != nil {
+		return err
+	}
+
+	// add sha256sum to movies.sha256
+	log.Println("Adding shasum to shasums file")
+	shasums, err := sftpReadShasums(client, shafile)
+	if err != nil {
+		return err
+	}
+	shakey := path.Join(moviedir, mkvfile)
+	if stat.IsDir() {
+		for name, sum := range sums {
+			shasums[path.Join(shakey, name)] = sum
+		}
+	} else {
+		shasums[shakey] = shasum
+	}
+	if err := sftpWriteShasums(client, shafile, shasums); err != nil {
+		return err
+	}
+
+	// move Files
+	log.Println("Moving files")
+	if err := client.PosixRename(ingestfile, newfile); err != nil {
+		return err
+	}
+
+	log.Println("Done.")
+	return nil
+}
+
+// sftpUpload copies the file or disc backup folder src to dst, which must not
+// exist yet.
+func sftpUpload(ctx context.Context, client *sftp.Client, src string, dst string, progress Progress) error {
+	var total int64
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p := newCopyProgress(progress, total)
+
+	return filepath.WalkDir(src, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, f)
+		if err != nil {
+			return err
+		}
+		out := path.Join(dst, filepath.ToSlash(rel))
+		if d.IsDir() {
+			return client.Mkdir(out)
+		}
+		return sftpCopyFile(ctx, client, f, out, p)
+	})
+}
+
+func sftpCopyFile(ctx context.Context, client *sftp.Client, src string, dst string, p *copyProgress) error {
+	i, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer i.Close()
+	o, err := client.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(o, progressReader{contextReader{ctx, i}, p}); err != nil {
+		o.Close()
+		return err
+	}
+	return o.Close()
+}
+
+func sftpSha256sum(ctx context.Context, client *sftp.Client, file string) (string, error) {
+	f, err := client.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, contextReader{ctx, f}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// sftpSha256sums is util.Sha256sums for a folder on the server.
+func sftpSha256sums(ctx context.Context, client *sftp.Client, dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	walker := client.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		if !walker.Stat().Mode().IsRegular() {
+			continue
+		}
+		sum, err := sftpSha256sum(ctx, client, walker.Path())
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(dir, walker.Path())
+		if err != nil {
+			return nil, err
+		}
+		sums["./"+filepath.ToSlash(rel)] = sum
+	}
+	return sums, nil
+}
+
+func sftpChmodDir(client *sftp.Client, dir string) error {
+	walker := client.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		mode := os.FileMode(0664)
+		if walker.Stat().IsDir() {
+			mode = 0775
+		}
+		if err := client.Chmod(walker.Path(), mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sftpReadShasums(client *sftp.Client, shafile string) (map[string]string, error) {
+	f, err := client.Open(shafile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return parseShasums(nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return parseShasums(b), nil
+}
+
+// sftpWriteShasums writes the shasums next to shafile and renames it over
+// shafile, so it's never left half written.
+func sftpWriteShasums(client *sftp.Client, shafile string, shasums map[string]string) error {
+	tmp := path.Join(path.Dir(shafile), "."+path.Base(shafile)+".tmp")
+	f, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(formatShasums(shasums)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := client.Chmod(tmp, 0644); err != nil {
+		return err
+	}
+	return client.PosixRename(tmp, shafile)
+}
@@ -0,0 +1,249 @@
+package ingest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type sftpTestServer struct {
+	addr       string
+	key        string
+	knownHosts string
+}
+
+func newSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, priv
+}
+
+// startSftpServer serves sftp to the client key it writes to the returned
+// key file, along with a known_hosts file for the server.
+func startSftpServer(t *testing.T) sftpTestServer {
+	t.Helper()
+	dir := t.TempDir()
+	hostSigner, _ := newSigner(t)
+	clientSigner, clientKey := newSigner(t)
+
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := path.Join(dir, "id_ed25519")
+	if err := os.WriteFile(key, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(k.Marshal()) == string(clientSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, config)
+		}
+	}()
+
+	knownHosts := path.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(l.Addr().String())}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return sftpTestServer{l.Addr().String(), key, knownHosts}
+}
+
+func serveSftp(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// only the sftp subsystem, no shell or commands
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if server, err := sftp.NewServer(ch); err == nil {
+						server.Serve()
+					}
+					ch.Close()
+				}
+			}
+		}()
+	}
+}
+
+func (s sftpTestServer) ingester(t *testing.T, naming *Naming) (*SftpIngester, string) {
+	t.Helper()
+	target := t.TempDir()
+	uri := &url.URL{Scheme: "sftp", Host: s.addr, Path: target}
+	return &SftpIngester{uri, naming, "movies.sha256", s.key, s.knownHosts}, target
+}
+
+func TestSftpIngest(t *testing.T) {
+	server := startSftpServer(t)
+	ingester, target := server.ingester(t, DefaultNaming(true))
+
+	src := path.Join(t.TempDir(), "bar.mkv")
+	if err := os.WriteFile(src, []byte(mkvfileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(target, "movies.sha256"), []byte(strings.TrimLeft(movieDirShafileContent, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var copied, total int64
+	progress := func(c int64, _ int64, tot int64) { copied, total = c, tot }
+	mkv := model.MkvFile{Filename: src, Shasum: shasum, Resolution: res}
+	if err := ingester.Ingest(context.Background(), mkv, media, progress); err != nil {
+		t.Fatalf("ingester.Ingest() error: %v", err)
+	}
+	if copied != int64(len(mkvfileContent)) || total != copied {
+		t.Fatalf("progress = %d/%d, expected: %d/%d", copied, total, len(mkvfileContent), len(mkvfileContent))
+	}
+
+	outfile := path.Join(target, "bar (1989)", "bar (1989) [1080p].mkv")
+	if b, err := os.ReadFile(outfile); err != nil || string(b) != mkvfileContent {
+		t.Fatalf("os.ReadFile(%s) = %q, %v, expected: %q", outfile, b, err, mkvfileContent)
+	}
+	if stat, err := os.Stat(outfile); err != nil || stat.Mode().Perm() != 0664 {
+		t.Fatalf("os.Stat(%s) = %v, %v, expected mode 0664", outfile, stat, err)
+	}
+
+	b, err := os.ReadFile(path.Join(target, "movies.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2  bar (1989)/bar (1989) [1080p].mkv
+97df3588b5a3f24babc3851b372f0ba71a9dcdded43b14b9d06961bfc1707d9d  bar (1989)/bar (1989) [4k].mkv
+1b8e84ccf80aae39e1ca16393920c801a8fb78c5ae8ce5e6a5d636baa3d9386d  baz (2000)/baz (2000) [4k].mkv
+5ecf8d2cc410094e8b82dd0bc178a57f3aa1e80916689beb00fe56148b1b1256  foo (1990)/foo (1990) [480p].mkv
+`
+	if string(b) != expected {
+		t.Fatalf("shafile = %q, expected: %q", b, expected)
+	}
+
+	// a bad copy is never moved into place
+	mkv.Shasum = strings.Repeat("0", 64)
+	if err := os.Remove(outfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingester.Ingest(context.Background(), mkv, media, nil); err == nil || !strings.Contains(err.Error(), "shasum does not match") {
+		t.Fatalf("ingester.Ingest() = %v, expected a shasum error", err)
+	}
+	if _, err := os.Stat(outfile); !os.IsNotExist(err) {
+		t.Fatalf("os.Stat(%s) = %v, expected the file not to exist", outfile, err)
+	}
+}
+
+func TestSftpIngestBackupDir(t *testing.T) {
+	server := startSftpServer(t)
+	naming, err := NewNaming(DefaultBackupTemplate, DefaultEpisodeTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingester, target := server.ingester(t, naming)
+
+	backupdir := path.Join(t.TempDir(), "backup")
+	if err := os.MkdirAll(backupdir+"/BDMV/STREAM", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/index.bdmv", []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/STREAM/00000.m2ts", []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := util.Sha256sumDir(backupdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var copied int64
+	progress := func(c int64, _ int64, _ int64) { copied = c }
+	if err := ingester.Ingest(context.Background(), model.MkvFile{Filename: backupdir, Shasum: sum}, media, progress); err != nil {
+		t.Fatalf("ingester.Ingest() error: %v", err)
+	}
+	if copied != 6 {
+		t.Fatalf("progress copied = %d, expected: 6", copied)
+	}
+	if b, err := os.ReadFile(path.Join(target, "bar (1989)/BDMV/STREAM/00000.m2ts")); err != nil || string(b) != "bar" {
+		t.Fatalf("backup file = %q, %v, expected: bar", b, err)
+	}
+
+	b, err := os.ReadFile(path.Join(target, "movies.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9  bar (1989)/BDMV/STREAM/00000.m2ts
+2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  bar (1989)/BDMV/index.bdmv
+`
+	if string(b) != expected {
+		t.Fatalf("shafile = %q, expected: %q", b, expected)
+	}
+}
+
+func TestSftpIngestUnknownHost(t *testing.T) {
+	server := startSftpServer(t)
+	other := startSftpServer(t)
+	// the host key of another server
+	server.knownHosts = other.knownHosts
+	ingester, target := server.ingester(t, DefaultNaming(false))
+
+	src := path.Join(t.TempDir(), "bar.mkv")
+	if err := os.WriteFile(src, []byte(mkvfileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingester.Ingest(context.Background(), model.MkvFile{Filename: src, Shasum: shasum}, media, nil); err == nil {
+		t.Fatal("expected an unknown host key to fail")
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Fatalf("target has %d entries, expected nothing copied", len(entries))
+	}
+}
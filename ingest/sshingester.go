@@ -1,9 +1,11 @@
 package ingest
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/url"
+	"os"
 	"os/exec"
 	"path"
 	"strings"
@@ -12,13 +14,13 @@ import (
 )
 
 type SshIngester struct {
-	uri         *url.URL
-	useMovieDir bool
-	shafile     string
+	uri     *url.URL
+	naming  *Naming
+	shafile string
 }
 
-func (t *SshIngester) runCommand(cmd string) error {
-	ssh := exec.Command("ssh", t.uri.Host, cmd)
+func (t *SshIngester) runCommand(ctx context.Context, cmd string) error {
+	ssh := exec.CommandContext(ctx, "ssh", t.uri.Host, cmd)
 	log.Println(ssh)
 
 	if err := ssh.Start(); err != nil {
@@ -34,20 +36,22 @@ func (t *SshIngester) runCommand(cmd string) error {
 	return nil
 }
 
+// sumDirCmd prints the sha256sum of every file under the working directory,
+// sorted by name.
+const sumDirCmd = "find . -type f -print0 | LC_ALL=C sort -z | xargs -0 sha256sum"
+
 func escapeSsh(s string) string {
 	return strings.ReplaceAll(s, `'`, `'\''`)
 }
 
-func (t *SshIngester) Ingest(mkv model.MkvFile, name string, year string) error {
-	moviedir := fmt.Sprintf("%s (%s)", name, year)
-	mkvfile := fmt.Sprintf("%s (%s) [%s].mkv", name, year, mkv.Resolution)
-
-	var newdir string
-	if t.useMovieDir {
-		newdir = path.Join(t.uri.Path, moviedir)
-	} else {
-		newdir = path.Join(t.uri.Path)
+func (t *SshIngester) Ingest(ctx context.Context, mkv model.MkvFile, media model.Media, _ Progress) error {
+	moviedir, mkvfile, err := t.naming.Path(mkv, media)
+	if err != nil {
+		log.Println("error naming file", err)
+		return err
 	}
+
+	newdir := path.Join(t.uri.Path, moviedir)
 	newfile := path.Join(newdir, mkvfile)
 	ingestfile := path.Join(t.uri.Path, ".input", path.Base(mkv.Filename))
 	var shafile string
@@ -57,60 +61,88 @@ func (t *SshIngester) Ingest(mkv model.MkvFile, name string, year string) error
 		shafile = path.Join(t.uri.Path, t.shafile)
 	}
 
+	stat, err := os.Stat(mkv.Filename)
+	if err != nil {
+		log.Println("error opening existing file", mkv.Filename, err)
+		return err
+	}
+
 	out := fmt.Sprintf("%s:%s", t.uri.Hostname(), path.Join(t.uri.Path, ".input"))
 	log.Println("starting scp", mkv.Filename, out)
-	scp := exec.Command("scp", mkv.Filename, out)
+	args := []string{mkv.Filename, out}
+	if stat.IsDir() {
+		// clear out what's left of an interrupted copy
+		t.runCommand(ctx, fmt.Sprintf("rm -rf '%s'", escapeSsh(ingestfile)))
+		args = append([]string{"-r"}, args...)
+	}
+	scp := exec.CommandContext(ctx, "scp", args...)
 	if err := scp.Start(); err != nil {
 		log.Println("error starting scp", mkv.Filename, out, err)
 		return err
 	}
 	if err := scp.Wait(); err != nil {
 		log.Println("error running scp", mkv.Filename, out, err)
+		if ctx.Err() != nil {
+			// don't leave a partial copy behind in .input
+			t.runCommand(context.Background(), fmt.Sprintf("rm -rf '%s'", escapeSsh(ingestfile)))
+			return ctx.Err()
+		}
 		return err
 	}
 
 	var cmd string
 
 	// check sha256sum
-	cmd = fmt.Sprintf("echo '%s  %s' | sha256sum -c", mkv.Shasum, escapeSsh(ingestfile))
-	if err := t.runCommand(cmd); err != nil {
+	if stat.IsDir() {
+		// the same sum as util.Sha256sumDir
+		cmd = fmt.Sprintf("cd '%s' && %s | sha256sum | grep -q '^%s '", escapeSsh(ingestfile), sumDirCmd, mkv.Shasum)
+	} else {
+		cmd = fmt.Sprintf("echo '%s  %s' | sha256sum -c", mkv.Shasum, escapeSsh(ingestfile))
+	}
+	if err := t.runCommand(ctx, cmd); err != nil {
 		log.Println("failed to verify checksum")
 		return err
 	}
 
 	// create directory
 	cmd = fmt.Sprintf("mkdir -p '%s'", escapeSsh(newdir))
-	if err := t.runCommand(cmd); err != nil {
+	if err := t.runCommand(ctx, cmd); err != nil {
 		log.Println("failed to mkdir", newdir)
 		return err
 	}
 
 	// fix permissions
 	cmd = fmt.Sprintf("chmod 775 '%s'", escapeSsh(newdir))
-	if err := t.runCommand(cmd); err != nil {
+	if err := t.runCommand(ctx, cmd); err != nil {
 		log.Println("failed to chmod dir", newdir)
 		return err
 	}
-	cmd = fmt.Sprintf("chmod 664 '%s'", escapeSsh(ingestfile))
-	if err := t.runCommand(cmd); err != nil {
+	if stat.IsDir() {
+		cmd = fmt.Sprintf("chmod -R u=rwX,g=rwX,o=rX '%s'", escapeSsh(ingestfile))
+	} else {
+		cmd = fmt.Sprintf("chmod 664 '%s'", escapeSsh(ingestfile))
+	}
+	if err := t.runCommand(ctx, cmd); err != nil {
 		log.Println("failed to chmod file", newdir)
 		return err
 	}
 
 	// add sha256sum to movies.sha256
-	if t.useMovieDir {
-		cmd = fmt.Sprintf("echo '%s  %s/%s' | sort -k2 -u -o %s -m - %s", mkv.Shasum, escapeSsh(moviedir), escapeSsh(mkvfile), shafile, shafile)
+	shakey := path.Join(moviedir, mkvfile)
+	if stat.IsDir() {
+		prefix := strings.ReplaceAll(escapeSsh(shakey), "|", `\|`)
+		cmd = fmt.Sprintf("(cd '%s' && %s) | sed 's|  \\./|  %s/|' | sort -k2 -u -o '%s' - '%s'", escapeSsh(ingestfile), sumDirCmd, prefix, escapeSsh(shafile), escapeSsh(shafile))
 	} else {
-		cmd = fmt.Sprintf("echo '%s  %s' | sort -k2 -u -o %s -m - %s", mkv.Shasum, escapeSsh(mkvfile), shafile, shafile)
+		cmd = fmt.Sprintf("echo '%s  %s' | sort -k2 -u -o '%s' -m - '%s'", mkv.Shasum, escapeSsh(shakey), escapeSsh(shafile), escapeSsh(shafile))
 	}
-	if err := t.runCommand(cmd); err != nil {
+	if err := t.runCommand(ctx, cmd); err != nil {
 		log.Println("failed to add shasum", newdir)
 		return err
 	}
 
 	// move Files
 	cmd = fmt.Sprintf("mv '%s' '%s'", escapeSsh(ingestfile), escapeSsh(newfile))
-	if err := t.runCommand(cmd); err != nil {
+	if err := t.runCommand(ctx, cmd); err != nil {
 		log.Println("failed to move files", newdir)
 		return err
 	}
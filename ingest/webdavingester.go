@@ -0,0 +1,311 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+)
+
+// WebdavIngester uploads files to a webdav share, over https for webdavs
+// urls. The user of the url and the target's password are sent with basic
+// auth. The upload is verified by its size and by reading it back, before
+// it's moved into place.
+type WebdavIngester struct {
+	uri      *url.URL
+	naming   *Naming
+	shafile  string
+	password string
+	client   *http.Client
+}
+
+// url returns the http url of p on the server
+func (t *WebdavIngester) url(p string) string {
+	scheme := "http"
+	if t.uri.Scheme == "webdavs" {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: t.uri.Host, Path: p}
+	return u.String()
+}
+
+func (t *WebdavIngester) do(ctx context.Context, method string, p string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.url(p), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if user := t.uri.User.Username(); user != "" {
+		req.SetBasicAuth(user, t.password)
+	}
+	return t.client.Do(req)
+}
+
+// request runs a request whose response has no body worth reading, and fails
+// unless the response status is one of ok.
+func (t *WebdavIngester) request(ctx context.Context, method string, p string, body io.Reader, size int64, header http.Header, ok ...int) error {
+	resp, err := t.do(ctx, method, p, body, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: %s", method, p, resp.Status)
+}
+
+// mkcolAll makes dir and any of its parents under the target path.
+func (t *WebdavIngester) mkcolAll(ctx context.Context, dir string) error {
+	rel := strings.TrimPrefix(strings.TrimPrefix(dir, t.uri.Path), "/")
+	p := t.uri.Path
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" {
+			continue
+		}
+		p = path.Join(p, part)
+		// 405 is returned if it already exists
+		if err := t.request(ctx, "MKCOL", p+"/", nil, 0, nil, http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *WebdavIngester) move(ctx context.Context, src string, dst string) error {
+	header := http.Header{
+		"Destination": {t.url(dst)},
+		"Overwrite":   {"T"},
+	}
+	return t.request(ctx, "MOVE", src, nil, 0, header, http.StatusCreated, http.StatusNoContent)
+}
+
+func (t *WebdavIngester) Ingest(ctx context.Context, mkv model.MkvFile, media model.Media, progress Progress) error {
+	moviedir, mkvfile, err := t.naming.Path(mkv, media)
+	if err != nil {
+		log.Println("error naming file", err)
+		return err
+	}
+
+	newdir := path.Join(t.uri.Path, moviedir)
+	newfile := path.Join(newdir, mkvfile)
+	inputdir := path.Join(t.uri.Path, ".input")
+	ingestfile := path.Join(inputdir, path.Base(mkv.Filename))
+	var shafile string
+	if path.IsAbs(t.shafile) {
+		shafile = t.shafile
+	} else {
+		shafile = path.Join(t.uri.Path, t.shafile)
+	}
+
+	stat, err := os.Stat(mkv.Filename)
+	if err != nil {
+		log.Println("error opening existing file", mkv.Filename, err)
+		return err
+	}
+
+	if err := t.mkcolAll(ctx, inputdir); err != nil {
+		log.Println("error making input dir", err)
+		return err
+	}
+	// clear out what's left of an interrupted copy
+	if err := t.request(ctx, http.MethodDelete, ingestfile, nil, 0, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound); err != nil {
+		return err
+	}
+
+	log.Println("starting webdav upload", mkv.Filename, ingestfile)
+	sizes, err := t.upload(ctx, mkv.Filename, ingestfile, progress)
+	if err != nil {
+		log.Println("error copying file", mkv.Filename, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	// check the size and sha256sum by reading the copy back
+	log.Println("Checking shasum", ingestfile)
+	sums := make(map[string]string, len(sizes))
+	for name, size := range sizes {
+		sum, err := t.sha256sum(ctx, path.Join(ingestfile, name), size)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		sums[name] = sum
+	}
+	var shasum string
+	if stat.IsDir() {
+		shasum = util.Sha256sumListing(sums)
+	} else {
+		shasum = sums["."]
+	}
+	if shasum != mkv.Shasum {
+		return fmt.Errorf("shasum does not match expected: %s, actual: %s", mkv.Shasum, shasum)
+	}
+
+	// create directory
+	if err := t.mkcolAll(ctx, newdir); err != nil {
+		return err
+	}
+
+	// add sha256sum to movies.sha256
+	log.Println("Adding shasum to shasums file")
+	shasums, err := t.readShasums(ctx, shafile)
+	if err != nil {
+		return err
+	}
+	shakey := path.Join(moviedir, mkvfile)
+	if stat.IsDir() {
+		for name, sum := range sums {
+			shasums[path.Join(shakey, name)] = sum
+		}
+	} else {
+		shasums[shakey] = shasum
+	}
+	if err := t.writeShasums(ctx, shafile, shasums); err != nil {
+		return err
+	}
+
+	// move Files
+	log.Println("Moving files")
+	if err := t.move(ctx, ingestfile, newfile); err != nil {
+		return err
+	}
+
+	log.Println("Done.")
+	return nil
+}
+
+// upload copies the file or disc backup folder src to dst, and returns the
+// size of every file by its path relative to dst, which is "." for a file.
+func (t *WebdavIngester) upload(ctx context.Context, src string, dst string, progress Progress) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	var total int64
+	err := filepath.WalkDir(src, func(f string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, f)
+		if err != nil {
+			return err
+		}
+		if rel != "." {
+			rel = "./" + filepath.ToSlash(rel)
+		}
+		sizes[rel] = info.Size()
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p := newCopyProgress(progress, total)
+
+	err = filepath.WalkDir(src, func(f string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, f)
+		if err != nil {
+			return err
+		}
+		out := path.Join(dst, filepath.ToSlash(rel))
+		if d.IsDir() {
+			return t.request(ctx, "MKCOL", out+"/", nil, 0, nil, http.StatusCreated)
+		}
+
+		i, err := os.Open(f)
+		if err != nil {
+			return err
+		}
+		defer i.Close()
+		info, err := i.Stat()
+		if err != nil {
+			return err
+		}
+		return t.request(ctx, http.MethodPut, out, progressReader{contextReader{ctx, i}, p}, info.Size(), nil, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	})
+	return sizes, err
+}
+
+// sha256sum reads file back from the server, and fails if it isn't size
+// bytes long.
+func (t *WebdavIngester) sha256sum(ctx context.Context, file string, size int64) (string, error) {
+	resp, err := t.do(ctx, http.MethodGet, file, nil, 0, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", file, resp.Status)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("size of %s does not match expected: %d, actual: %d", file, size, n)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func (t *WebdavIngester) readShasums(ctx context.Context, shafile string) (map[string]string, error) {
+	resp, err := t.do(ctx, http.MethodGet, shafile, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return parseShasums(nil), nil
+	case http.StatusOK:
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return parseShasums(b), nil
+	default:
+		return nil, fmt.Errorf("GET %s: %s", shafile, resp.Status)
+	}
+}
+
+// writeShasums uploads the shasums next to shafile and moves it over shafile,
+// so it's never left half written.
+func (t *WebdavIngester) writeShasums(ctx context.Context, shafile string, shasums map[string]string) error {
+	tmp := path.Join(path.Dir(shafile), "."+path.Base(shafile)+".tmp")
+	b := formatShasums(shasums)
+	header := http.Header{"Content-Type": {"text/plain"}}
+	if err := t.request(ctx, http.MethodPut, tmp, bytes.NewReader(b), int64(len(b)), header, http.StatusCreated, http.StatusNoContent, http.StatusOK); err != nil {
+		return err
+	}
+	return t.move(ctx, tmp, shafile)
+}
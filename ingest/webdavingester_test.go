@@ -0,0 +1,147 @@
+package ingest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+	"golang.org/x/net/webdav"
+)
+
+// newTestWebdavIngester serves a temp dir over webdav, for the user rips with
+// the password secret, and returns an ingester for its movies folder.
+func newTestWebdavIngester(t *testing.T, naming *Naming) (*WebdavIngester, string) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.Mkdir(path.Join(root, "movies"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	handler := &webdav.Handler{FileSystem: webdav.Dir(root), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "rips" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	uri := &url.URL{Scheme: "webdav", User: url.User("rips"), Host: strings.TrimPrefix(server.URL, "http://"), Path: "/movies"}
+	return &WebdavIngester{uri, naming, "movies.sha256", "secret", server.Client()}, path.Join(root, "movies")
+}
+
+func TestWebdavIngest(t *testing.T) {
+	ingester, target := newTestWebdavIngester(t, DefaultNaming(true))
+
+	src := path.Join(t.TempDir(), "bar.mkv")
+	if err := os.WriteFile(src, []byte(mkvfileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(target, "movies.sha256"), []byte(strings.TrimLeft(movieDirShafileContent, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var copied, total int64
+	progress := func(c int64, _ int64, tot int64) { copied, total = c, tot }
+	mkv := model.MkvFile{Filename: src, Shasum: shasum, Resolution: res}
+	if err := ingester.Ingest(context.Background(), mkv, media, progress); err != nil {
+		t.Fatalf("ingester.Ingest() error: %v", err)
+	}
+	if copied != int64(len(mkvfileContent)) || total != copied {
+		t.Fatalf("progress = %d/%d, expected: %d/%d", copied, total, len(mkvfileContent), len(mkvfileContent))
+	}
+
+	outfile := path.Join(target, "bar (1989)", "bar (1989) [1080p].mkv")
+	if b, err := os.ReadFile(outfile); err != nil || string(b) != mkvfileContent {
+		t.Fatalf("os.ReadFile(%s) = %q, %v, expected: %q", outfile, b, err, mkvfileContent)
+	}
+
+	b, err := os.ReadFile(path.Join(target, "movies.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2  bar (1989)/bar (1989) [1080p].mkv
+97df3588b5a3f24babc3851b372f0ba71a9dcdded43b14b9d06961bfc1707d9d  bar (1989)/bar (1989) [4k].mkv
+1b8e84ccf80aae39e1ca16393920c801a8fb78c5ae8ce5e6a5d636baa3d9386d  baz (2000)/baz (2000) [4k].mkv
+5ecf8d2cc410094e8b82dd0bc178a57f3aa1e80916689beb00fe56148b1b1256  foo (1990)/foo (1990) [480p].mkv
+`
+	if string(b) != expected {
+		t.Fatalf("shafile = %q, expected: %q", b, expected)
+	}
+
+	// a bad copy is never moved into place
+	mkv.Shasum = strings.Repeat("0", 64)
+	if err := os.Remove(outfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingester.Ingest(context.Background(), mkv, media, nil); err == nil || !strings.Contains(err.Error(), "shasum does not match") {
+		t.Fatalf("ingester.Ingest() = %v, expected a shasum error", err)
+	}
+	if _, err := os.Stat(outfile); !os.IsNotExist(err) {
+		t.Fatalf("os.Stat(%s) = %v, expected the file not to exist", outfile, err)
+	}
+}
+
+func TestWebdavIngestBackupDir(t *testing.T) {
+	naming, err := NewNaming(DefaultBackupTemplate, DefaultEpisodeTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingester, target := newTestWebdavIngester(t, naming)
+
+	backupdir := path.Join(t.TempDir(), "backup")
+	if err := os.MkdirAll(backupdir+"/BDMV/STREAM", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/index.bdmv", []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupdir+"/BDMV/STREAM/00000.m2ts", []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := util.Sha256sumDir(backupdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ingester.Ingest(context.Background(), model.MkvFile{Filename: backupdir, Shasum: sum}, media, nil); err != nil {
+		t.Fatalf("ingester.Ingest() error: %v", err)
+	}
+	if b, err := os.ReadFile(path.Join(target, "bar (1989)/BDMV/STREAM/00000.m2ts")); err != nil || string(b) != "bar" {
+		t.Fatalf("backup file = %q, %v, expected: bar", b, err)
+	}
+
+	b, err := os.ReadFile(path.Join(target, "movies.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9  bar (1989)/BDMV/STREAM/00000.m2ts
+2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  bar (1989)/BDMV/index.bdmv
+`
+	if string(b) != expected {
+		t.Fatalf("shafile = %q, expected: %q", b, expected)
+	}
+}
+
+func TestWebdavIngestUnauthorized(t *testing.T) {
+	ingester, target := newTestWebdavIngester(t, DefaultNaming(false))
+	ingester.password = "wrong"
+
+	src := path.Join(t.TempDir(), "bar.mkv")
+	if err := os.WriteFile(src, []byte(mkvfileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ingester.Ingest(context.Background(), model.MkvFile{Filename: src, Shasum: shasum}, media, nil); err == nil {
+		t.Fatal("expected a wrong password to fail")
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Fatalf("target has %d entries, expected nothing copied", len(entries))
+	}
+}
@@ -0,0 +1,217 @@
+package metadata
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	value    []byte
+	notFound bool
+	fetched  time.Time
+}
+
+type sqliteCache struct {
+	mutex    sync.RWMutex
+	db       *sql.DB
+	name     string
+	provider Provider
+	ttl      time.Duration
+	entries  map[string]cacheEntry
+}
+
+// NewSqliteCache wraps provider so its responses, including titles that
+// weren't found, are kept in db for ttl. Expired entries are still returned
+// when the provider can't be reached. name keeps the entries of different
+// providers apart.
+func NewSqliteCache(db *sql.DB, name string, provider Provider, ttl time.Duration) (Provider, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS metadata_cache (
+		provider TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT,
+		not_found INTEGER NOT NULL,
+		fetched INTEGER NOT NULL,
+		PRIMARY KEY(provider, key)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &sqliteCache{
+		db:       db,
+		name:     name,
+		provider: provider,
+		ttl:      ttl,
+		entries:  make(map[string]cacheEntry),
+	}
+
+	rows, err := db.Query("SELECT key, value, not_found, fetched FROM metadata_cache WHERE provider = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		var notFound bool
+		var fetched int64
+		if err := rows.Scan(&key, &value, &notFound, &fetched); err != nil {
+			log.Println("error scanning metadata_cache row:", err)
+			continue
+		}
+		c.entries[key] = cacheEntry{[]byte(value.String), notFound, time.Unix(fetched, 0)}
+	}
+	return c, nil
+}
+
+func (c *sqliteCache) get(key string) (cacheEntry, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *sqliteCache) put(key string, value any, notFound bool) {
+	var b []byte
+	if !notFound {
+		var err error
+		if b, err = json.Marshal(value); err != nil {
+			log.Println("error marshaling cache entry", key, "err:", err)
+			return
+		}
+	}
+	entry := cacheEntry{b, notFound, time.Now()}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = entry
+	_, err := c.db.Exec(
+		`INSERT INTO metadata_cache (provider, key, value, not_found, fetched)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(provider, key) DO UPDATE SET
+			value = excluded.value,
+			not_found = excluded.not_found,
+			fetched = excluded.fetched`,
+		c.name, key, string(b), notFound, entry.fetched.Unix(),
+	)
+	if err != nil {
+		log.Println("error saving cache entry", key, "err:", err)
+	}
+}
+
+// putTitle caches t under each of its ids, so later lookups by either of them
+// are hits.
+func (c *sqliteCache) putTitle(t *Title) {
+	for _, key := range titleKeys(t.Ids, t.Kind) {
+		c.put(key, t, false)
+	}
+}
+
+func titleKeys(ids Ids, kind Kind) []string {
+	keys := make([]string, 0, 2)
+	if ids.Imdb != "" {
+		keys = append(keys, fmt.Sprintf("get/%s/imdb/%s", kind, ids.Imdb))
+	}
+	if ids.Tmdb != "" {
+		keys = append(keys, fmt.Sprintf("get/%s/tmdb/%s", kind, ids.Tmdb))
+	}
+	return keys
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// cached returns the entry for key if it hasn't expired, otherwise fetches it.
+func cached[T any](c *sqliteCache, key string, fetch func() (T, error)) (T, error) {
+	var value T
+	entry, ok := c.get(key)
+	if ok && time.Since(entry.fetched) < c.ttl {
+		return decode[T](entry)
+	}
+
+	value, err := fetch()
+	if err == nil {
+		c.put(key, value, false)
+		return value, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		c.put(key, nil, true)
+		return value, err
+	}
+	if ok {
+		log.Println("serving stale cache entry", key, "err:", err)
+		return decode[T](entry)
+	}
+	return value, err
+}
+
+func decode[T any](entry cacheEntry) (T, error) {
+	var value T
+	if entry.notFound {
+		return value, ErrNotFound
+	}
+	err := json.Unmarshal(entry.value, &value)
+	return value, err
+}
+
+func (c *sqliteCache) Search(query string, kind Kind, limit int) ([]*Title, error) {
+	key := fmt.Sprintf("search/%s/%d/%s", kind, limit, normalize(query))
+	return cached(c, key, func() ([]*Title, error) {
+		titles, err := c.provider.Search(query, kind, limit)
+		for _, t := range titles {
+			c.putTitle(t)
+		}
+		return titles, err
+	})
+}
+
+func (c *sqliteCache) Get(ids Ids, kind Kind) (*Title, error) {
+	keys := titleKeys(ids, kind)
+	if len(keys) == 0 {
+		return c.provider.Get(ids, kind)
+	}
+	// a fresh entry under any of the ids will do
+	for _, key := range keys[1:] {
+		if entry, ok := c.get(key); ok && time.Since(entry.fetched) < c.ttl {
+			return decode[*Title](entry)
+		}
+	}
+	return cached(c, keys[0], func() (*Title, error) {
+		t, err := c.provider.Get(ids, kind)
+		if err == nil {
+			c.putTitle(t)
+		}
+		return t, err
+	})
+}
+
+func (c *sqliteCache) FindTitle(name string, kind Kind) (*Title, error) {
+	key := fmt.Sprintf("find/%s/%s", kind, normalize(name))
+	return cached(c, key, func() (*Title, error) {
+		t, err := c.provider.FindTitle(name, kind)
+		if err == nil {
+			c.putTitle(t)
+		}
+		return t, err
+	})
+}
+
+func (c *sqliteCache) Season(ids Ids, season int) ([]Episode, error) {
+	if ids.IsEmpty() {
+		return c.provider.Season(ids, season)
+	}
+	key := fmt.Sprintf("season/%s/%s/%d", ids.Imdb, ids.Tmdb, season)
+	return cached(c, key, func() ([]Episode, error) {
+		return c.provider.Season(ids, season)
+	})
+}
@@ -0,0 +1,182 @@
+package metadata
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// countingProvider knows one movie and counts the calls it gets
+type countingProvider struct {
+	calls int
+	err   error
+}
+
+var testTitle = &Title{Ids: Ids{Imdb: "tt0133093", Tmdb: "603"}, Kind: Movie, Title: "The Matrix", Year: "1999"}
+
+func (p *countingProvider) Search(query string, kind Kind, limit int) ([]*Title, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return []*Title{testTitle}, nil
+}
+
+func (p *countingProvider) Get(ids Ids, kind Kind) (*Title, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	if ids.Imdb == testTitle.Ids.Imdb || ids.Tmdb == testTitle.Ids.Tmdb {
+		return testTitle, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (p *countingProvider) FindTitle(name string, kind Kind) (*Title, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	if normalize(name) == "the matrix" {
+		return testTitle, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (p *countingProvider) Season(ids Ids, season int) ([]Episode, error) {
+	p.calls++
+	return nil, p.err
+}
+
+func openTestCache(t *testing.T, db *sql.DB, p Provider, ttl time.Duration) *sqliteCache {
+	t.Helper()
+	c, err := NewSqliteCache(db, "test", p, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*sqliteCache)
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSqliteCache_Hits(t *testing.T) {
+	p := &countingProvider{}
+	c := openTestCache(t, openTestDB(t), p, time.Hour)
+
+	for range 2 {
+		if got, err := c.FindTitle("The  Matrix", Movie); err != nil || got.Title != "The Matrix" {
+			t.Fatalf("FindTitle = %v, %v", got, err)
+		}
+	}
+	if p.calls != 1 {
+		t.Fatalf("expected 1 call, got %d", p.calls)
+	}
+
+	// found titles are cached by both ids
+	if _, err := c.Get(Ids{Tmdb: "603"}, Movie); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(Ids{Imdb: "tt0133093"}, Movie); err != nil {
+		t.Fatal(err)
+	}
+	if p.calls != 1 {
+		t.Fatalf("expected Get to hit the cache, got %d calls", p.calls)
+	}
+
+	// so are titles that weren't found
+	for range 2 {
+		if _, err := c.FindTitle("nothing", Movie); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if p.calls != 2 {
+		t.Fatalf("expected not found to be cached, got %d calls", p.calls)
+	}
+
+	c.Search("matrix", Movie, 4)
+	c.Search("matrix", Movie, 4)
+	if p.calls != 3 {
+		t.Fatalf("expected search to be cached, got %d calls", p.calls)
+	}
+}
+
+func TestSqliteCache_Stale(t *testing.T) {
+	p := &countingProvider{}
+	c := openTestCache(t, openTestDB(t), p, time.Hour)
+
+	c.FindTitle("the matrix", Movie)
+	c.Get(Ids{Imdb: "tt0133093"}, Movie)
+	for key, entry := range c.entries {
+		entry.fetched = time.Now().Add(-2 * time.Hour)
+		c.entries[key] = entry
+	}
+
+	p.err = errors.New("connection refused")
+	got, err := c.FindTitle("the matrix", Movie)
+	if err != nil || got.Title != "The Matrix" {
+		t.Fatalf("expected stale entry while offline, got %v, %v", got, err)
+	}
+	if p.calls != 2 {
+		t.Fatalf("expected expired entry to be fetched again, got %d calls", p.calls)
+	}
+	if _, err := c.FindTitle("uncached", Movie); err == nil {
+		t.Fatal("expected error for uncached entry while offline")
+	}
+
+	p.err = nil
+	c.FindTitle("the matrix", Movie)
+	if entry, _ := c.get("find/movie/the matrix"); time.Since(entry.fetched) > time.Minute {
+		t.Fatal("expected entry to be refreshed")
+	}
+}
+
+func TestSqliteCache_Persistence(t *testing.T) {
+	dbPath := t.TempDir() + "/cache.db"
+
+	db1, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &countingProvider{}
+	c1 := openTestCache(t, db1, p, time.Hour)
+	c1.FindTitle("the matrix", Movie)
+	c1.FindTitle("nothing", Movie)
+	db1.Close()
+
+	db2, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	c2 := openTestCache(t, db2, p, time.Hour)
+	if got, err := c2.FindTitle("the matrix", Movie); err != nil || got.Title != "The Matrix" {
+		t.Fatalf("FindTitle after reopen = %v, %v", got, err)
+	}
+	if _, err := c2.FindTitle("nothing", Movie); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after reopen, got %v", err)
+	}
+	if p.calls != 2 {
+		t.Fatalf("expected entries to persist, got %d calls", p.calls)
+	}
+
+	other, err := NewSqliteCache(db2, "other", p, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.FindTitle("the matrix", Movie)
+	if p.calls != 3 {
+		t.Fatal("expected providers to not share entries")
+	}
+}
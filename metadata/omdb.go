@@ -0,0 +1,153 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/eefret/gomdb"
+)
+
+type omdbProvider struct {
+	api    *gomdb.OmdbApi
+	apikey string
+}
+
+func NewOmdbProvider(apikey string) Provider {
+	return &omdbProvider{gomdb.Init(apikey), apikey}
+}
+
+func omdbType(kind Kind) string {
+	if kind == Series {
+		return gomdb.SeriesSearch
+	}
+	return gomdb.MovieSearch
+}
+
+func (p *omdbProvider) Search(query string, kind Kind, limit int) ([]*Title, error) {
+	res, err := p.api.Search(&gomdb.QueryData{Title: query, SearchType: omdbType(kind)})
+	if res == nil && err != nil {
+		return nil, err
+	}
+
+	return fetchAll(resultCount(len(res.Search), limit), func(i int) (*Title, error) {
+		return p.Get(Ids{Imdb: res.Search[i].ImdbID}, kind)
+	}), nil
+}
+
+func (p *omdbProvider) Get(ids Ids, kind Kind) (*Title, error) {
+	if ids.Imdb == "" {
+		return nil, ErrNoId
+	}
+	m, err := p.api.MovieByImdbID(ids.Imdb)
+	if err != nil {
+		return nil, omdbError(err)
+	}
+	return omdbTitle(m), nil
+}
+
+func (p *omdbProvider) FindTitle(name string, kind Kind) (*Title, error) {
+	m, err := p.api.MovieByTitle(&gomdb.QueryData{Title: name, SearchType: omdbType(kind)})
+	if err != nil {
+		return nil, omdbError(err)
+	}
+	return omdbTitle(m), nil
+}
+
+type omdbSeason struct {
+	Title        string
+	Season       string
+	TotalSeasons string
+	Episodes     []struct {
+		Title    string
+		Released string
+		Episode  string
+		ImdbID   string
+	}
+	Response string
+	Error    string
+}
+
+// Season fetches the episode list of a season directly, since gomdb doesn't
+// support it.
+func (p *omdbProvider) Season(ids Ids, season int) ([]Episode, error) {
+	if ids.Imdb == "" {
+		return nil, ErrNoId
+	}
+	params := url.Values{}
+	params.Add("apikey", p.apikey)
+	params.Add("i", ids.Imdb)
+	params.Add("Season", strconv.Itoa(season))
+
+	resp, err := http.Get("http://www.omdbapi.com/?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d received from omdb", resp.StatusCode)
+	}
+
+	var s omdbSeason
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Response == "False" {
+		return nil, omdbError(errors.New(s.Error))
+	}
+
+	episodes := make([]Episode, len(s.Episodes))
+	for i, e := range s.Episodes {
+		n, _ := strconv.Atoi(e.Episode)
+		episodes[i] = Episode{Episode: n, Title: e.Title, Released: omdbValue(e.Released)}
+	}
+	return episodes, nil
+}
+
+func omdbError(err error) error {
+	if strings.Contains(err.Error(), "not found") {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+// omdb uses "N/A" for missing values
+func omdbValue(s string) string {
+	if s == "N/A" {
+		return ""
+	}
+	return s
+}
+
+func omdbTitle(m *gomdb.MovieResult) *Title {
+	t := &Title{
+		Ids:    Ids{Imdb: m.ImdbID},
+		Kind:   Movie,
+		Title:  m.Title,
+		Year:   m.Year,
+		Rated:  omdbValue(m.Rated),
+		Plot:   omdbValue(m.Plot),
+		Poster: omdbValue(m.Poster),
+	}
+	if m.Type == gomdb.SeriesSearch {
+		t.Kind = Series
+		t.Year = seriesYear(m.Year)
+	}
+	if minutes, ok := strings.CutSuffix(m.Runtime, " min"); ok {
+		t.Runtime, _ = strconv.Atoi(minutes)
+	}
+	return t
+}
+
+// seriesYear returns the year a series started, omdb reports series years as
+// ranges like "1994–2004".
+func seriesYear(year string) string {
+	if len(year) > 4 {
+		return year[0:4]
+	}
+	return year
+}
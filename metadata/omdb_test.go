@@ -0,0 +1,32 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/eefret/gomdb"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOmdbTitle(t *testing.T) {
+	got := omdbTitle(&gomdb.MovieResult{
+		Title:   "Friends",
+		Year:    "1994–2004",
+		Rated:   "TV-14",
+		Runtime: "22 min",
+		Plot:    "N/A",
+		Poster:  "N/A",
+		ImdbID:  "tt0108778",
+		Type:    "series",
+	})
+	expected := &Title{
+		Ids:     Ids{Imdb: "tt0108778"},
+		Kind:    Series,
+		Title:   "Friends",
+		Year:    "1994",
+		Rated:   "TV-14",
+		Runtime: 22,
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Fatalf("omdbTitle mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,96 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+type Kind string
+
+const (
+	Movie  Kind = "movie"
+	Series Kind = "series"
+)
+
+var ErrNotFound = errors.New("title not found")
+var ErrNoId = errors.New("no id this provider can look up")
+
+// Ids are the ids of a title with each provider, any of them may be empty.
+type Ids struct {
+	Imdb string `json:",omitempty"`
+	Tmdb string `json:",omitempty"`
+}
+
+func (ids Ids) IsEmpty() bool {
+	return ids.Imdb == "" && ids.Tmdb == ""
+}
+
+// Title is a movie or series. Year is the first year of a series, Runtime is
+// in minutes and is 0 if unknown.
+type Title struct {
+	Ids     Ids
+	Kind    Kind
+	Title   string
+	Year    string
+	Rated   string `json:",omitempty"`
+	Runtime int    `json:",omitempty"`
+	Plot    string `json:",omitempty"`
+	Poster  string `json:",omitempty"`
+}
+
+// Url returns a page about the title, on imdb if the imdb id is known.
+func (t *Title) Url() string {
+	if t.Ids.Imdb != "" {
+		return fmt.Sprintf("https://www.imdb.com/title/%s", t.Ids.Imdb)
+	}
+	if t.Kind == Series {
+		return fmt.Sprintf("https://www.themoviedb.org/tv/%s", t.Ids.Tmdb)
+	}
+	return fmt.Sprintf("https://www.themoviedb.org/movie/%s", t.Ids.Tmdb)
+}
+
+type Episode struct {
+	Episode  int
+	Title    string
+	Released string
+}
+
+// Provider looks up movie and series details.
+type Provider interface {
+	// Search returns the full details of up to limit results, or all of them
+	// if limit is 0.
+	Search(query string, kind Kind, limit int) ([]*Title, error)
+	// Get looks a title up by whichever of its ids the provider supports.
+	Get(ids Ids, kind Kind) (*Title, error)
+	// FindTitle returns the best match for a name.
+	FindTitle(name string, kind Kind) (*Title, error)
+	// Season returns the episodes of one season of a series.
+	Season(ids Ids, season int) ([]Episode, error)
+}
+
+// fetchAll calls get for the first n results concurrently, dropping the ones
+// that fail.
+func fetchAll(n int, get func(i int) (*Title, error)) []*Title {
+	var wg sync.WaitGroup
+	titles := make([]*Title, n)
+	for i := range n {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if t, err := get(i); err == nil {
+				titles[i] = t
+			}
+		}(i)
+	}
+	wg.Wait()
+	return slices.DeleteFunc(titles, func(t *Title) bool { return t == nil })
+}
+
+func resultCount(total int, limit int) int {
+	if limit <= 0 || limit > total {
+		return total
+	}
+	return limit
+}
@@ -0,0 +1,218 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const tmdbUrl = "https://api.themoviedb.org/3"
+const tmdbImageUrl = "https://image.tmdb.org/t/p/w342"
+
+type tmdbProvider struct {
+	apikey  string
+	baseUrl string
+	client  *http.Client
+}
+
+// NewTmdbProvider accepts either a v3 api key or a v4 read access token.
+func NewTmdbProvider(apikey string) Provider {
+	return &tmdbProvider{apikey, tmdbUrl, http.DefaultClient}
+}
+
+type tmdbResult struct {
+	Id             int    `json:"id"`
+	Title          string `json:"title"`
+	Name           string `json:"name"`
+	ReleaseDate    string `json:"release_date"`
+	FirstAirDate   string `json:"first_air_date"`
+	Overview       string `json:"overview"`
+	PosterPath     string `json:"poster_path"`
+	Runtime        int    `json:"runtime"`
+	EpisodeRunTime []int  `json:"episode_run_time"`
+	ImdbId         string `json:"imdb_id"`
+	ExternalIds    struct {
+		ImdbId string `json:"imdb_id"`
+	} `json:"external_ids"`
+}
+
+type tmdbResults struct {
+	Results []tmdbResult `json:"results"`
+}
+
+type tmdbFind struct {
+	MovieResults []tmdbResult `json:"movie_results"`
+	TvResults    []tmdbResult `json:"tv_results"`
+}
+
+type tmdbSeason struct {
+	Episodes []struct {
+		EpisodeNumber int    `json:"episode_number"`
+		Name          string `json:"name"`
+		AirDate       string `json:"air_date"`
+	} `json:"episodes"`
+}
+
+func tmdbType(kind Kind) string {
+	if kind == Series {
+		return "tv"
+	}
+	return "movie"
+}
+
+func (p *tmdbProvider) get(path string, params url.Values, v any) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	req, err := http.NewRequest(http.MethodGet, p.baseUrl+path, nil)
+	if err != nil {
+		return err
+	}
+	// v4 tokens are JWTs, v3 keys are plain hex
+	if strings.Count(p.apikey, ".") == 2 {
+		req.Header.Set("Authorization", "Bearer "+p.apikey)
+	} else {
+		params.Set("api_key", p.apikey)
+	}
+	req.URL.RawQuery = params.Encode()
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d received from tmdb", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *tmdbProvider) search(query string, kind Kind) ([]tmdbResult, error) {
+	var res tmdbResults
+	params := url.Values{}
+	params.Set("query", query)
+	if err := p.get("/search/"+tmdbType(kind), params, &res); err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+func (p *tmdbProvider) Search(query string, kind Kind, limit int) ([]*Title, error) {
+	results, err := p.search(query, kind)
+	if err != nil {
+		return nil, err
+	}
+	return fetchAll(resultCount(len(results), limit), func(i int) (*Title, error) {
+		return p.Get(Ids{Tmdb: strconv.Itoa(results[i].Id)}, kind)
+	}), nil
+}
+
+// Get looks up by tmdb id, or finds the tmdb id from the imdb id first.
+func (p *tmdbProvider) Get(ids Ids, kind Kind) (*Title, error) {
+	if ids.Tmdb == "" {
+		if ids.Imdb == "" {
+			return nil, ErrNoId
+		}
+		var err error
+		if ids.Tmdb, kind, err = p.find(ids.Imdb, kind); err != nil {
+			return nil, err
+		}
+	}
+
+	var res tmdbResult
+	params := url.Values{}
+	params.Set("append_to_response", "external_ids")
+	if err := p.get("/"+tmdbType(kind)+"/"+url.PathEscape(ids.Tmdb), params, &res); err != nil {
+		return nil, err
+	}
+	return tmdbTitle(res, kind), nil
+}
+
+func (p *tmdbProvider) find(imdbId string, kind Kind) (string, Kind, error) {
+	var res tmdbFind
+	params := url.Values{}
+	params.Set("external_source", "imdb_id")
+	if err := p.get("/find/"+url.PathEscape(imdbId), params, &res); err != nil {
+		return "", kind, err
+	}
+	if kind != Series && len(res.MovieResults) > 0 {
+		return strconv.Itoa(res.MovieResults[0].Id), Movie, nil
+	}
+	if len(res.TvResults) > 0 {
+		return strconv.Itoa(res.TvResults[0].Id), Series, nil
+	}
+	if len(res.MovieResults) > 0 {
+		return strconv.Itoa(res.MovieResults[0].Id), Movie, nil
+	}
+	return "", kind, ErrNotFound
+}
+
+func (p *tmdbProvider) FindTitle(name string, kind Kind) (*Title, error) {
+	results, err := p.search(name, kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+	return p.Get(Ids{Tmdb: strconv.Itoa(results[0].Id)}, kind)
+}
+
+func (p *tmdbProvider) Season(ids Ids, season int) ([]Episode, error) {
+	if ids.Tmdb == "" {
+		if ids.Imdb == "" {
+			return nil, ErrNoId
+		}
+		var err error
+		if ids.Tmdb, _, err = p.find(ids.Imdb, Series); err != nil {
+			return nil, err
+		}
+	}
+
+	var res tmdbSeason
+	if err := p.get(fmt.Sprintf("/tv/%s/season/%d", url.PathEscape(ids.Tmdb), season), nil, &res); err != nil {
+		return nil, err
+	}
+	episodes := make([]Episode, len(res.Episodes))
+	for i, e := range res.Episodes {
+		episodes[i] = Episode{Episode: e.EpisodeNumber, Title: e.Name, Released: e.AirDate}
+	}
+	return episodes, nil
+}
+
+func tmdbTitle(r tmdbResult, kind Kind) *Title {
+	t := &Title{
+		Ids:     Ids{Imdb: r.ImdbId, Tmdb: strconv.Itoa(r.Id)},
+		Kind:    kind,
+		Title:   r.Title,
+		Year:    year(r.ReleaseDate),
+		Runtime: r.Runtime,
+		Plot:    r.Overview,
+	}
+	if kind == Series {
+		t.Ids.Imdb = r.ExternalIds.ImdbId
+		t.Title = r.Name
+		t.Year = year(r.FirstAirDate)
+		if len(r.EpisodeRunTime) > 0 {
+			t.Runtime = r.EpisodeRunTime[0]
+		}
+	}
+	if r.PosterPath != "" {
+		t.Poster = tmdbImageUrl + r.PosterPath
+	}
+	return t
+}
+
+// year returns the year of a yyyy-mm-dd date
+func year(date string) string {
+	if len(date) >= 4 {
+		return date[0:4]
+	}
+	return date
+}
@@ -0,0 +1,155 @@
+package metadata
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestTmdb(t *testing.T, apikey string) *tmdbProvider {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search/movie", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") != "the matrix" {
+			w.Write([]byte(`{"results": []}`))
+			return
+		}
+		w.Write([]byte(`{"results": [{"id": 603, "title": "The Matrix"}, {"id": 604, "title": "The Matrix Reloaded"}]}`))
+	})
+	mux.HandleFunc("/movie/603", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 603, "title": "The Matrix", "release_date": "1999-03-31", "runtime": 136, "imdb_id": "tt0133093", "overview": "Neo", "poster_path": "/m.jpg"}`))
+	})
+	mux.HandleFunc("/movie/604", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 604, "title": "The Matrix Reloaded", "release_date": "2003-05-15", "runtime": 138, "imdb_id": "tt0234215"}`))
+	})
+	mux.HandleFunc("/tv/1668", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1668, "name": "Friends", "first_air_date": "1994-09-22", "episode_run_time": [22], "external_ids": {"imdb_id": "tt0108778"}}`))
+	})
+	mux.HandleFunc("/tv/1668/season/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"episodes": [{"episode_number": 1, "name": "The Pilot", "air_date": "1994-09-22"}, {"episode_number": 2, "name": "The One with the Sonogram at the End"}]}`))
+	})
+	mux.HandleFunc("/find/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("external_source") != "imdb_id" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/find/tt0133093":
+			w.Write([]byte(`{"movie_results": [{"id": 603}], "tv_results": []}`))
+		case "/find/tt0108778":
+			w.Write([]byte(`{"movie_results": [], "tv_results": [{"id": 1668}]}`))
+		default:
+			w.Write([]byte(`{"movie_results": [], "tv_results": []}`))
+		}
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != apikey && r.Header.Get("Authorization") != "Bearer "+apikey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return &tmdbProvider{apikey, server.URL, server.Client()}
+}
+
+var matrix = &Title{
+	Ids:     Ids{Imdb: "tt0133093", Tmdb: "603"},
+	Kind:    Movie,
+	Title:   "The Matrix",
+	Year:    "1999",
+	Runtime: 136,
+	Plot:    "Neo",
+	Poster:  tmdbImageUrl + "/m.jpg",
+}
+
+func TestTmdbGet(t *testing.T) {
+	p := newTestTmdb(t, "key")
+
+	got, err := p.Get(Ids{Tmdb: "603"}, Movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(matrix, got); diff != "" {
+		t.Fatalf("Get by tmdb id mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = p.Get(Ids{Imdb: "tt0133093"}, Movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(matrix, got); diff != "" {
+		t.Fatalf("Get by imdb id mismatch (-want +got):\n%s", diff)
+	}
+
+	series, err := p.Get(Ids{Imdb: "tt0108778"}, Series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Title{Ids: Ids{Imdb: "tt0108778", Tmdb: "1668"}, Kind: Series, Title: "Friends", Year: "1994", Runtime: 22}
+	if diff := cmp.Diff(expected, series); diff != "" {
+		t.Fatalf("Get series mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := p.Get(Ids{Imdb: "tt0000000"}, Movie); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := p.Get(Ids{}, Movie); !errors.Is(err, ErrNoId) {
+		t.Fatalf("expected ErrNoId, got %v", err)
+	}
+}
+
+func TestTmdbSearch(t *testing.T) {
+	p := newTestTmdb(t, "key")
+
+	titles, err := p.Search("the matrix", Movie, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 2 || titles[0].Title != "The Matrix" || titles[1].Ids.Imdb != "tt0234215" {
+		t.Fatalf("unexpected search results %+v", titles)
+	}
+
+	titles, _ = p.Search("the matrix", Movie, 1)
+	if len(titles) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(titles))
+	}
+
+	got, err := p.FindTitle("the matrix", Movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(matrix, got); diff != "" {
+		t.Fatalf("FindTitle mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := p.FindTitle("nothing", Movie); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTmdbSeason(t *testing.T) {
+	p := newTestTmdb(t, "key")
+
+	episodes, err := p.Season(Ids{Imdb: "tt0108778"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Episode{
+		{Episode: 1, Title: "The Pilot", Released: "1994-09-22"},
+		{Episode: 2, Title: "The One with the Sonogram at the End"},
+	}
+	if diff := cmp.Diff(expected, episodes); diff != "" {
+		t.Fatalf("Season mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTmdbBearerToken(t *testing.T) {
+	p := newTestTmdb(t, "header.payload.signature")
+	if _, err := p.Get(Ids{Tmdb: "603"}, Movie); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,22 +1,44 @@
 package model
 
-import "github.com/aravance/go-makemkv"
-
 type WorkflowStatus string
 
 const (
-	StatusDone      WorkflowStatus = "Done"
-	StatusImporting WorkflowStatus = "Importing"
-	StatusRipping   WorkflowStatus = "Ripping"
-	StatusPending   WorkflowStatus = "Pending"
-	StatusError     WorkflowStatus = "Error"
-	StatusStart     WorkflowStatus = "Start"
+	StatusDone        WorkflowStatus = "Done"
+	StatusImporting   WorkflowStatus = "Importing"
+	StatusTranscoding WorkflowStatus = "Transcoding"
+	StatusRipping     WorkflowStatus = "Ripping"
+	StatusPending     WorkflowStatus = "Pending"
+	StatusQueued      WorkflowStatus = "Queued"
+	StatusError       WorkflowStatus = "Error"
+	StatusCancelled   WorkflowStatus = "Cancelled"
+	StatusStart       WorkflowStatus = "Start"
+)
+
+type IngestStatus string
+
+const (
+	IngestPending  IngestStatus = "Pending"
+	IngestCopying  IngestStatus = "Copying"
+	IngestVerified IngestStatus = "Verified"
+	IngestFailed   IngestStatus = "Failed"
 )
 
+// TargetStatus tracks the ingest of a workflow's file to one target, Target
+// is the target url.
+type TargetStatus struct {
+	Target string
+	Status IngestStatus
+	Error  string `json:",omitempty"`
+}
+
 type MkvFile struct {
-	Filename   string
-	Shasum     string
-	Resolution string
+	Filename    string
+	Shasum      string
+	Resolution  string
+	Codec       string `json:",omitempty"`
+	AudioFormat string `json:",omitempty"`
+	// Variant is the name of the encoder that transcoded the file
+	Variant string `json:",omitempty"`
 }
 
 type Workflow struct {
@@ -26,9 +48,131 @@ type Workflow struct {
 	OriginalName string
 	Status       WorkflowStatus
 	ImdbId       *string  `json:",omitempty"`
+	TmdbId       *string  `json:",omitempty"`
 	Name         *string  `json:",omitempty"`
 	Year         *string  `json:",omitempty"`
+	Edition      *string  `json:",omitempty"`
+	Season       *int     `json:",omitempty"`
+	Episode      *int     `json:",omitempty"`
 	File         *MkvFile `json:",omitempty"`
+	// Profile is the name of the rip profile, empty for the default one
+	Profile string `json:",omitempty"`
+
+	Targets []*TargetStatus `json:",omitempty"`
+	// Variants are the transcoded copies of File
+	Variants []*Variant `json:",omitempty"`
+
+	// Confidence is how sure an automatic match is, from 0 to 1. It is nil
+	// once a user has picked or confirmed the movie.
+	Confidence *float64 `json:",omitempty"`
+}
+
+// Media describes what a ripped file contains, and is used by ingesters to
+// decide where the file goes. Season and Episode are only set for episodes.
+type Media struct {
+	Name    string
+	Year    string
+	ImdbId  string
+	TmdbId  string
+	Edition string
+	Season  *int
+	Episode *int
+}
+
+func (m Media) IsEpisode() bool {
+	return m.Season != nil && m.Episode != nil
+}
+
+func (w *Workflow) IsEpisode() bool {
+	return w.Season != nil && w.Episode != nil
+}
+
+// BackupTitle is the title id of workflows that back up the whole disc
+// instead of ripping one title. Their File is the backup folder or iso.
+const BackupTitle = -1
+
+func (w *Workflow) IsBackup() bool {
+	return w.TitleId == BackupTitle
+}
+
+// ReviewConfidence is the confidence below which an automatic match is held
+// for review instead of being ingested.
+const ReviewConfidence = 0.6
+
+func (w *Workflow) NeedsReview() bool {
+	return w.Confidence != nil && *w.Confidence < ReviewConfidence
+}
+
+// SameTitle reports whether both workflows are for the same movie or series,
+// by any id they both have.
+func (w *Workflow) SameTitle(o *Workflow) bool {
+	return (w.ImdbId != nil && o.ImdbId != nil && *w.ImdbId == *o.ImdbId) ||
+		(w.TmdbId != nil && o.TmdbId != nil && *w.TmdbId == *o.TmdbId)
+}
+
+// TargetStatus returns the ingest status for target, creating a pending one
+// if the target hasn't been ingested to yet.
+func (w *Workflow) TargetStatus(target string) *TargetStatus {
+	return targetStatus(&w.Targets, target)
+}
+
+// Variant is a copy of a workflow's file transcoded by the encoder with this
+// Name, it is ingested to the encoder's own targets. File is nil until it has
+// been transcoded, and again once it has been ingested.
+type Variant struct {
+	Name    string
+	File    *MkvFile        `json:",omitempty"`
+	Targets []*TargetStatus `json:",omitempty"`
+}
+
+// Variant returns the variant made by the named encoder, creating it if the
+// file hasn't been transcoded by it yet.
+func (w *Workflow) Variant(name string) *Variant {
+	for _, v := range w.Variants {
+		if v.Name == name {
+			return v
+		}
+	}
+	v := &Variant{Name: name}
+	w.Variants = append(w.Variants, v)
+	return v
+}
+
+func (v *Variant) TargetStatus(target string) *TargetStatus {
+	return targetStatus(&v.Targets, target)
+}
+
+func targetStatus(targets *[]*TargetStatus, target string) *TargetStatus {
+	for _, ts := range *targets {
+		if ts.Target == target {
+			return ts
+		}
+	}
+	ts := &TargetStatus{Target: target, Status: IngestPending}
+	*targets = append(*targets, ts)
+	return ts
+}
 
-	MkvStatus *makemkv.Status `json:"-"`
+// Media returns the media details of the workflow, ok is false if the name or
+// year has not been set yet.
+func (w *Workflow) Media() (media Media, ok bool) {
+	if w.Name == nil || w.Year == nil {
+		return media, false
+	}
+	media = Media{
+		Name:    *w.Name,
+		Year:    *w.Year,
+		Season:  w.Season,
+		Episode: w.Episode,
+	}
+	if w.ImdbId != nil {
+		media.ImdbId = *w.ImdbId
+	}
+	if w.TmdbId != nil {
+		media.TmdbId = *w.TmdbId
+	}
+	if w.Edition != nil {
+		media.Edition = *w.Edition
+	}
+	return media, true
 }
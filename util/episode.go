@@ -0,0 +1,121 @@
+package util
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/metadata"
+)
+
+const minEpisodeLength = 15 * time.Minute
+const maxEpisodeLength = 75 * time.Minute
+
+var seasonRegexp = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:season|series|s)[ _.-]?(\d{1,2})(?:[^0-9]|$)`)
+var discRegexp = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:disc|disk|d)[ _.-]?(\d{1,2})(?:[^0-9]|$)`)
+
+// GuessEpisodes returns the titles that look like episodes of a tv series, in
+// disc order, or nil if the disc looks like a movie. Episodes are titles of a
+// similar, episode-like length. A disc with a longer title that isn't a "play
+// all" of the episodes is treated as a movie.
+func GuessEpisodes(info *makemkv.DiscInfo) []*makemkv.TitleInfo {
+	if info == nil {
+		return nil
+	}
+
+	candidates := make([]*makemkv.TitleInfo, 0)
+	for i := range info.Titles {
+		t := &info.Titles[i]
+		if t.Duration >= minEpisodeLength && t.Duration <= maxEpisodeLength {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) < 2 {
+		return nil
+	}
+
+	durations := make([]time.Duration, len(candidates))
+	for i, t := range candidates {
+		durations[i] = t.Duration
+	}
+	slices.Sort(durations)
+	median := durations[len(durations)/2]
+
+	episodes := make([]*makemkv.TitleInfo, 0, len(candidates))
+	var total time.Duration
+	for _, t := range candidates {
+		if t.Duration < median*3/4 || t.Duration > median*5/4 {
+			continue
+		}
+		// the same episode is sometimes reachable from more than one playlist
+		if len(t.Segments) > 0 && slices.ContainsFunc(episodes, func(e *makemkv.TitleInfo) bool {
+			return slices.Equal(e.Segments, t.Segments)
+		}) {
+			continue
+		}
+		episodes = append(episodes, t)
+		total += t.Duration
+	}
+	if len(episodes) < 2 {
+		return nil
+	}
+
+	for _, t := range info.Titles {
+		if t.Duration <= maxEpisodeLength {
+			continue
+		}
+		isPlayAll := t.Duration > total*9/10 && t.Duration < total*11/10
+		if !isPlayAll {
+			return nil
+		}
+	}
+
+	slices.SortFunc(episodes, func(a, b *makemkv.TitleInfo) int {
+		return a.Id - b.Id
+	})
+	return episodes
+}
+
+// GuessSeason returns the season number found in any of the names, such as
+// "FRIENDS_S1_D2" or "Friends: Season 1", or 0 if there isn't one.
+func GuessSeason(names ...string) int {
+	return findNumber(seasonRegexp, names)
+}
+
+// GuessDiscNumber returns the disc number found in any of the names, such as
+// "FRIENDS_S1_D2" or "Friends: Season 1 Disc 2", or 0 if there isn't one.
+func GuessDiscNumber(names ...string) int {
+	return findNumber(discRegexp, names)
+}
+
+func findNumber(re *regexp.Regexp, names []string) int {
+	for _, name := range names {
+		if m := re.FindStringSubmatch(name); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			return n
+		}
+	}
+	return 0
+}
+
+// SeriesName strips the season and disc parts off of a disc name, so it can
+// be used to look up the series.
+func SeriesName(name string) string {
+	name = strings.ReplaceAll(name, "_", " ")
+	for _, re := range []*regexp.Regexp{seasonRegexp, discRegexp} {
+		if loc := re.FindStringIndex(name); loc != nil && loc[0] > 0 {
+			name = name[0:loc[0]]
+		}
+	}
+	return strings.TrimRight(strings.TrimSpace(name), ":-")
+}
+
+func GetSeries(name string, provider metadata.Provider) (*metadata.Title, error) {
+	getSeriesByTitle := func(name string) (*metadata.Title, error) {
+		return provider.FindTitle(name, metadata.Series)
+	}
+	return getMovie(SeriesName(name), getSeriesByTitle)
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+)
+
+func titleIds(titles []*makemkv.TitleInfo) []int {
+	ids := make([]int, len(titles))
+	for i, t := range titles {
+		ids[i] = t.Id
+	}
+	return ids
+}
+
+func TestGuessEpisodes(t *testing.T) {
+	info := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 88 * time.Minute},
+		{Id: 1, Duration: 22 * time.Minute},
+		{Id: 2, Duration: 21 * time.Minute},
+		{Id: 3, Duration: 23 * time.Minute},
+		{Id: 4, Duration: 22 * time.Minute},
+		{Id: 5, Duration: 5 * time.Minute},
+	}}
+	result := titleIds(GuessEpisodes(info))
+	expected := []int{1, 2, 3, 4}
+	if len(result) != len(expected) {
+		t.Fatalf("GuessEpisodes(info) = %v, expected %v", result, expected)
+	}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Fatalf("GuessEpisodes(info) = %v, expected %v", result, expected)
+		}
+	}
+}
+
+func TestGuessEpisodesMovie(t *testing.T) {
+	data := readTestData(t)
+	for name, info := range data {
+		if result := GuessEpisodes(info); result != nil {
+			t.Fatalf("GuessEpisodes(%s) = %v, expected nil", name, titleIds(result))
+		}
+	}
+
+	// a feature with a couple of featurettes isn't a series
+	info := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 121 * time.Minute},
+		{Id: 1, Duration: 20 * time.Minute},
+		{Id: 2, Duration: 21 * time.Minute},
+	}}
+	if result := GuessEpisodes(info); result != nil {
+		t.Fatalf("GuessEpisodes(info) = %v, expected nil", titleIds(result))
+	}
+}
+
+func TestGuessSeasonAndDisc(t *testing.T) {
+	tests := []struct {
+		name   string
+		season int
+		disc   int
+		series string
+	}{
+		{"FRIENDS_S1_D2", 1, 2, "FRIENDS"},
+		{"Friends: Season 10 Disc 3", 10, 3, "Friends"},
+		{"THE_WIRE_SEASON_4_DISC_1", 4, 1, "THE WIRE"},
+		{"Deadwood", 0, 0, "Deadwood"},
+	}
+	for _, test := range tests {
+		if season := GuessSeason(test.name); season != test.season {
+			t.Errorf("GuessSeason(%q) = %d, expected %d", test.name, season, test.season)
+		}
+		if disc := GuessDiscNumber(test.name); disc != test.disc {
+			t.Errorf("GuessDiscNumber(%q) = %d, expected %d", test.name, disc, test.disc)
+		}
+		if series := SeriesName(test.name); series != test.series {
+			t.Errorf("SeriesName(%q) = %q, expected %q", test.name, series, test.series)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package util
+
+import (
+	"cmp"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aravance/mkv-ripper/metadata"
+)
+
+const titleWeight = 0.5
+const runtimeWeight = 0.35
+const yearWeight = 0.15
+
+// runtimes further apart than this don't count toward a match
+const maxRuntimeDiff = 20 * time.Minute
+
+var yearRegexp = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{2})(?:[^0-9]|$)`)
+var nonAlnumRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Match is a movie and how confident we are that it is the one on the disc,
+// from 0 to 1.
+type Match struct {
+	Title      *metadata.Title
+	Confidence float64
+}
+
+// MatchMovie searches for name and picks the candidate that best matches the
+// disc title. Candidates are scored by how similar their title is to name, how
+// close their runtime is to duration, and by a year found in name or hints,
+// such as the disc label.
+func MatchMovie(provider metadata.Provider, name string, duration time.Duration, hints ...string) (*Match, error) {
+	name = strings.TrimSpace(strings.ReplaceAll(name, "_", " "))
+	queries := []string{name}
+	if stripped := stripName(name); stripped != name {
+		queries = append(queries, stripped)
+	}
+
+	candidates := make([]*metadata.Title, 0)
+	var err error
+	for _, q := range queries {
+		var titles []*metadata.Title
+		if titles, err = provider.Search(q, metadata.Movie, 5); err != nil {
+			continue
+		}
+		for _, t := range titles {
+			if !slices.ContainsFunc(candidates, func(c *metadata.Title) bool { return c.Ids == t.Ids }) {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		var t *metadata.Title
+		if t, err = GetMovie(name, provider); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, t)
+	}
+
+	year := GuessYear(append([]string{name}, hints...)...)
+	matches := make([]Match, len(candidates))
+	for i, t := range candidates {
+		matches[i] = Match{t, scoreMovie(t, queries, year, duration)}
+	}
+	best := slices.MaxFunc(matches, func(a, b Match) int {
+		return cmp.Compare(a.Confidence, b.Confidence)
+	})
+	best.Confidence = roundConfidence(best.Confidence)
+	return &best, nil
+}
+
+// GuessYear returns the first year found in any of the names, or "" if there
+// isn't one.
+func GuessYear(names ...string) string {
+	for _, name := range names {
+		if m := yearRegexp.FindStringSubmatch(name); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+func stripName(name string) string {
+	if index := strings.IndexAny(name, "[{(:"); index > 0 {
+		return strings.TrimSpace(name[0:index])
+	}
+	return name
+}
+
+func scoreMovie(t *metadata.Title, names []string, year string, duration time.Duration) float64 {
+	similarity := 0.0
+	for _, name := range names {
+		similarity = max(similarity, titleSimilarity(name, t.Title))
+	}
+	score := similarity * titleWeight
+	total := titleWeight
+
+	if duration > 0 && t.Runtime > 0 {
+		diff := (duration - time.Duration(t.Runtime)*time.Minute).Abs()
+		score += max(0, 1-float64(diff)/float64(maxRuntimeDiff)) * runtimeWeight
+		total += runtimeWeight
+	}
+
+	// a year can be part of the title, like Blade Runner 2049
+	if year != "" && len(t.Year) >= 4 && !strings.Contains(t.Title, year) {
+		want, _ := strconv.Atoi(year)
+		got, _ := strconv.Atoi(t.Year[0:4])
+		switch diff := want - got; {
+		case diff == 0:
+			score += yearWeight
+		case diff == 1 || diff == -1:
+			score += yearWeight / 2
+		}
+		total += yearWeight
+	}
+	return score / total
+}
+
+// titleSimilarity is 1 for titles that only differ in case and punctuation,
+// falling toward 0 as they differ more.
+func titleSimilarity(a string, b string) float64 {
+	a, b = normalizeTitle(a), normalizeTitle(b)
+	if a == "" || b == "" {
+		return 0
+	}
+	longest := max(len([]rune(a)), len([]rune(b)))
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+func normalizeTitle(s string) string {
+	s = strings.ToLower(s)
+	s = strings.ReplaceAll(s, "&", " and ")
+	s = strings.TrimSpace(nonAlnumRegexp.ReplaceAllString(s, " "))
+	return strings.TrimPrefix(s, "the ")
+}
+
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// roundConfidence keeps stored confidences readable
+func roundConfidence(c float64) float64 {
+	return math.Round(c*100) / 100
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
+)
+
+// searchProvider returns the same results for every search
+type searchProvider struct {
+	results []*metadata.Title
+}
+
+func (p searchProvider) Search(query string, kind metadata.Kind, limit int) ([]*metadata.Title, error) {
+	return p.results, nil
+}
+func (p searchProvider) Get(ids metadata.Ids, kind metadata.Kind) (*metadata.Title, error) {
+	return nil, metadata.ErrNotFound
+}
+func (p searchProvider) FindTitle(name string, kind metadata.Kind) (*metadata.Title, error) {
+	if len(p.results) == 0 {
+		return nil, metadata.ErrNotFound
+	}
+	return p.results[0], nil
+}
+func (p searchProvider) Season(ids metadata.Ids, season int) ([]metadata.Episode, error) {
+	return nil, metadata.ErrNotFound
+}
+
+func movie(id string, title string, year string, runtime int) *metadata.Title {
+	return &metadata.Title{Ids: metadata.Ids{Imdb: id}, Kind: metadata.Movie, Title: title, Year: year, Runtime: runtime}
+}
+
+var dunes = searchProvider{[]*metadata.Title{
+	movie("tt0087182", "Dune", "1984", 137),
+	movie("tt1160419", "Dune", "2021", 155),
+	movie("tt15239678", "Dune: Part Two", "2024", 166),
+}}
+
+func TestMatchMovieByRuntime(t *testing.T) {
+	match, err := MatchMovie(dunes, "DUNE", 2*time.Hour+35*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Title.Year != "2021" {
+		t.Fatalf("MatchMovie(DUNE, 2h35m) = %s (%s), expected 2021", match.Title.Title, match.Title.Year)
+	}
+	if match.Confidence < model.ReviewConfidence {
+		t.Fatalf("expected a confident match, got %v", match.Confidence)
+	}
+}
+
+func TestMatchMovieByYear(t *testing.T) {
+	// the runtime is closer to the 2021 movie, but the label names the year
+	match, err := MatchMovie(dunes, "Dune", 2*time.Hour+25*time.Minute, "DUNE_1984")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Title.Year != "1984" {
+		t.Fatalf("MatchMovie(Dune, DUNE_1984) = %s (%s), expected 1984", match.Title.Title, match.Title.Year)
+	}
+}
+
+func TestMatchMovieByTitle(t *testing.T) {
+	match, err := MatchMovie(dunes, "Dune Part Two", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Title.Year != "2024" || match.Confidence != 1 {
+		t.Fatalf("MatchMovie(Dune Part Two) = %s (%s) %v", match.Title.Title, match.Title.Year, match.Confidence)
+	}
+}
+
+func TestMatchMovieLowConfidence(t *testing.T) {
+	match, err := MatchMovie(dunes, "LOGICAL_VOLUME_ID", 95*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Confidence >= model.ReviewConfidence {
+		t.Fatalf("expected a low confidence match, got %s %v", match.Title.Title, match.Confidence)
+	}
+
+	if _, err := MatchMovie(searchProvider{}, "nothing", 0); err == nil {
+		t.Fatal("expected an error without candidates")
+	}
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"The Lord of the Rings", "lord of the rings", 1},
+		{"Fast & Furious", "Fast and Furious", 1},
+		{"Toy Story 3", "Toy Story 4", 1 - 1.0/11},
+		{"abc", "xyz", 0},
+	}
+	for _, tt := range tests {
+		if got := titleSimilarity(tt.a, tt.b); got != tt.expected {
+			t.Errorf("titleSimilarity(%q, %q) = %v, expected %v", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestGuessYear(t *testing.T) {
+	if y := GuessYear("DUNE", "DUNE_1984"); y != "1984" {
+		t.Fatalf("GuessYear = %q, expected 1984", y)
+	}
+	if y := GuessYear("BLADE_RUNNER_2049"); y != "2049" {
+		t.Fatalf("GuessYear = %q, expected 2049", y)
+	}
+	if y := GuessYear("12019"); y != "" {
+		t.Fatalf("GuessYear = %q, expected none", y)
+	}
+}
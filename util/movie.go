@@ -2,26 +2,25 @@ package util
 
 import (
 	"log"
-	"strings"
 
 	"github.com/aravance/go-makemkv"
-	"github.com/eefret/gomdb"
+	"github.com/aravance/mkv-ripper/metadata"
+	"github.com/aravance/mkv-ripper/model"
 )
 
-func GetMovie(name string, api *gomdb.OmdbApi) (movie *gomdb.MovieResult, err error) {
-	getMovieByTitle := func(name string) (*gomdb.MovieResult, error) {
-		return api.MovieByTitle(&gomdb.QueryData{Title: name})
+func GetMovie(name string, provider metadata.Provider) (movie *metadata.Title, err error) {
+	getMovieByTitle := func(name string) (*metadata.Title, error) {
+		return provider.FindTitle(name, metadata.Movie)
 	}
 	return getMovie(name, getMovieByTitle)
 }
 
-func getMovie(name string, getMovieByTitle func(string) (*gomdb.MovieResult, error)) (movie *gomdb.MovieResult, err error) {
+func getMovie(name string, getMovieByTitle func(string) (*metadata.Title, error)) (movie *metadata.Title, err error) {
 	movie, err = getMovieByTitle(name)
 	if err != nil {
 		log.Println("error fetching movie:", name, "err:", err)
-		index := strings.IndexAny(name, "[{(:")
-		if index > 0 {
-			name = strings.TrimSpace(name[0:index])
+		if stripped := stripName(name); stripped != name {
+			name = stripped
 			movie, err = getMovieByTitle(name)
 			if err != nil {
 				log.Println("error fetching movie with stripped name:", name, "err:", err)
@@ -31,25 +30,33 @@ func getMovie(name string, getMovieByTitle func(string) (*gomdb.MovieResult, err
 	return movie, err
 }
 
-func guessIsMainTitle(title makemkv.TitleInfo) bool {
-	return strings.Contains(title.FileName, "MainFeature") ||
-		// disney usually uses 00800.mpls
-		title.SourceFileName == "00800.mpls" ||
-		// studio ghibli usually uses 00200.mpls
-		title.SourceFileName == "00200.mpls"
+// SetTitle saves the name, year and ids of a movie or series on the workflow.
+func SetTitle(wf *model.Workflow, t *metadata.Title) {
+	name, year := t.Title, t.Year
+	wf.Name = &name
+	wf.Year = &year
+	wf.ImdbId = nil
+	wf.TmdbId = nil
+	if t.Ids.Imdb != "" {
+		imdbId := t.Ids.Imdb
+		wf.ImdbId = &imdbId
+	}
+	if t.Ids.Tmdb != "" {
+		tmdbId := t.Ids.Tmdb
+		wf.TmdbId = &tmdbId
+	}
 }
 
-func GuessMainTitle(info *makemkv.DiscInfo) *makemkv.TitleInfo {
-	if info == nil || len(info.Titles) == 0 {
-		return nil
+// TitleIds returns the ids of the movie or series set on the workflow.
+func TitleIds(wf *model.Workflow) metadata.Ids {
+	var ids metadata.Ids
+	if wf.ImdbId != nil {
+		ids.Imdb = *wf.ImdbId
 	}
-
-	for _, t := range info.Titles {
-		if guessIsMainTitle(t) {
-			return &t
-		}
+	if wf.TmdbId != nil {
+		ids.Tmdb = *wf.TmdbId
 	}
-	return &info.Titles[0]
+	return ids
 }
 
 func GuessName(disc *makemkv.DiscInfo, title *makemkv.TitleInfo) string {
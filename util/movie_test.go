@@ -4,18 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aravance/go-makemkv"
-	"github.com/eefret/gomdb"
+	"github.com/aravance/mkv-ripper/metadata"
 	"github.com/google/go-cmp/cmp"
 )
 
 func TestGetMovie(t *testing.T) {
-	getMovieTest := func(name string) (*gomdb.MovieResult, error) {
+	getMovieTest := func(name string) (*metadata.Title, error) {
 		if strings.ToLower(name) == "toy story 3" {
-			return &gomdb.MovieResult{Title: "Toy Story 3"}, nil
+			return &metadata.Title{Title: "Toy Story 3"}, nil
 		} else {
 			return nil, fmt.Errorf("no result found")
 		}
@@ -26,7 +28,7 @@ func TestGetMovie(t *testing.T) {
 		t.Fatalf(`getMovie("Toy Story 3  (Disc 1)", getMovieTest) error : %v`, err)
 	}
 
-	expected := &gomdb.MovieResult{Title: "Toy Story 3"}
+	expected := &metadata.Title{Title: "Toy Story 3"}
 	if !cmp.Equal(result, expected) {
 		t.Fatalf(`getMovie("Toy Story 3  (Disc 1)", getMovieTest) = %v, expected %v`, result, expected)
 	}
@@ -59,6 +61,102 @@ func TestGetMainTitleLaLaLand(t *testing.T) {
 	}
 }
 
+func TestGetMainTitleJohnWick4(t *testing.T) {
+	data := readTestData(t)
+	johnWick4 := data["johnWick4"]
+	result := GuessMainTitle(johnWick4)
+	if result.Id != 3 {
+		t.Fatalf(`GuessMainTitle(johnWick4) = %v, expected %v`, result.Id, 3)
+	}
+}
+
+func TestGetMainTitlePrincessBride(t *testing.T) {
+	data := readTestData(t)
+	princessBride := data["princessBride"]
+	result := GuessMainTitle(princessBride)
+	if result.Id != 0 {
+		t.Fatalf(`GuessMainTitle(princessBride) = %v, expected %v`, result.Id, 0)
+	}
+}
+
+func TestGetMainTitleSpiritedAway(t *testing.T) {
+	data := readTestData(t)
+	spiritedAway := data["spiritedAway"]
+	result := GuessMainTitle(spiritedAway)
+	if result.Id != 1 {
+		t.Fatalf(`GuessMainTitle(spiritedAway) = %v, expected %v`, result.Id, 1)
+	}
+}
+
+func TestRankTitlesDecoys(t *testing.T) {
+	data := readTestData(t)
+	ranked := RankTitles(data["johnWick4"])
+	if len(ranked) != 8 {
+		t.Fatalf(`len(RankTitles(johnWick4)) = %v, expected %v`, len(ranked), 8)
+	}
+
+	hasReason := func(s TitleScore, reason string) bool {
+		return slices.ContainsFunc(s.Reasons, func(r string) bool {
+			return strings.Contains(r, reason)
+		})
+	}
+	if !hasReason(ranked[0], "in order") {
+		t.Errorf(`RankTitles(johnWick4)[0].Reasons = %v, expected it to share segments in order`, ranked[0].Reasons)
+	}
+	for _, s := range ranked[1:6] {
+		if !hasReason(s, "out of order") {
+			t.Errorf(`RankTitles(johnWick4) title %v reasons = %v, expected a decoy`, s.Title.Id, s.Reasons)
+		}
+	}
+	for i := 1; i < len(ranked); i++ {
+		if ranked[i].Score > ranked[i-1].Score {
+			t.Fatalf(`RankTitles(johnWick4) is not sorted: %v > %v`, ranked[i].Score, ranked[i-1].Score)
+		}
+	}
+}
+
+func TestRankTitlesSegmentsOutOfOrder(t *testing.T) {
+	streams := func(n int) []makemkv.AudioStreamInfo { return make([]makemkv.AudioStreamInfo, n) }
+	// the feature and its theatrical cut are both numbered out of order, and a
+	// long bonus feature has segments of its own
+	info := &makemkv.DiscInfo{Titles: []makemkv.TitleInfo{
+		{Id: 0, Duration: 2 * time.Hour, FileSize: 30_000_000_000, ChapterCount: 24, AudioStreams: streams(3), Segments: []int{12, 10, 11}},
+		{Id: 1, Duration: 100 * time.Minute, FileSize: 25_000_000_000, ChapterCount: 20, AudioStreams: streams(3), Segments: []int{12, 10}},
+		{Id: 2, Duration: 110 * time.Minute, FileSize: 28_000_000_000, ChapterCount: 24, AudioStreams: streams(3), Segments: []int{20}},
+	}}
+
+	ranked := RankTitles(info)
+	if ranked[0].Title.Id != 0 {
+		t.Fatalf(`RankTitles(outOfOrder)[0] = %v, expected %v`, ranked[0].Title.Id, 0)
+	}
+	for _, s := range ranked {
+		if slices.ContainsFunc(s.Reasons, func(r string) bool { return strings.HasSuffix(r, ", out of order") }) {
+			t.Errorf(`RankTitles(outOfOrder) title %v reasons = %v, expected no decoy`, s.Title.Id, s.Reasons)
+		}
+	}
+}
+
+func TestRankTitlesDuplicate(t *testing.T) {
+	data := readTestData(t)
+	ranked := RankTitles(data["spiritedAway"])
+	last := ranked[len(ranked)-1]
+	if last.Title.Id != 2 {
+		t.Fatalf(`RankTitles(spiritedAway) last = %v, expected %v`, last.Title.Id, 2)
+	}
+	if !slices.Contains(last.Reasons, "same segments as title 2") {
+		t.Fatalf(`RankTitles(spiritedAway) last reasons = %v, expected a duplicate`, last.Reasons)
+	}
+}
+
+func TestRankTitlesEmpty(t *testing.T) {
+	if ranked := RankTitles(nil); ranked != nil {
+		t.Fatalf(`RankTitles(nil) = %v, expected nil`, ranked)
+	}
+	if result := GuessMainTitle(&makemkv.DiscInfo{}); result != nil {
+		t.Fatalf(`GuessMainTitle(empty) = %v, expected nil`, result)
+	}
+}
+
 func readTestData(t *testing.T) map[string]*makemkv.DiscInfo {
 	b, err := os.ReadFile("movie_testdata")
 	if err != nil {
@@ -1,10 +1,14 @@
 package util
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
 )
 
 func Sha256sum(file string) (string, error) {
@@ -18,3 +22,53 @@ func Sha256sum(file string) (string, error) {
 	io.Copy(h, f)
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
+
+// Sha256sumDir checksums every file in dir, and returns the sha256 of the
+// listing. It matches the output of:
+//
+//	find . -type f -print0 | LC_ALL=C sort -z | xargs -0 sha256sum | sha256sum
+func Sha256sumDir(dir string) (string, error) {
+	sums, err := Sha256sums(dir)
+	if err != nil {
+		return "", err
+	}
+	return Sha256sumListing(sums), nil
+}
+
+// Sha256sumListing returns the sha256 of the sha256sum listing of sums, sorted
+// by file name, see Sha256sumDir.
+func Sha256sumListing(sums map[string]string) string {
+	files := make([]string, 0, len(sums))
+	for f := range sums {
+		files = append(files, f)
+	}
+	slices.Sort(files)
+
+	var b bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s  %s\n", sums[f], f)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b.Bytes()))
+}
+
+// Sha256sums returns the sha256 of every file in dir, by their path relative
+// to it starting with "./".
+func Sha256sums(dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sum, err := Sha256sum(p)
+		if err != nil {
+			return err
+		}
+		sums["./"+filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	return sums, err
+}
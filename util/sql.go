@@ -0,0 +1,28 @@
+package util
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// HasColumn reports whether a table has a column, false if there's no such
+// table.
+func HasColumn(db *sql.DB, table string, column string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	return count > 0, err
+}
+
+// AddColumn adds a column to a table created by an older version, if it
+// isn't there already.
+func AddColumn(db *sql.DB, table string, column string) error {
+	name, _, _ := strings.Cut(column, " ")
+	ok, err := HasColumn(db, table, name)
+	if err != nil || ok {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+	return err
+}
@@ -0,0 +1,206 @@
+package util
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aravance/go-makemkv"
+)
+
+// how much each property of a title counts towards its score, relative to the
+// title with the most of it on the disc
+const durationWeight = 40.0
+const sizeWeight = 15.0
+const chapterWeight = 10.0
+const audioWeight = 10.0
+const subtitleWeight = 5.0
+
+const mainFeatureBonus = 30.0
+const playlistBonus = 20.0
+const extrasPenalty = 20.0
+const decoyPenalty = 25.0
+const duplicatePenalty = 5.0
+
+// TitleScore is how likely a title is to be the main feature of its disc, and
+// why.
+type TitleScore struct {
+	Title   *makemkv.TitleInfo
+	Score   float64
+	Reasons []string
+}
+
+// RankTitles scores every title on the disc, best guess at the main feature
+// first. Titles with equal scores keep their disc order.
+func RankTitles(info *makemkv.DiscInfo) []TitleScore {
+	if info == nil || len(info.Titles) == 0 {
+		return nil
+	}
+
+	var maxDuration, maxSize int64
+	var maxChapters, maxAudio, maxSubtitles int
+	for _, t := range info.Titles {
+		maxDuration = max(maxDuration, int64(t.Duration))
+		maxSize = max(maxSize, int64(t.FileSize))
+		maxChapters = max(maxChapters, t.ChapterCount)
+		maxAudio = max(maxAudio, len(t.AudioStreams))
+		maxSubtitles = max(maxSubtitles, len(t.SubtitleStreams))
+	}
+
+	scores := make([]TitleScore, len(info.Titles))
+	for i := range info.Titles {
+		t := &info.Titles[i]
+		s := TitleScore{Title: t}
+		add := func(points float64, reason string) {
+			s.Score += points
+			s.Reasons = append(s.Reasons, reason)
+		}
+
+		s.Score += durationWeight * ratio(int64(t.Duration), maxDuration)
+		if int64(t.Duration) == maxDuration {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("longest title, %v", t.Duration))
+		} else {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("%v, %.0f%% of the longest title", t.Duration, 100*ratio(int64(t.Duration), maxDuration)))
+		}
+		s.Score += sizeWeight * ratio(int64(t.FileSize), maxSize)
+		if int64(t.FileSize) == maxSize {
+			s.Reasons = append(s.Reasons, "largest file")
+		}
+		s.Score += chapterWeight * ratio(int64(t.ChapterCount), int64(maxChapters))
+		if t.ChapterCount == maxChapters && maxChapters > 1 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("most chapters, %d", t.ChapterCount))
+		}
+		s.Score += audioWeight * ratio(int64(len(t.AudioStreams)), int64(maxAudio))
+		if len(t.AudioStreams) == maxAudio && maxAudio > 1 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("most audio streams, %d", maxAudio))
+		}
+		s.Score += subtitleWeight * ratio(int64(len(t.SubtitleStreams)), int64(maxSubtitles))
+		if len(t.SubtitleStreams) == maxSubtitles && maxSubtitles > 1 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("most subtitle streams, %d", maxSubtitles))
+		}
+
+		if strings.Contains(t.FileName, "MainFeature") {
+			add(mainFeatureBonus, "makemkv named it the main feature")
+		}
+		switch t.SourceFileName {
+		case "00800.mpls":
+			add(playlistBonus, "00800.mpls is usually the main feature on disney discs")
+		case "00200.mpls":
+			add(playlistBonus, "00200.mpls is usually the main feature on studio ghibli discs")
+		}
+		if isExtras(t) {
+			add(-extrasPenalty, "named like a disclaimer or play all playlist")
+		}
+
+		scoreSegments(&s, info.Titles, add)
+		scores[i] = s
+	}
+
+	slices.SortStableFunc(scores, func(a, b TitleScore) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	return scores
+}
+
+// scoreSegments looks for decoy playlists. Some discs have many playlists of
+// the same length that play the main feature's segments, only one of them in
+// the right order. A playlist that shares most of its segments with others
+// but plays them out of order, while one of the others plays them in order, is
+// most likely a decoy. Without one in order, the segments are just numbered
+// out of order on the disc.
+func scoreSegments(s *TitleScore, titles []makemkv.TitleInfo, add func(float64, string)) {
+	t := s.Title
+	if len(t.Segments) == 0 {
+		var same []int
+		for _, o := range titles {
+			if o.Id != t.Id && o.Duration == t.Duration && o.FileSize == t.FileSize {
+				same = append(same, o.Id)
+			}
+		}
+		if len(same) > 0 {
+			s.Reasons = append(s.Reasons, "same length and size as "+titleList(same))
+		}
+		return
+	}
+
+	var shared []int
+	inOrder := false
+	for _, o := range titles {
+		if o.Id == t.Id || len(o.Segments) == 0 {
+			continue
+		}
+		if slices.Equal(o.Segments, t.Segments) {
+			if o.Id < t.Id {
+				add(-duplicatePenalty, fmt.Sprintf("same segments as title %d", o.Id+1))
+				return
+			}
+			continue
+		}
+		if sharedSegments(t.Segments, o.Segments)*2 >= len(t.Segments) {
+			shared = append(shared, o.Id)
+			inOrder = inOrder || slices.IsSorted(o.Segments)
+		}
+	}
+	if len(shared) == 0 {
+		return
+	}
+	reason := "shares segments with " + titleList(shared)
+	switch {
+	case slices.IsSorted(t.Segments):
+		s.Reasons = append(s.Reasons, reason+", in order")
+	case inOrder:
+		add(-decoyPenalty, reason+", out of order")
+	default:
+		s.Reasons = append(s.Reasons, reason+", none of them in order")
+	}
+}
+
+func sharedSegments(a []int, b []int) int {
+	n := 0
+	for _, seg := range a {
+		if slices.Contains(b, seg) {
+			n++
+		}
+	}
+	return n
+}
+
+// titleList formats title ids the way they are shown, counting from 1
+func titleList(ids []int) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = strconv.Itoa(id + 1)
+	}
+	if len(ids) == 1 {
+		return "title " + names[0]
+	}
+	return "titles " + strings.Join(names, ", ")
+}
+
+func isExtras(t *makemkv.TitleInfo) bool {
+	name := strings.ToLower(t.FileName)
+	return strings.Contains(name, "disclaimer") || strings.Contains(name, "playall")
+}
+
+func ratio(n int64, max int64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return float64(n) / float64(max)
+}
+
+// GuessMainTitle returns the best ranked title of the disc, see RankTitles.
+func GuessMainTitle(info *makemkv.DiscInfo) *makemkv.TitleInfo {
+	ranked := RankTitles(info)
+	if len(ranked) == 0 {
+		return nil
+	}
+	return ranked[0].Title
+}
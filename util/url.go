@@ -10,3 +10,9 @@ func WorkflowUrl(discid string, titleid int, parts ...string) string {
 	u, _ := url.JoinPath(base, parts...)
 	return u
 }
+
+func DriveUrl(driveid string, parts ...string) string {
+	base := fmt.Sprintf("/drive/%s", driveid)
+	u, _ := url.JoinPath(base, parts...)
+	return u
+}
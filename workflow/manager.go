@@ -1,47 +1,84 @@
 package workflow
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
+	"os/exec"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/aravance/go-makemkv"
 	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/event"
 	"github.com/aravance/mkv-ripper/ingest"
 	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
 )
 
+// Backups is where full disc backups are ingested to, they never go to the
+// targets of ripped titles. With Iso set, backups are packed into an iso
+// before they are checksummed.
+type Backups struct {
+	Targets []ingest.Target
+	Iso     bool
+}
+
 type WorkflowManager interface {
-	Start(*model.Workflow) error
+	Start(drive.Drive, *model.Workflow) error
+	Enqueue(drive.Drive, *model.Workflow) error
+	ResumeQueue(drive.Drive)
 	Ingest(*model.Workflow) error
+	Cancel(*model.Workflow) error
 	NewWorkflow(discId string, titleId int, label string, name string) (*model.Workflow, bool)
 	GetWorkflow(discId string, titleId int) *model.Workflow
 	GetWorkflows(discId string) []*model.Workflow
 	GetAllWorkflows() []*model.Workflow
 	Save(*model.Workflow) error
 	Clean(*model.Workflow) error
+	Progress(*model.Workflow) *makemkv.Status
+	// Profiles are the rip profiles workflows can pick with their Profile
+	Profiles() drive.Profiles
+}
+
+func (m *workflowManager) Start(d drive.Drive, wf *model.Workflow) error {
+	return m.start(d, wf, false)
 }
 
-func (m *workflowManager) Start(wf *model.Workflow) error {
-	if wf.Status == model.StatusImporting || wf.Status == model.StatusRipping {
+// start rips wf, for a queued workflow only if it's still queued once it
+// starts, so it isn't ripped after it was cancelled.
+func (m *workflowManager) start(d drive.Drive, wf *model.Workflow, queued bool) error {
+	if wf.Status == model.StatusImporting || wf.Status == model.StatusRipping || wf.Status == model.StatusTranscoding {
 		return fmt.Errorf("workflow is already running: %s", wf.Status)
 	}
-	disc := m.driveman.GetDisc()
+	disc := d.GetDisc()
 	if disc == nil {
 		return fmt.Errorf("disc cannot be nil")
 	}
-	di, ok := m.discdb.GetDiscInfo(disc.Uuid)
-	if !ok || di == nil {
-		return fmt.Errorf("info cannot be nil")
+	if disc.Id != wf.DiscId {
+		return fmt.Errorf("disc %s is not in drive %s", wf.DiscId, d.Id())
 	}
-	if wf.TitleId >= len(di.Titles) {
-		return fmt.Errorf("title cannot be nil")
+	var ti *makemkv.TitleInfo
+	if !wf.IsBackup() {
+		di, ok := m.discdb.GetDiscInfo(disc.Id)
+		if !ok || di == nil {
+			return fmt.Errorf("info cannot be nil")
+		}
+		if wf.TitleId < 0 || wf.TitleId >= len(di.Titles) {
+			return fmt.Errorf("title cannot be nil")
+		}
+		ti = &di.Titles[wf.TitleId]
 	}
-	ti := &di.Titles[wf.TitleId]
+
+	ctx, err := m.startJob(wf, queued)
+	if err != nil {
+		return err
+	}
+	defer m.endJob(wf, ctx)
 
 	wf.Status = model.StatusRipping
 	m.Save(wf)
@@ -55,34 +92,109 @@ func (m *workflowManager) Start(wf *model.Workflow) error {
 	}
 
 	statchan := make(chan makemkv.Status)
-	defer close(statchan)
-
+	statdone := make(chan struct{})
 	go func() {
+		defer close(statdone)
 		for stat := range statchan {
-			disc.MkvStatus = &stat
-			wf.MkvStatus = &stat
+			m.setProgress(wf, &stat)
 		}
 	}()
+	defer func() {
+		close(statchan)
+		<-statdone
+		m.setProgress(wf, nil)
+	}()
 
-	f, err := m.driveman.RipFile(ti, dir, statchan)
+	profile := m.profiles.Get(wf.Profile)
+	var f *model.MkvFile
+	if wf.IsBackup() {
+		f, err = m.backup(ctx, d, disc, dir, profile, statchan)
+	} else {
+		f, err = d.RipFile(ctx, ti, dir, profile, statchan)
+	}
 	if err != nil {
 		log.Println("error ripping:", wf, "err:", err)
-		wf.Status = model.StatusError
+		if ctx.Err() != nil {
+			wf.Status = model.StatusCancelled
+		} else {
+			wf.Status = model.StatusError
+		}
 		m.Save(wf)
 		return err
 	}
 
 	wf.File = f
+	wf.Targets = nil
+	wf.Variants = nil
 	wf.Status = model.StatusPending
 	m.Save(wf)
 
-	go m.Ingest(wf)
+	if (m.autoEject || drive.AutoEject(d)) && !m.hasQueued(d) {
+		if err := d.Eject(); err != nil {
+			log.Println("error ejecting drive:", d.Id(), "err:", err)
+		}
+	}
+
+	m.endJob(wf, ctx)
+	if wf.NeedsReview() {
+		log.Println("holding low confidence match for review", wf)
+	} else {
+		m.ingests.Add(1)
+		go func() {
+			defer m.ingests.Done()
+			m.Ingest(wf)
+		}()
+	}
 
 	return nil
 }
 
+// backup decrypts the whole disc into dir, and packs it into an iso if
+// backups are configured to.
+func (m *workflowManager) backup(ctx context.Context, d drive.Drive, disc *drive.Disc, dir string, profile drive.RipProfile, statchan chan makemkv.Status) (*model.MkvFile, error) {
+	backupdir, err := d.Backup(ctx, dir, profile, statchan)
+	if err != nil {
+		return nil, err
+	}
+
+	if !m.backups.Iso {
+		log.Println("starting sha256sum for " + backupdir)
+		shasum, err := util.Sha256sumDir(backupdir)
+		if err != nil {
+			return nil, err
+		}
+		return &model.MkvFile{Filename: backupdir, Shasum: shasum}, nil
+	}
+
+	iso := backupdir + ".iso"
+	label := disc.Label
+	if label == "" {
+		label = "BACKUP"
+	}
+	log.Println("packing backup into", iso)
+	cmd := exec.CommandContext(ctx, "genisoimage", "-quiet", "-udf", "-allow-limited-size", "-V", label, "-o", iso, backupdir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Println("error packing iso", iso, "err:", err, string(out))
+		os.Remove(iso)
+		return nil, err
+	}
+	os.RemoveAll(backupdir)
+
+	log.Println("starting sha256sum for " + iso)
+	shasum, err := util.Sha256sum(iso)
+	if err != nil {
+		return nil, err
+	}
+	return &model.MkvFile{Filename: iso, Shasum: shasum}, nil
+}
+
+// Ingest copies the ripped file to every target that hasn't been verified yet.
+// Titles are first transcoded by every encoder that hasn't made its variant
+// yet, and the variants copied to the encoders' targets. The files are only
+// cleaned up once all targets are verified, if any fail the workflow moves to
+// StatusError and Ingest can be called again to retry them.
 func (m *workflowManager) Ingest(wf *model.Workflow) error {
-	if wf.Status != model.StatusPending {
+	if wf.Status != model.StatusPending && wf.Status != model.StatusCancelled && wf.Status != model.StatusError {
 		log.Println("ingest workflow not ready", wf)
 		return fmt.Errorf("workflow not ready, status: %s", wf.Status)
 	}
@@ -94,27 +206,65 @@ func (m *workflowManager) Ingest(wf *model.Workflow) error {
 		return fmt.Errorf("no files to ingest")
 	}
 
-	if wf.Name == nil || wf.Year == nil {
+	media, ok := wf.Media()
+	if !ok {
 		log.Println("name or year is not set")
 		return fmt.Errorf("name or year is not set")
 	}
 
+	if wf.IsBackup() && len(m.backups.Targets) == 0 {
+		log.Println("no backup targets for", wf)
+		return fmt.Errorf("no backup targets are configured")
+	}
+
+	ctx, err := m.startJob(wf, false)
+	if err != nil {
+		return err
+	}
+	defer m.endJob(wf, ctx)
+
+	if !wf.IsBackup() {
+		if err := m.transcodeAll(ctx, wf); err != nil {
+			if ctx.Err() != nil {
+				log.Println("transcode cancelled", wf)
+				wf.Status = model.StatusCancelled
+			} else {
+				wf.Status = model.StatusError
+			}
+			m.Save(wf)
+			return err
+		}
+	}
+
 	wf.Status = model.StatusImporting
 	m.Save(wf)
 
-	for _, target := range m.targets {
-		ingester, err := ingest.NewIngester(target, m.useMovieDir, m.shafile)
-		if err != nil {
-			log.Println("error finding ingester", err, "for target", target)
-			continue
-		}
+	targets := m.targets
+	if wf.IsBackup() {
+		targets = m.backups.Targets
+	}
 
-		err = ingester.Ingest(*file, *wf.Name, *wf.Year)
-		if err != nil {
-			log.Println("error running ingester", ingester, err)
+	failed, err := m.ingestTo(ctx, wf, *file, media, targets, wf.TargetStatus)
+	if err != nil {
+		return err
+	}
+	if !wf.IsBackup() {
+		for _, enc := range m.encoders {
+			v := wf.Variant(enc.Name)
+			n, err := m.ingestTo(ctx, wf, *v.File, media, enc.Targets, v.TargetStatus)
+			if err != nil {
+				return err
+			}
+			failed += n
 		}
 	}
 
+	if failed > 0 {
+		wf.Status = model.StatusError
+		m.Save(wf)
+		return fmt.Errorf("ingest failed for %d targets", failed)
+	}
+
 	log.Println("cleaning workflow")
 	m.Clean(wf)
 	wf.Status = model.StatusDone
@@ -122,16 +272,152 @@ func (m *workflowManager) Ingest(wf *model.Workflow) error {
 	return nil
 }
 
+// ingestTo copies file to every target that status doesn't have as verified
+// yet, and returns how many failed. The error is only set if the ingest was
+// cancelled, the workflow is StatusCancelled then.
+func (m *workflowManager) ingestTo(ctx context.Context, wf *model.Workflow, file model.MkvFile, media model.Media, targets []ingest.Target, status func(string) *model.TargetStatus) (int, error) {
+	failed := 0
+	for _, target := range targets {
+		ts := status(target.Url.String())
+		if ts.Status == model.IngestVerified {
+			continue
+		}
+		ts.Status = model.IngestCopying
+		ts.Error = ""
+		m.Save(wf)
+
+		title := "Copying to " + target.Url.Redacted()
+		start := time.Now()
+		progress := func(copied int64, resumed int64, total int64) {
+			if total > 0 {
+				p := int(copied * progressMax / total)
+				rate := throughput(copied-resumed, time.Since(start))
+				m.setProgress(wf, &makemkv.Status{Title: title + rate, Current: p, Total: p, Max: progressMax})
+			}
+		}
+		ingester, err := ingest.NewIngester(target, m.shafile)
+		if err == nil {
+			err = ingester.Ingest(ctx, file, media, progress)
+			m.setProgress(wf, nil)
+		}
+		if ctx.Err() != nil {
+			log.Println("ingest cancelled", wf)
+			ts.Status = model.IngestPending
+			wf.Status = model.StatusCancelled
+			m.Save(wf)
+			return failed, ctx.Err()
+		}
+		if err != nil {
+			log.Println("error ingesting to target", target.Url, "err:", err)
+			ts.Status = model.IngestFailed
+			ts.Error = err.Error()
+			failed++
+		} else {
+			ts.Status = model.IngestVerified
+		}
+		m.Save(wf)
+	}
+	return failed, nil
+}
+
+// throughput formats the rate of a copy for the progress title, it's empty
+// until the copy has run long enough to tell.
+func throughput(copied int64, elapsed time.Duration) string {
+	if elapsed < time.Second || copied <= 0 {
+		return ""
+	}
+	rate := float64(copied) / elapsed.Seconds()
+	units := []string{"B/s", "KB/s", "MB/s", "GB/s"}
+	i := 0
+	for rate >= 1000 && i < len(units)-1 {
+		rate /= 1000
+		i++
+	}
+	return fmt.Sprintf(" (%.1f %s)", rate, units[i])
+}
+
+// Cancel stops a running rip or ingest. The workflow moves to
+// StatusCancelled once the running job has wound down.
+func (m *workflowManager) Cancel(wf *model.Workflow) error {
+	m.mutex.Lock()
+	if j, ok := m.jobs[jobKey(wf)]; ok {
+		m.mutex.Unlock()
+		log.Println("cancelling", wf)
+		j.cancel()
+		return nil
+	}
+	if wf.Status != model.StatusQueued {
+		m.mutex.Unlock()
+		return fmt.Errorf("workflow is not running: %s", wf.Status)
+	}
+	// under the same lock the queue starts it with, so it's either cancelled
+	// here or its job is cancelled above
+	log.Println("removing from queue", wf)
+	m.dequeue(wf)
+	wf.Status = model.StatusCancelled
+	m.mutex.Unlock()
+	return m.Save(wf)
+}
+
+func jobKey(wf *model.Workflow) string {
+	return fmt.Sprintf("%s/%d", wf.DiscId, wf.TitleId)
+}
+
+// startJob starts the job of wf, with queued only if wf is still queued.
+func (m *workflowManager) startJob(wf *model.Workflow, queued bool) (context.Context, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if queued && wf.Status != model.StatusQueued {
+		return nil, fmt.Errorf("workflow is no longer queued: %s", wf.Status)
+	}
+	key := jobKey(wf)
+	if _, ok := m.jobs[key]; ok {
+		return nil, fmt.Errorf("workflow is already running: %s", wf.Status)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.jobs[key] = job{ctx, cancel}
+	return ctx, nil
+}
+
+// endJob ends the job started with ctx. A rip starts the ingest as a new job
+// once it's done, which is left running.
+func (m *workflowManager) endJob(wf *model.Workflow, ctx context.Context) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := jobKey(wf)
+	if j, ok := m.jobs[key]; ok && j.ctx == ctx {
+		j.cancel()
+		delete(m.jobs, key)
+	}
+}
+
+// job is a running rip or ingest
+type job struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
 type workflowManager struct {
-	workflows   map[string]map[int]*model.Workflow
-	driveman    drive.DriveManager
-	discdb      drive.DiscDatabase
-	targets     []*url.URL
-	outdir      string
-	file        string
-	useMovieDir bool
-	shafile     string
-	persistFn   func(*workflowManager, *model.Workflow) error
+	mutex     sync.RWMutex
+	workflows map[string]map[int]*model.Workflow
+	jobs      map[string]job
+	queues    map[string]*ripQueue
+	discdb    drive.DiscDatabase
+	targets   []ingest.Target
+	backups   Backups
+	profiles  drive.Profiles
+	encoders  []Encoder
+	outdir    string
+	file      string
+	shafile   string
+	autoEject bool
+	events    *event.Hub
+	progress  map[string]makemkv.Status
+	persistFn func(*workflowManager, *model.Workflow) error
+	// ingests are the ingests Start runs once a rip is done
+	ingests sync.WaitGroup
 }
 
 func newWorkflow(discId string, titleId int, label string, name string) *model.Workflow {
@@ -148,28 +434,31 @@ func newWorkflow(discId string, titleId int, label string, name string) *model.W
 }
 
 func NewJsonWorkflowManager(
-	driveman drive.DriveManager,
 	discdb drive.DiscDatabase,
-	targets []*url.URL,
+	targets []ingest.Target,
 	outdir string,
 	file string,
-	useMovieDir bool,
 	shafile string,
+	autoEject bool,
+	events *event.Hub,
 ) WorkflowManager {
 	workflows, err := loadWorkflowJson(file)
 	if err != nil {
 		workflows = make(map[string]map[int]*model.Workflow)
 	}
 	m := workflowManager{
-		workflows:   workflows,
-		driveman:    driveman,
-		discdb:      discdb,
-		targets:     targets,
-		outdir:      outdir,
-		file:        file,
-		useMovieDir: useMovieDir,
-		shafile:     shafile,
-		persistFn:   jsonPersist,
+		workflows: workflows,
+		jobs:      make(map[string]job),
+		queues:    make(map[string]*ripQueue),
+		discdb:    discdb,
+		targets:   targets,
+		outdir:    outdir,
+		file:      file,
+		shafile:   shafile,
+		autoEject: autoEject,
+		events:    events,
+		progress:  make(map[string]makemkv.Status),
+		persistFn: jsonPersist,
 	}
 	return &m
 }
@@ -184,6 +473,9 @@ func getOrCreate(wfs map[string]map[int]*model.Workflow, key string) map[int]*mo
 }
 
 func (m *workflowManager) NewWorkflow(discId string, titleId int, label string, name string) (*model.Workflow, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	titleWfs := getOrCreate(m.workflows, discId)
 	w, containsKey := titleWfs[titleId]
 	if containsKey {
@@ -195,6 +487,9 @@ func (m *workflowManager) NewWorkflow(discId string, titleId int, label string,
 }
 
 func (m *workflowManager) GetWorkflow(discId string, titleId int) *model.Workflow {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	titleWfs, containsKey := m.workflows[discId]
 	if !containsKey {
 		return nil
@@ -203,6 +498,9 @@ func (m *workflowManager) GetWorkflow(discId string, titleId int) *model.Workflo
 }
 
 func (m *workflowManager) GetWorkflows(discId string) []*model.Workflow {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	titleWfs, containsKey := m.workflows[discId]
 	if !containsKey {
 		return make([]*model.Workflow, 0)
@@ -216,6 +514,9 @@ func (m *workflowManager) GetWorkflows(discId string) []*model.Workflow {
 }
 
 func (m *workflowManager) GetAllWorkflows() []*model.Workflow {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	values := make([]*model.Workflow, 0, len(m.workflows))
 	for _, t := range m.workflows {
 		for _, v := range t {
@@ -226,10 +527,44 @@ func (m *workflowManager) GetAllWorkflows() []*model.Workflow {
 }
 
 func (m *workflowManager) Save(w *model.Workflow) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	titleWfs := getOrCreate(m.workflows, w.DiscId)
 	titleWfs[w.TitleId] = w
 
-	return m.persistFn(m, w)
+	err := m.persistFn(m, w)
+	m.events.Publish(event.Workflow(w.DiscId, w.TitleId))
+	return err
+}
+
+// Progress returns the latest makemkv status of a running rip, or nil if the
+// workflow isn't ripping.
+func (m *workflowManager) Progress(wf *model.Workflow) *makemkv.Status {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	stat, ok := m.progress[jobKey(wf)]
+	if !ok {
+		return nil
+	}
+	return &stat
+}
+
+func (m *workflowManager) Profiles() drive.Profiles {
+	return m.profiles
+}
+
+func (m *workflowManager) setProgress(wf *model.Workflow, stat *makemkv.Status) {
+	m.mutex.Lock()
+	if stat == nil {
+		delete(m.progress, jobKey(wf))
+	} else {
+		m.progress[jobKey(wf)] = *stat
+	}
+	m.mutex.Unlock()
+
+	m.events.Publish(event.Progress(wf.DiscId, wf.TitleId))
 }
 
 func jsonPersist(m *workflowManager, w *model.Workflow) error {
@@ -243,12 +578,27 @@ func jsonPersist(m *workflowManager, w *model.Workflow) error {
 }
 
 func (m *workflowManager) Clean(w *model.Workflow) error {
-	err := os.Remove(w.File.Filename)
+	var err error
+	if w.IsBackup() {
+		err = os.RemoveAll(w.File.Filename)
+	} else {
+		err = os.Remove(w.File.Filename)
+	}
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Println("error removing file", w.File.Filename)
 		return err
 	}
 	w.File = nil
+	for _, v := range w.Variants {
+		if v.File == nil {
+			continue
+		}
+		if err := os.Remove(v.File.Filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println("error removing file", v.File.Filename)
+			return err
+		}
+		v.File = nil
+	}
 
 	dir := path.Join(m.outdir, w.DiscId)
 	err = os.Remove(dir)
@@ -0,0 +1,407 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/event"
+	"github.com/aravance/mkv-ripper/ingest"
+	"github.com/aravance/mkv-ripper/model"
+	_ "modernc.org/sqlite"
+)
+
+// Mock Drive whose rips block until they are cancelled
+type blockingDrive struct {
+	disc    *drive.Disc
+	started chan struct{}
+}
+
+func (d *blockingDrive) Id() string                              { return "sr0" }
+func (d *blockingDrive) Device() string                          { return "/dev/sr0" }
+func (d *blockingDrive) Eject() error                            { return nil }
+func (d *blockingDrive) GetDiscInfo() (*makemkv.DiscInfo, error) { return nil, nil }
+func (d *blockingDrive) GetDisc() *drive.Disc                    { return d.disc }
+func (d *blockingDrive) HasDisc() bool                           { return true }
+func (d *blockingDrive) Status() drive.DriveStatus               { return drive.StatusReady }
+func (d *blockingDrive) RipFile(ctx context.Context, _ *makemkv.TitleInfo, _ string, _ drive.RipProfile, _ chan makemkv.Status) (*model.MkvFile, error) {
+	close(d.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+func (d *blockingDrive) Backup(ctx context.Context, _ string, _ drive.RipProfile, _ chan makemkv.Status) (string, error) {
+	close(d.started)
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestWorkflowManager_CancelRip(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	discdb := &mockDiscDB{data: map[string]*makemkv.DiscInfo{
+		"d1": {Titles: []makemkv.TitleInfo{{Id: 0, FileName: "title_t00.mkv"}}},
+	}}
+	wfm, err := NewSqliteWorkflowManager(db, discdb, nil, Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &blockingDrive{disc: &drive.Disc{Id: "d1"}, started: make(chan struct{})}
+	wf, _ := wfm.NewWorkflow("d1", 0, "LABEL", "movie")
+
+	if err := wfm.Cancel(wf); err == nil {
+		t.Fatal("expected error cancelling a workflow that is not running")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- wfm.Start(d, wf)
+	}()
+
+	select {
+	case <-d.started:
+	case <-time.After(time.Second):
+		t.Fatal("rip did not start")
+	}
+
+	if err := wfm.Cancel(wf); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected Start to return an error after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after cancel")
+	}
+
+	got := wfm.GetWorkflow("d1", 0)
+	if got.Status != model.StatusCancelled {
+		t.Fatalf("expected status %s, got %s", model.StatusCancelled, got.Status)
+	}
+}
+
+func TestWorkflowManager_IngestFailedTarget(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	outdir := t.TempDir()
+	good := t.TempDir()
+	bad := path.Join(t.TempDir(), "missing")
+	if err := os.WriteFile(bad, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets := []ingest.Target{
+		{Url: &url.URL{Path: good}},
+		{Url: &url.URL{Path: bad}},
+	}
+
+	wfm, err := NewSqliteWorkflowManager(db, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, targets, Backups{}, drive.Profiles{}, nil, outdir, "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ripped := path.Join(outdir, "d1", "title_t00.mkv")
+	if err := os.MkdirAll(path.Dir(ripped), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ripped, []byte("foobar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wf, _ := wfm.NewWorkflow("d1", 0, "LABEL", "movie")
+	wf.Name = strPtr("bar")
+	wf.Year = strPtr("1989")
+	wf.File = &model.MkvFile{
+		Filename:   ripped,
+		Shasum:     "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2",
+		Resolution: "1080p",
+	}
+	wf.Status = model.StatusPending
+	wfm.Save(wf)
+
+	if err := wfm.Ingest(wf); err == nil {
+		t.Fatal("expected error ingesting to a bad target")
+	}
+	if wf.Status != model.StatusError {
+		t.Fatalf("expected status %s, got %s", model.StatusError, wf.Status)
+	}
+	if _, err := os.Stat(ripped); err != nil {
+		t.Fatalf("ripped file was removed after a failed ingest: %v", err)
+	}
+	if ts := wf.TargetStatus(targets[0].Url.String()); ts.Status != model.IngestVerified {
+		t.Fatalf("expected good target %s, got %s", model.IngestVerified, ts.Status)
+	}
+	if ts := wf.TargetStatus(targets[1].Url.String()); ts.Status != model.IngestFailed || ts.Error == "" {
+		t.Fatalf("expected bad target %s with an error, got %+v", model.IngestFailed, ts)
+	}
+
+	// fix the target and retry, the verified target shouldn't be copied again
+	if err := os.Remove(bad); err != nil {
+		t.Fatal(err)
+	}
+	goodfile := path.Join(good, "bar (1989) [1080p].mkv")
+	if err := os.Remove(goodfile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wfm.Ingest(wf); err != nil {
+		t.Fatal(err)
+	}
+	if wf.Status != model.StatusDone {
+		t.Fatalf("expected status %s, got %s", model.StatusDone, wf.Status)
+	}
+	if _, err := os.Stat(path.Join(bad, "bar (1989) [1080p].mkv")); err != nil {
+		t.Fatalf("retried target was not ingested: %v", err)
+	}
+	if _, err := os.Stat(goodfile); err == nil {
+		t.Fatal("verified target was ingested again")
+	}
+	if _, err := os.Stat(ripped); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ripped file to be cleaned, got %v", err)
+	}
+}
+
+func TestWorkflowManager_PublishesEvents(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	hub := event.NewHub()
+	wfm, err := NewSqliteWorkflowManager(db, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", false, hub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := hub.Subscribe()
+	defer hub.Unsubscribe(ch)
+
+	wf, _ := wfm.NewWorkflow("d1", 2, "LABEL", "movie")
+	if err := wfm.Save(wf); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, ch, event.Workflow("d1", 2))
+
+	m := wfm.(*workflowManager)
+	m.setProgress(wf, &makemkv.Status{Total: 5, Max: 10})
+	expectEvent(t, ch, event.Progress("d1", 2))
+	if stat := wfm.Progress(wf); stat == nil || stat.Total != 5 {
+		t.Fatalf("Progress() = %v, expected Total 5", stat)
+	}
+
+	m.setProgress(wf, nil)
+	expectEvent(t, ch, event.Progress("d1", 2))
+	if stat := wfm.Progress(wf); stat != nil {
+		t.Fatalf("Progress() = %v, expected nil", stat)
+	}
+}
+
+func expectEvent(t *testing.T, ch chan event.Event, expected event.Event) {
+	t.Helper()
+	select {
+	case e := <-ch:
+		if e != expected {
+			t.Fatalf("got event %v, expected: %v", e, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event, expected: %v", expected)
+	}
+}
+
+func TestWorkflowManager_HoldsLowConfidenceMatch(t *testing.T) {
+	db, _ := sql.Open("sqlite", ":memory:")
+	defer db.Close()
+	wfm := newQueueTestManager(t, db)
+	d := &recordingDrive{disc: &drive.Disc{Id: "d1", Label: "DISC"}, done: make(chan int, 2)}
+
+	low, high := 0.3, 0.9
+	held, _ := wfm.NewWorkflow("d1", 0, "DISC", "movie")
+	held.Name, held.Year, held.Confidence = strPtr("Movie"), strPtr("2000"), &low
+	ingested, _ := wfm.NewWorkflow("d1", 1, "DISC", "movie")
+	ingested.Name, ingested.Year, ingested.Confidence = strPtr("Movie"), strPtr("2000"), &high
+
+	if err := wfm.Start(d, held); err != nil {
+		t.Fatal(err)
+	}
+	if err := wfm.Start(d, ingested); err != nil {
+		t.Fatal(err)
+	}
+
+	wfm.(*workflowManager).ingests.Wait()
+	if ingested.Status != model.StatusDone {
+		t.Fatalf("expected confident match to be ingested, got %s", ingested.Status)
+	}
+	if held.Status != model.StatusPending {
+		t.Fatalf("expected low confidence match to be held, got %s", held.Status)
+	}
+}
+
+// Mock Drive whose rips are a small file
+type fileDrive struct {
+	disc *drive.Disc
+}
+
+func (d *fileDrive) Id() string                              { return "sr0" }
+func (d *fileDrive) Device() string                          { return "/dev/sr0" }
+func (d *fileDrive) Eject() error                            { return nil }
+func (d *fileDrive) GetDiscInfo() (*makemkv.DiscInfo, error) { return nil, nil }
+func (d *fileDrive) GetDisc() *drive.Disc                    { return d.disc }
+func (d *fileDrive) HasDisc() bool                           { return true }
+func (d *fileDrive) Status() drive.DriveStatus               { return drive.StatusReady }
+func (d *fileDrive) RipFile(_ context.Context, title *makemkv.TitleInfo, outdir string, _ drive.RipProfile, _ chan makemkv.Status) (*model.MkvFile, error) {
+	f := path.Join(outdir, title.FileName)
+	return &model.MkvFile{
+		Filename:   f,
+		Shasum:     "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2",
+		Resolution: "1080p",
+	}, os.WriteFile(f, []byte("foobar"), 0644)
+}
+func (d *fileDrive) Backup(context.Context, string, drive.RipProfile, chan makemkv.Status) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func TestWorkflowManager_AutoIngest(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	discdb := &mockDiscDB{data: map[string]*makemkv.DiscInfo{
+		"d1": {Titles: []makemkv.TitleInfo{{Id: 0, FileName: "title_t00.mkv"}}},
+	}}
+	// a slow encoder keeps the ingest running while the rip winds down, the
+	// rip used to cancel it as it ended its own job
+	encoders := []Encoder{{
+		Name:    "copy",
+		Command: []string{"sh", "-c", `sleep 0.2; cp "$0" "$1"`, "{input}", "{output}"},
+	}}
+	target := t.TempDir()
+	wfm, err := NewSqliteWorkflowManager(db, discdb, []ingest.Target{{Url: &url.URL{Path: target}}}, Backups{}, drive.Profiles{}, encoders, t.TempDir(), "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fileDrive{disc: &drive.Disc{Id: "d1", Label: "LABEL"}}
+
+	wf, _ := wfm.NewWorkflow("d1", 0, "LABEL", "movie")
+	wf.Name = strPtr("bar")
+	wf.Year = strPtr("1989")
+	if err := wfm.Start(d, wf); err != nil {
+		t.Fatal(err)
+	}
+	wfm.(*workflowManager).ingests.Wait()
+
+	if wf.Status != model.StatusDone {
+		t.Fatalf("expected rip to be ingested, got %s", wf.Status)
+	}
+	if _, err := os.Stat(path.Join(target, "bar (1989) [1080p].mkv")); err != nil {
+		t.Fatalf("rip was not ingested: %v", err)
+	}
+}
+
+// Mock Drive whose backups are a folder with one file
+type backupDrive struct {
+	disc *drive.Disc
+}
+
+func (d *backupDrive) Id() string                              { return "sr0" }
+func (d *backupDrive) Device() string                          { return "/dev/sr0" }
+func (d *backupDrive) Eject() error                            { return nil }
+func (d *backupDrive) GetDiscInfo() (*makemkv.DiscInfo, error) { return nil, nil }
+func (d *backupDrive) GetDisc() *drive.Disc                    { return d.disc }
+func (d *backupDrive) HasDisc() bool                           { return true }
+func (d *backupDrive) Status() drive.DriveStatus               { return drive.StatusReady }
+func (d *backupDrive) RipFile(context.Context, *makemkv.TitleInfo, string, drive.RipProfile, chan makemkv.Status) (*model.MkvFile, error) {
+	return nil, errors.New("not supported")
+}
+func (d *backupDrive) Backup(_ context.Context, outdir string, _ drive.RipProfile, _ chan makemkv.Status) (string, error) {
+	dir := path.Join(outdir, "backup")
+	if err := os.MkdirAll(path.Join(dir, "BDMV"), 0755); err != nil {
+		return "", err
+	}
+	return dir, os.WriteFile(path.Join(dir, "BDMV", "index.bdmv"), []byte("foo"), 0644)
+}
+
+func TestWorkflowManager_Backup(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	movies := t.TempDir()
+	backups := t.TempDir()
+	naming, _ := ingest.NewNaming(ingest.DefaultBackupTemplate, ingest.DefaultEpisodeTemplate)
+	outdir := t.TempDir()
+	d := &backupDrive{disc: &drive.Disc{Id: "d1", Label: "LABEL"}}
+
+	nobackups, err := NewSqliteWorkflowManager(db, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, []ingest.Target{{Url: &url.URL{Path: movies}}}, Backups{}, drive.Profiles{}, nil, outdir, "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf, _ := nobackups.NewWorkflow("d1", model.BackupTitle, "LABEL", "movie")
+	if err := nobackups.Enqueue(d, wf); err == nil {
+		t.Fatal("expected error queueing a backup without backup targets")
+	}
+
+	wfm, err := NewSqliteWorkflowManager(db, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, []ingest.Target{{Url: &url.URL{Path: movies}}}, Backups{Targets: []ingest.Target{{Url: &url.URL{Path: backups}, Naming: naming}}}, drive.Profiles{}, nil, outdir, "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf, _ = wfm.NewWorkflow("d1", model.BackupTitle, "LABEL", "movie")
+	wf.Name = strPtr("bar")
+	wf.Year = strPtr("1989")
+	if err := wfm.Start(d, wf); err != nil {
+		t.Fatal(err)
+	}
+
+	wfm.(*workflowManager).ingests.Wait()
+	if wf.Status != model.StatusDone {
+		t.Fatalf("expected backup to be ingested, got %s", wf.Status)
+	}
+	if _, err := os.Stat(path.Join(backups, "bar (1989)", "BDMV", "index.bdmv")); err != nil {
+		t.Fatalf("backup was not ingested: %v", err)
+	}
+	if entries, _ := os.ReadDir(movies); len(entries) != 0 {
+		t.Fatalf("backup was ingested to the movie targets: %v", entries)
+	}
+	if _, err := os.Stat(path.Join(outdir, "d1", "backup")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected backup to be cleaned, got %v", err)
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	tests := []struct {
+		copied   int64
+		elapsed  time.Duration
+		expected string
+	}{
+		{1 << 30, 500 * time.Millisecond, ""},
+		{0, time.Minute, ""},
+		{500, time.Second, " (500.0 B/s)"},
+		{170_000_000, 2 * time.Second, " (85.0 MB/s)"},
+		{3_000_000_000_000, time.Second, " (3000.0 GB/s)"},
+	}
+	for _, test := range tests {
+		if got := throughput(test.copied, test.elapsed); got != test.expected {
+			t.Errorf("throughput(%d, %v) = %q, expected: %q", test.copied, test.elapsed, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/model"
+)
+
+// ripQueue holds the workflows waiting to be ripped on one drive. Queued
+// workflows are persisted with StatusQueued, so the queue can be rebuilt by
+// ResumeQueue after a restart.
+type ripQueue struct {
+	drive   drive.Drive
+	pending []*model.Workflow
+	running bool
+}
+
+func (m *workflowManager) Enqueue(d drive.Drive, wf *model.Workflow) error {
+	if wf.Status == model.StatusImporting || wf.Status == model.StatusRipping || wf.Status == model.StatusTranscoding || wf.Status == model.StatusQueued {
+		return fmt.Errorf("workflow is already running: %s", wf.Status)
+	}
+	if wf.IsBackup() && len(m.backups.Targets) == 0 {
+		return fmt.Errorf("no backup targets are configured")
+	}
+
+	wf.Status = model.StatusQueued
+	if err := m.Save(wf); err != nil {
+		return err
+	}
+
+	m.enqueue(d, wf)
+	return nil
+}
+
+func (m *workflowManager) ResumeQueue(d drive.Drive) {
+	disc := d.GetDisc()
+	if disc == nil {
+		return
+	}
+
+	queued := slices.DeleteFunc(m.GetWorkflows(disc.Id), func(wf *model.Workflow) bool {
+		return wf.Status != model.StatusQueued
+	})
+	slices.SortFunc(queued, func(a, b *model.Workflow) int {
+		return a.TitleId - b.TitleId
+	})
+	for _, wf := range queued {
+		m.enqueue(d, wf)
+	}
+}
+
+func (m *workflowManager) enqueue(d drive.Drive, wf *model.Workflow) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q, ok := m.queues[d.Id()]
+	if !ok {
+		q = &ripQueue{}
+		m.queues[d.Id()] = q
+	}
+	q.drive = d
+	if slices.Contains(q.pending, wf) {
+		return
+	}
+	log.Println("queueing", wf, "on drive", d.Id())
+	q.pending = append(q.pending, wf)
+
+	if !q.running {
+		q.running = true
+		go m.runQueue(q)
+	}
+}
+
+// hasQueued reports whether any workflows are waiting for drive d.
+func (m *workflowManager) hasQueued(d drive.Drive) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	q, ok := m.queues[d.Id()]
+	return ok && len(q.pending) > 0
+}
+
+// dequeue removes wf from whichever drive queue it is waiting in, m.mutex
+// must be held.
+func (m *workflowManager) dequeue(wf *model.Workflow) {
+	for _, q := range m.queues {
+		q.pending = slices.DeleteFunc(q.pending, func(o *model.Workflow) bool {
+			return o == wf
+		})
+	}
+}
+
+func (m *workflowManager) runQueue(q *ripQueue) {
+	for {
+		m.mutex.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			m.mutex.Unlock()
+			return
+		}
+		wf := q.pending[0]
+		q.pending = q.pending[1:]
+		d := q.drive
+		m.mutex.Unlock()
+
+		if disc := d.GetDisc(); disc == nil || disc.Id != wf.DiscId {
+			// leave it queued, it resumes when the disc is inserted again
+			log.Println("disc changed, leaving workflow queued", wf)
+			continue
+		}
+
+		if err := m.start(d, wf, true); err != nil {
+			log.Println("error running queued workflow", wf, "err:", err)
+		}
+	}
+}
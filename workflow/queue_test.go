@@ -0,0 +1,276 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/google/go-cmp/cmp"
+	_ "modernc.org/sqlite"
+)
+
+// Mock Drive that records the titles it rips
+type recordingDrive struct {
+	disc     *drive.Disc
+	mutex    sync.Mutex
+	ripped   []int
+	profiles []string
+	done     chan int
+}
+
+func (d *recordingDrive) Id() string                              { return "sr0" }
+func (d *recordingDrive) Device() string                          { return "/dev/sr0" }
+func (d *recordingDrive) Eject() error                            { return nil }
+func (d *recordingDrive) GetDiscInfo() (*makemkv.DiscInfo, error) { return nil, nil }
+func (d *recordingDrive) GetDisc() *drive.Disc                    { return d.disc }
+func (d *recordingDrive) HasDisc() bool                           { return true }
+func (d *recordingDrive) Status() drive.DriveStatus               { return drive.StatusReady }
+func (d *recordingDrive) RipFile(_ context.Context, title *makemkv.TitleInfo, outdir string, profile drive.RipProfile, _ chan makemkv.Status) (*model.MkvFile, error) {
+	d.mutex.Lock()
+	d.ripped = append(d.ripped, title.Id)
+	d.profiles = append(d.profiles, profile.Name)
+	d.mutex.Unlock()
+	d.done <- title.Id
+	return &model.MkvFile{Filename: outdir + "/" + title.FileName}, nil
+}
+func (d *recordingDrive) Backup(context.Context, string, drive.RipProfile, chan makemkv.Status) (string, error) {
+	return "", errors.New("not supported")
+}
+
+// Mock Drive that records how many titles were ripped when it's ejected
+type ejectingDrive struct {
+	*recordingDrive
+	ejects chan int
+}
+
+func (d *ejectingDrive) Eject() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.ejects <- len(d.ripped)
+	return nil
+}
+
+func newQueueTestManager(t *testing.T, db *sql.DB) WorkflowManager {
+	t.Helper()
+	return newProfileTestManager(t, db, drive.Profiles{})
+}
+
+func newProfileTestManager(t *testing.T, db *sql.DB, profiles drive.Profiles) WorkflowManager {
+	t.Helper()
+	discdb := &mockDiscDB{data: map[string]*makemkv.DiscInfo{
+		"d1": {Titles: []makemkv.TitleInfo{
+			{Id: 0, FileName: "title_t00.mkv"},
+			{Id: 1, FileName: "title_t01.mkv"},
+			{Id: 2, FileName: "title_t02.mkv"},
+		}},
+	}}
+	wfm, err := NewSqliteWorkflowManager(db, discdb, nil, Backups{}, profiles, nil, t.TempDir(), "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wfm
+}
+
+func waitForRips(t *testing.T, d *recordingDrive, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-d.done:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d rips, got %d", n, i)
+		}
+	}
+}
+
+func TestWorkflowManager_EnqueueRipsInOrder(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wfm := newQueueTestManager(t, db)
+
+	d := &recordingDrive{disc: &drive.Disc{Id: "d1"}, done: make(chan int)}
+	for _, titleId := range []int{2, 0} {
+		wf, _ := wfm.NewWorkflow("d1", titleId, "LABEL", "movie")
+		if err := wfm.Enqueue(d, wf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForRips(t, d, 2)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !cmp.Equal(d.ripped, []int{2, 0}) {
+		t.Fatalf("ripped titles = %v, expected %v", d.ripped, []int{2, 0})
+	}
+}
+
+func TestWorkflowManager_ResumeQueue(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// queued before a restart
+	wfm1 := newQueueTestManager(t, db)
+	wfm1.Save(&model.Workflow{DiscId: "d1", TitleId: 1, Label: "L", OriginalName: "b", Status: model.StatusQueued})
+	wfm1.Save(&model.Workflow{DiscId: "d1", TitleId: 0, Label: "L", OriginalName: "a", Status: model.StatusQueued})
+	wfm1.Save(&model.Workflow{DiscId: "d1", TitleId: 2, Label: "L", OriginalName: "c", Status: model.StatusDone})
+
+	wfm2 := newQueueTestManager(t, db)
+	d := &recordingDrive{disc: &drive.Disc{Id: "d1"}, done: make(chan int)}
+	wfm2.ResumeQueue(d)
+	waitForRips(t, d, 2)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !cmp.Equal(d.ripped, []int{0, 1}) {
+		t.Fatalf("ripped titles = %v, expected %v", d.ripped, []int{0, 1})
+	}
+}
+
+func TestWorkflowManager_RipProfile(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	profiles, err := drive.NewProfiles([]drive.RipProfile{{Name: "movies", Minlength: 3600}, {Name: "extras", Minlength: 60}}, "movies", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wfm1 := newProfileTestManager(t, db, profiles)
+	wfm1.Save(&model.Workflow{DiscId: "d1", TitleId: 0, Label: "L", OriginalName: "a", Status: model.StatusQueued})
+	wfm1.Save(&model.Workflow{DiscId: "d1", TitleId: 1, Label: "L", OriginalName: "b", Status: model.StatusQueued, Profile: "extras"})
+
+	wfm2 := newProfileTestManager(t, db, profiles)
+	if got := wfm2.GetWorkflow("d1", 1).Profile; got != "extras" {
+		t.Fatalf("reloaded workflow profile = %q, expected: extras", got)
+	}
+	d := &recordingDrive{disc: &drive.Disc{Id: "d1"}, done: make(chan int)}
+	wfm2.ResumeQueue(d)
+	waitForRips(t, d, 2)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if expected := []string{"movies", "extras"}; !cmp.Equal(d.profiles, expected) {
+		t.Fatalf("rip profiles = %v, expected: %v", d.profiles, expected)
+	}
+}
+
+func TestWorkflowManager_CancelQueued(t *testing.T) {
+	wfm, _ := newTestManager(t)
+
+	wf := &model.Workflow{DiscId: "d1", TitleId: 0, Label: "L", OriginalName: "a", Status: model.StatusQueued}
+	wfm.Save(wf)
+
+	if err := wfm.Cancel(wf); err != nil {
+		t.Fatal(err)
+	}
+	if got := wfm.GetWorkflow("d1", 0); got.Status != model.StatusCancelled {
+		t.Fatalf("expected status %s, got %s", model.StatusCancelled, got.Status)
+	}
+}
+
+// Mock Drive that holds up the queue the first time it's asked for its disc,
+// once the queue has taken a workflow off of it
+type pausingDrive struct {
+	*recordingDrive
+	once    sync.Once
+	popped  chan struct{}
+	release chan struct{}
+}
+
+func (d *pausingDrive) GetDisc() *drive.Disc {
+	d.once.Do(func() {
+		close(d.popped)
+		<-d.release
+	})
+	return d.recordingDrive.GetDisc()
+}
+
+func TestWorkflowManager_CancelPopped(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	wfm := newQueueTestManager(t, db)
+
+	d := &pausingDrive{
+		recordingDrive: &recordingDrive{disc: &drive.Disc{Id: "d1"}, done: make(chan int, 1)},
+		popped:         make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+	wf, _ := wfm.NewWorkflow("d1", 0, "LABEL", "movie")
+	if err := wfm.Enqueue(d, wf); err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelled after the queue took it, but before it started
+	<-d.popped
+	if err := wfm.Cancel(wf); err != nil {
+		t.Fatal(err)
+	}
+	close(d.release)
+
+	select {
+	case titleId := <-d.done:
+		t.Fatalf("title %d was ripped after it was cancelled", titleId)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := wfm.GetWorkflow("d1", 0); got.Status != model.StatusCancelled {
+		t.Fatalf("expected status %s, got %s", model.StatusCancelled, got.Status)
+	}
+}
+
+func TestWorkflowManager_AutoEject(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	discdb := &mockDiscDB{data: map[string]*makemkv.DiscInfo{
+		"d1": {Titles: []makemkv.TitleInfo{
+			{Id: 0, FileName: "title_t00.mkv"},
+			{Id: 1, FileName: "title_t01.mkv"},
+		}},
+	}}
+	wfm, err := NewSqliteWorkflowManager(db, discdb, nil, Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first rip blocks on done until both are queued
+	d := &ejectingDrive{&recordingDrive{disc: &drive.Disc{Id: "d1"}, done: make(chan int)}, make(chan int, 2)}
+	for _, titleId := range []int{0, 1} {
+		wf, _ := wfm.NewWorkflow("d1", titleId, "LABEL", "movie")
+		if err := wfm.Enqueue(d, wf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForRips(t, d.recordingDrive, 2)
+
+	select {
+	case ripped := <-d.ejects:
+		if ripped != 2 {
+			t.Fatalf("ejected after %d rips, expected after the last of 2", ripped)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the drive to be ejected")
+	}
+	select {
+	case ripped := <-d.ejects:
+		t.Fatalf("ejected again after %d rips, expected only once", ripped)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -4,20 +4,26 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
-	"net/url"
 
+	"github.com/aravance/go-makemkv"
 	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/event"
+	"github.com/aravance/mkv-ripper/ingest"
 	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
 )
 
 func NewSqliteWorkflowManager(
 	db *sql.DB,
-	driveman drive.DriveManager,
 	discdb drive.DiscDatabase,
-	targets []*url.URL,
+	targets []ingest.Target,
+	backups Backups,
+	profiles drive.Profiles,
+	encoders []Encoder,
 	outdir string,
-	useMovieDir bool,
 	shafile string,
+	autoEject bool,
+	events *event.Hub,
 ) (WorkflowManager, error) {
 	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS workflows (
 		disc_id TEXT NOT NULL,
@@ -29,15 +35,36 @@ func NewSqliteWorkflowManager(
 		name TEXT,
 		year TEXT,
 		file_json TEXT,
+		season INTEGER,
+		episode INTEGER,
+		edition TEXT,
+		tmdb_id TEXT,
+		confidence REAL,
 		PRIMARY KEY(disc_id, title_id)
 	)`)
 	if err != nil {
 		return nil, err
 	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ingest_targets (
+		disc_id TEXT NOT NULL,
+		title_id INTEGER NOT NULL,
+		target TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT,
+		PRIMARY KEY(disc_id, title_id, target)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range []string{"season INTEGER", "episode INTEGER", "edition TEXT", "tmdb_id TEXT", "confidence REAL", "profile TEXT", "variants_json TEXT"} {
+		if err := util.AddColumn(db, "workflows", col); err != nil {
+			return nil, err
+		}
+	}
 
 	workflows := make(map[string]map[int]*model.Workflow)
 
-	rows, err := db.Query("SELECT disc_id, title_id, label, original_name, status, imdb_id, name, year, file_json FROM workflows")
+	rows, err := db.Query("SELECT disc_id, title_id, label, original_name, status, imdb_id, name, year, file_json, season, episode, edition, tmdb_id, confidence, profile, variants_json FROM workflows")
 	if err != nil {
 		return nil, err
 	}
@@ -46,9 +73,11 @@ func NewSqliteWorkflowManager(
 	for rows.Next() {
 		var discId, label, originalName, status string
 		var titleId int
-		var imdbId, name, year, fileJson sql.NullString
+		var imdbId, tmdbId, name, year, fileJson, edition, profile, variantsJson sql.NullString
+		var season, episode sql.NullInt64
+		var confidence sql.NullFloat64
 
-		if err := rows.Scan(&discId, &titleId, &label, &originalName, &status, &imdbId, &name, &year, &fileJson); err != nil {
+		if err := rows.Scan(&discId, &titleId, &label, &originalName, &status, &imdbId, &name, &year, &fileJson, &season, &episode, &edition, &tmdbId, &confidence, &profile, &variantsJson); err != nil {
 			log.Println("error scanning workflow row:", err)
 			continue
 		}
@@ -59,16 +88,31 @@ func NewSqliteWorkflowManager(
 			Label:        label,
 			OriginalName: originalName,
 			Status:       model.WorkflowStatus(status),
+			Profile:      profile.String,
 		}
 		if imdbId.Valid {
 			wf.ImdbId = &imdbId.String
 		}
+		if tmdbId.Valid {
+			wf.TmdbId = &tmdbId.String
+		}
+		if confidence.Valid {
+			wf.Confidence = &confidence.Float64
+		}
 		if name.Valid {
 			wf.Name = &name.String
 		}
 		if year.Valid {
 			wf.Year = &year.String
 		}
+		if edition.Valid {
+			wf.Edition = &edition.String
+		}
+		if season.Valid && episode.Valid {
+			s, e := int(season.Int64), int(episode.Int64)
+			wf.Season = &s
+			wf.Episode = &e
+		}
 		if fileJson.Valid {
 			var f model.MkvFile
 			if err := json.Unmarshal([]byte(fileJson.String), &f); err != nil {
@@ -77,27 +121,71 @@ func NewSqliteWorkflowManager(
 				wf.File = &f
 			}
 		}
+		if variantsJson.Valid {
+			if err := json.Unmarshal([]byte(variantsJson.String), &wf.Variants); err != nil {
+				log.Println("error unmarshaling variants_json:", err)
+			}
+		}
 
 		titleWfs := getOrCreate(workflows, discId)
 		titleWfs[titleId] = wf
 	}
+	rows.Close()
+
+	if err := loadTargetStatuses(db, workflows); err != nil {
+		return nil, err
+	}
 
 	persistFn := func(m *workflowManager, w *model.Workflow) error {
 		return sqlitePersist(db, w)
 	}
 
 	return &workflowManager{
-		workflows:   workflows,
-		driveman:    driveman,
-		discdb:      discdb,
-		targets:     targets,
-		outdir:      outdir,
-		useMovieDir: useMovieDir,
-		shafile:     shafile,
-		persistFn:   persistFn,
+		workflows: workflows,
+		jobs:      make(map[string]job),
+		queues:    make(map[string]*ripQueue),
+		discdb:    discdb,
+		targets:   targets,
+		backups:   backups,
+		profiles:  profiles,
+		encoders:  encoders,
+		outdir:    outdir,
+		shafile:   shafile,
+		autoEject: autoEject,
+		events:    events,
+		progress:  make(map[string]makemkv.Status),
+		persistFn: persistFn,
 	}, nil
 }
 
+func loadTargetStatuses(db *sql.DB, workflows map[string]map[int]*model.Workflow) error {
+	rows, err := db.Query("SELECT disc_id, title_id, target, status, error FROM ingest_targets ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var discId, target, status string
+		var titleId int
+		var errText sql.NullString
+		if err := rows.Scan(&discId, &titleId, &target, &status, &errText); err != nil {
+			log.Println("error scanning ingest_targets row:", err)
+			continue
+		}
+		wf, ok := workflows[discId][titleId]
+		if !ok {
+			continue
+		}
+		wf.Targets = append(wf.Targets, &model.TargetStatus{
+			Target: target,
+			Status: model.IngestStatus(status),
+			Error:  errText.String,
+		})
+	}
+	return nil
+}
+
 func sqlitePersist(db *sql.DB, w *model.Workflow) error {
 	var fileJson *string
 	if w.File != nil {
@@ -108,10 +196,19 @@ func sqlitePersist(db *sql.DB, w *model.Workflow) error {
 		s := string(b)
 		fileJson = &s
 	}
+	var variantsJson *string
+	if len(w.Variants) > 0 {
+		b, err := json.Marshal(w.Variants)
+		if err != nil {
+			return err
+		}
+		s := string(b)
+		variantsJson = &s
+	}
 
 	_, err := db.Exec(
-		`INSERT INTO workflows (disc_id, title_id, label, original_name, status, imdb_id, name, year, file_json)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`INSERT INTO workflows (disc_id, title_id, label, original_name, status, imdb_id, name, year, file_json, season, episode, edition, tmdb_id, confidence, profile, variants_json)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(disc_id, title_id) DO UPDATE SET
 			label = excluded.label,
 			original_name = excluded.original_name,
@@ -119,9 +216,46 @@ func sqlitePersist(db *sql.DB, w *model.Workflow) error {
 			imdb_id = excluded.imdb_id,
 			name = excluded.name,
 			year = excluded.year,
-			file_json = excluded.file_json`,
+			file_json = excluded.file_json,
+			season = excluded.season,
+			episode = excluded.episode,
+			edition = excluded.edition,
+			tmdb_id = excluded.tmdb_id,
+			confidence = excluded.confidence,
+			profile = excluded.profile,
+			variants_json = excluded.variants_json`,
 		w.DiscId, w.TitleId, w.Label, w.OriginalName, string(w.Status),
-		w.ImdbId, w.Name, w.Year, fileJson,
+		w.ImdbId, w.Name, w.Year, fileJson, w.Season, w.Episode, w.Edition, w.TmdbId, w.Confidence, w.Profile, variantsJson,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return persistTargetStatuses(db, w)
+}
+
+func persistTargetStatuses(db *sql.DB, w *model.Workflow) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM ingest_targets WHERE disc_id = ? AND title_id = ?", w.DiscId, w.TitleId)
+	if err != nil {
+		return err
+	}
+	for _, ts := range w.Targets {
+		var errText *string
+		if ts.Error != "" {
+			errText = &ts.Error
+		}
+		_, err = tx.Exec(
+			"INSERT INTO ingest_targets (disc_id, title_id, target, status, error) VALUES (?, ?, ?, ?, ?)",
+			w.DiscId, w.TitleId, ts.Target, string(ts.Status), errText,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
@@ -12,20 +12,6 @@ import (
 	_ "modernc.org/sqlite"
 )
 
-// Mock DriveManager
-type mockDriveManager struct{}
-
-func (m *mockDriveManager) Eject() error                        { return nil }
-func (m *mockDriveManager) GetDiscInfo() (*makemkv.DiscInfo, error) { return nil, nil }
-func (m *mockDriveManager) GetDisc() *drive.Disc                { return nil }
-func (m *mockDriveManager) HasDisc() bool                       { return false }
-func (m *mockDriveManager) Start() error                        { return nil }
-func (m *mockDriveManager) Stop() error                         { return nil }
-func (m *mockDriveManager) Status() drive.DriveStatus            { return drive.StatusEmpty }
-func (m *mockDriveManager) RipFile(_ *makemkv.TitleInfo, _ string, _ chan makemkv.Status) (*model.MkvFile, error) {
-	return nil, nil
-}
-
 // Mock DiscDatabase
 type mockDiscDB struct {
 	data map[string]*makemkv.DiscInfo
@@ -35,10 +21,17 @@ func (d *mockDiscDB) GetDiscInfo(id string) (*makemkv.DiscInfo, bool) {
 	info, ok := d.data[id]
 	return info, ok
 }
-func (d *mockDiscDB) SaveDiscInfo(id string, info *makemkv.DiscInfo) error {
-	d.data[id] = info
+func (d *mockDiscDB) SaveDiscInfo(disc *drive.Disc, info *makemkv.DiscInfo) error {
+	d.data[disc.Id] = info
 	return nil
 }
+func (d *mockDiscDB) GetDisc(id string) (drive.Disc, bool) {
+	_, ok := d.data[id]
+	return drive.Disc{Id: id, Minlength: drive.DefaultMinlength}, ok
+}
+func (d *mockDiscDB) FindDisc(uuid string, label string) (drive.Disc, bool) {
+	return drive.Disc{}, false
+}
 
 func newTestManager(t *testing.T) (WorkflowManager, *sql.DB) {
 	t.Helper()
@@ -48,7 +41,7 @@ func newTestManager(t *testing.T) (WorkflowManager, *sql.DB) {
 	}
 	t.Cleanup(func() { db.Close() })
 
-	wfm, err := NewSqliteWorkflowManager(db, &mockDriveManager{}, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, t.TempDir(), false, "movies.sha256")
+	wfm, err := NewSqliteWorkflowManager(db, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", false, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -60,8 +53,10 @@ func strPtr(s string) *string { return &s }
 func TestSqliteWorkflowManager_SaveAndGet(t *testing.T) {
 	wfm, _ := newTestManager(t)
 
+	confidence := 0.42
 	wf := &model.Workflow{
 		DiscId: "disc-1", TitleId: 0, Label: "MOVIE", OriginalName: "movie.mkv", Status: model.StatusStart,
+		TmdbId: strPtr("603"), Confidence: &confidence,
 	}
 	if err := wfm.Save(wf); err != nil {
 		t.Fatal(err)
@@ -71,7 +66,7 @@ func TestSqliteWorkflowManager_SaveAndGet(t *testing.T) {
 	if got == nil {
 		t.Fatal("expected workflow")
 	}
-	if !cmp.Equal(got, wf, cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "MkvStatus" }, cmp.Ignore())) {
+	if !cmp.Equal(got, wf) {
 		t.Fatalf("mismatch: %s", cmp.Diff(wf, got))
 	}
 }
@@ -161,7 +156,7 @@ func TestSqliteWorkflowManager_FileRoundTripPersistence(t *testing.T) {
 
 	// Save with file
 	db1, _ := sql.Open("sqlite", dbPath)
-	wfm1, _ := NewSqliteWorkflowManager(db1, &mockDriveManager{}, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, tmpDir, false, "movies.sha256")
+	wfm1, _ := NewSqliteWorkflowManager(db1, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, tmpDir, "movies.sha256", false, nil)
 	file := &model.MkvFile{Filename: "/tmp/m.mkv", Shasum: "sha", Resolution: "4k"}
 	wfm1.Save(&model.Workflow{DiscId: "d1", TitleId: 0, Label: "L", OriginalName: "a", Status: model.StatusDone, File: file})
 	db1.Close()
@@ -169,7 +164,7 @@ func TestSqliteWorkflowManager_FileRoundTripPersistence(t *testing.T) {
 	// Reopen
 	db2, _ := sql.Open("sqlite", dbPath)
 	defer db2.Close()
-	wfm2, _ := NewSqliteWorkflowManager(db2, &mockDriveManager{}, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, tmpDir, false, "movies.sha256")
+	wfm2, _ := NewSqliteWorkflowManager(db2, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, tmpDir, "movies.sha256", false, nil)
 	got := wfm2.GetWorkflow("d1", 0)
 	if got == nil || got.File == nil {
 		t.Fatal("expected file after reopen")
@@ -211,3 +206,98 @@ func TestSqliteWorkflowManager_Clean(t *testing.T) {
 func writeFile(path string) error {
 	return os.WriteFile(path, []byte("data"), 0644)
 }
+
+func TestSqliteWorkflowManager_MigratesOldTable(t *testing.T) {
+	db, err := sql.Open("sqlite", t.TempDir()+"/old.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE workflows (
+		disc_id TEXT NOT NULL,
+		title_id INTEGER NOT NULL,
+		label TEXT,
+		original_name TEXT,
+		status TEXT,
+		imdb_id TEXT,
+		name TEXT,
+		year TEXT,
+		file_json TEXT,
+		PRIMARY KEY(disc_id, title_id)
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO workflows (disc_id, title_id, label, original_name, status) VALUES ('d1', 0, 'L', 'a', 'Done')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wfm, err := NewSqliteWorkflowManager(db, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, t.TempDir(), "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wfm.GetWorkflow("d1", 0); got == nil || got.Status != model.StatusDone {
+		t.Fatalf("expected existing workflow to load, got %+v", got)
+	}
+
+	season, episode := 1, 3
+	wf := &model.Workflow{DiscId: "d2", TitleId: 0, Label: "L", OriginalName: "b", Status: model.StatusStart, Season: &season, Episode: &episode}
+	if err := wfm.Save(wf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSqliteWorkflowManager_EpisodeRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := tmpDir + "/test.db"
+
+	db1, _ := sql.Open("sqlite", dbPath)
+	wfm1, _ := NewSqliteWorkflowManager(db1, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, tmpDir, "movies.sha256", false, nil)
+	season, episode := 2, 7
+	wfm1.Save(&model.Workflow{DiscId: "d1", TitleId: 0, Label: "L", OriginalName: "a", Status: model.StatusStart, Season: &season, Episode: &episode})
+	db1.Close()
+
+	db2, _ := sql.Open("sqlite", dbPath)
+	defer db2.Close()
+	wfm2, _ := NewSqliteWorkflowManager(db2, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, tmpDir, "movies.sha256", false, nil)
+	got := wfm2.GetWorkflow("d1", 0)
+	if got == nil || !got.IsEpisode() || *got.Season != season || *got.Episode != episode {
+		t.Fatalf("expected S02E07 after reopen, got %+v", got)
+	}
+}
+
+func TestSqliteWorkflowManager_TargetStatusRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := tmpDir + "/test.db"
+
+	db1, _ := sql.Open("sqlite", dbPath)
+	wfm1, _ := NewSqliteWorkflowManager(db1, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, tmpDir, "movies.sha256", false, nil)
+	wf := &model.Workflow{DiscId: "d1", TitleId: 0, Label: "L", OriginalName: "a", Status: model.StatusError}
+	wf.TargetStatus("/mnt/a").Status = model.IngestVerified
+	failed := wf.TargetStatus("ssh://nas/movies")
+	failed.Status = model.IngestFailed
+	failed.Error = "connection refused"
+	wfm1.Save(wf)
+	db1.Close()
+
+	db2, _ := sql.Open("sqlite", dbPath)
+	defer db2.Close()
+	wfm2, _ := NewSqliteWorkflowManager(db2, &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}, nil, Backups{}, drive.Profiles{}, nil, tmpDir, "movies.sha256", false, nil)
+	got := wfm2.GetWorkflow("d1", 0)
+	if got == nil {
+		t.Fatal("expected workflow after reopen")
+	}
+	if !cmp.Equal(got.Targets, wf.Targets) {
+		t.Fatalf("mismatch: %s", cmp.Diff(wf.Targets, got.Targets))
+	}
+
+	got.Targets = nil
+	wfm2.Save(got)
+	var count int
+	db2.QueryRow("SELECT COUNT(*) FROM ingest_targets").Scan(&count)
+	if count != 0 {
+		t.Fatalf("expected target statuses to be removed, got %d", count)
+	}
+}
@@ -0,0 +1,229 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/ingest"
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/aravance/mkv-ripper/util"
+)
+
+// Encoder transcodes ripped files into a variant that is ingested to its own
+// Targets. Command is the encoder and its arguments, where {input} and
+// {output} are replaced by the paths of the ripped and transcoded files. Ext
+// is the extension of the transcoded file, and Resolution replaces the one of
+// the ripped file if set.
+type Encoder struct {
+	Name       string
+	Command    []string
+	Ext        string
+	Resolution string
+	Targets    []ingest.Target
+}
+
+// progressMax is the Max of the progress of a transcode or copy, so
+// percentages keep two decimals.
+const progressMax = 10000
+
+var percentRegexp = regexp.MustCompile(`(\d+(?:\.\d+)?) ?%`)
+var timeRegexp = regexp.MustCompile(`(?:^|\s)time=(\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+var outTimeRegexp = regexp.MustCompile(`^out_time_us=(\d+)`)
+
+// encoderProgress reads how far along an encoder is, from 0 to 1, from a line
+// of its output. HandBrakeCLI prints a percentage, ffmpeg the time it has
+// encoded up to, which needs the duration of the title.
+func encoderProgress(line string, duration time.Duration) (float64, bool) {
+	if m := percentRegexp.FindStringSubmatch(line); m != nil {
+		pct, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return 0, false
+		}
+		return min(pct/100, 1), true
+	}
+	if duration <= 0 {
+		return 0, false
+	}
+
+	var done time.Duration
+	if m := outTimeRegexp.FindStringSubmatch(line); m != nil {
+		us, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		done = time.Duration(us) * time.Microsecond
+	} else if m := timeRegexp.FindStringSubmatch(line); m != nil {
+		h, _ := strconv.Atoi(m[1])
+		mins, _ := strconv.Atoi(m[2])
+		secs, _ := strconv.ParseFloat(m[3], 64)
+		done = time.Duration(h)*time.Hour + time.Duration(mins)*time.Minute + time.Duration(secs*float64(time.Second))
+	} else {
+		return 0, false
+	}
+	return min(float64(done)/float64(duration), 1), true
+}
+
+// scanLines splits encoder output on carriage returns too, which encoders
+// use to redraw their progress.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// encoderArgs fills the file paths into the encoder command.
+func encoderArgs(command []string, input string, output string) []string {
+	r := strings.NewReplacer("{input}", input, "{output}", output)
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = r.Replace(arg)
+	}
+	return args
+}
+
+// runEncoder runs the encoder command and forwards its progress to statchan.
+// The last lines of its output are returned in the error if it fails.
+func runEncoder(ctx context.Context, args []string, duration time.Duration, title string, statchan chan makemkv.Status) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	var tail []string
+	scanner := bufio.NewScanner(pr)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		tail = append(tail, line)
+		if len(tail) > 5 {
+			tail = tail[1:]
+		}
+		if p, ok := encoderProgress(line, duration); ok && statchan != nil {
+			select {
+			case statchan <- makemkv.Status{Title: title, Current: int(p * progressMax), Total: int(p * progressMax), Max: progressMax}:
+			case <-ctx.Done():
+			}
+		}
+	}
+	// keep the encoder from blocking on a full pipe if scanning stopped early
+	io.Copy(io.Discard, pr)
+
+	if err := <-waitErr; err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%s: %w: %s", path.Base(args[0]), err, strings.Join(tail, "; "))
+	}
+	return nil
+}
+
+// transcode runs the encoder on the workflow's file, and returns the variant
+// file next to it.
+func (m *workflowManager) transcode(ctx context.Context, wf *model.Workflow, enc Encoder) (*model.MkvFile, error) {
+	input := wf.File.Filename
+	ext := enc.Ext
+	if ext == "" {
+		ext = path.Ext(input)
+	}
+	name := strings.TrimSuffix(path.Base(input), path.Ext(input)) + "." + enc.Name + ext
+	output := path.Join(path.Dir(input), name)
+	// the encoder writes to a temp file, so a half done transcode is never
+	// mistaken for a finished one
+	tmp := path.Join(path.Dir(input), "."+name)
+	defer os.Remove(tmp)
+
+	var duration time.Duration
+	if info, ok := m.discdb.GetDiscInfo(wf.DiscId); ok && wf.TitleId >= 0 && wf.TitleId < len(info.Titles) {
+		duration = info.Titles[wf.TitleId].Duration
+	}
+
+	statchan := make(chan makemkv.Status)
+	statdone := make(chan struct{})
+	go func() {
+		defer close(statdone)
+		for stat := range statchan {
+			m.setProgress(wf, &stat)
+		}
+	}()
+	defer func() {
+		close(statchan)
+		<-statdone
+		m.setProgress(wf, nil)
+	}()
+
+	log.Println("transcoding", input, "with", enc.Name)
+	if err := runEncoder(ctx, encoderArgs(enc.Command, input, tmp), duration, "Transcoding "+enc.Name, statchan); err != nil {
+		log.Println("error transcoding", input, "with", enc.Name, "err:", err)
+		return nil, err
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		return nil, err
+	}
+
+	log.Println("starting sha256sum for " + output)
+	shasum, err := util.Sha256sum(output)
+	if err != nil {
+		log.Println("error in sha256sum for " + output)
+		return nil, err
+	}
+
+	resolution := wf.File.Resolution
+	if enc.Resolution != "" {
+		resolution = enc.Resolution
+	}
+	return &model.MkvFile{
+		Filename:   output,
+		Shasum:     shasum,
+		Resolution: resolution,
+		Variant:    enc.Name,
+	}, nil
+}
+
+// transcodeAll makes the variants of every encoder that haven't been made yet.
+func (m *workflowManager) transcodeAll(ctx context.Context, wf *model.Workflow) error {
+	for _, enc := range m.encoders {
+		v := wf.Variant(enc.Name)
+		if v.File != nil {
+			continue
+		}
+		wf.Status = model.StatusTranscoding
+		m.Save(wf)
+
+		f, err := m.transcode(ctx, wf, enc)
+		if err != nil {
+			return err
+		}
+		v.File = f
+		v.Targets = nil
+		m.Save(wf)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aravance/go-makemkv"
+	"github.com/aravance/mkv-ripper/drive"
+	"github.com/aravance/mkv-ripper/ingest"
+	"github.com/aravance/mkv-ripper/model"
+	"github.com/google/go-cmp/cmp"
+	_ "modernc.org/sqlite"
+)
+
+func TestEncoderProgress(t *testing.T) {
+	duration := 2 * time.Hour
+	for line, expected := range map[string]float64{
+		"Encoding: task 1 of 1, 25.00 % (80.12 fps, avg 81.50 fps, ETA 00h10m12s)":          0.25,
+		"frame= 1200 fps= 48 q=28.0 size=   10240kB time=00:30:00.00 bitrate=1000.0kbits/s": 0.25,
+		"out_time_us=5400000000": 0.75,
+		"Encoding: 100.00 %":     1,
+	} {
+		p, ok := encoderProgress(line, duration)
+		if !ok || p != expected {
+			t.Fatalf("encoderProgress(%q) = %v, %v, expected: %v", line, p, ok, expected)
+		}
+	}
+
+	for _, line := range []string{"", "Stream #0:0: Video: h264", "time=00:30:00.00"} {
+		if p, ok := encoderProgress(line, 0); ok {
+			t.Fatalf("encoderProgress(%q) = %v, expected no progress", line, p)
+		}
+	}
+}
+
+func TestEncoderArgs(t *testing.T) {
+	args := encoderArgs([]string{"ffmpeg", "-i", "{input}", "-c:v", "libx265", "{output}"}, "/rip/a.mkv", "/rip/.a.x265.mkv")
+	expected := []string{"ffmpeg", "-i", "/rip/a.mkv", "-c:v", "libx265", "/rip/.a.x265.mkv"}
+	if !cmp.Equal(args, expected) {
+		t.Fatalf("encoderArgs() = %v, expected: %v", args, expected)
+	}
+}
+
+func TestRunEncoder_Error(t *testing.T) {
+	err := runEncoder(context.Background(), []string{"sh", "-c", "echo bad input >&2; exit 1"}, 0, "", nil)
+	if err == nil || err.Error() != "sh: exit status 1: bad input" {
+		t.Fatalf("runEncoder() error = %v, expected the encoder output", err)
+	}
+}
+
+func TestWorkflowManager_IngestTranscodes(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	outdir := t.TempDir()
+	movies := t.TempDir()
+	small := t.TempDir()
+	naming, err := ingest.NewNaming(ingest.DefaultVariantMovieTemplate, ingest.DefaultVariantEpisodeTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a bad variant target keeps the files around to check the variant
+	bad := path.Join(t.TempDir(), "missing")
+	if err := os.WriteFile(bad, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	encoders := []Encoder{{
+		Name:       "720p",
+		Command:    []string{"sh", "-c", `echo "Encoding: 50.00 %"; tr a-z A-Z < "$0" > "$1"`, "{input}", "{output}"},
+		Resolution: "720p",
+		Targets: []ingest.Target{
+			{Url: &url.URL{Path: small}, Naming: naming},
+			{Url: &url.URL{Path: bad}, Naming: naming},
+		},
+	}}
+	targets := []ingest.Target{{Url: &url.URL{Path: movies}}}
+	discdb := &mockDiscDB{data: map[string]*makemkv.DiscInfo{}}
+
+	wfm, err := NewSqliteWorkflowManager(db, discdb, targets, Backups{}, drive.Profiles{}, encoders, outdir, "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ripped := path.Join(outdir, "d1", "title_t00.mkv")
+	if err := os.MkdirAll(path.Dir(ripped), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ripped, []byte("foobar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wf, _ := wfm.NewWorkflow("d1", 0, "LABEL", "movie")
+	wf.Name = strPtr("bar")
+	wf.Year = strPtr("1989")
+	wf.File = &model.MkvFile{
+		Filename:   ripped,
+		Shasum:     "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2",
+		Resolution: "1080p",
+	}
+	wf.Status = model.StatusPending
+	wfm.Save(wf)
+
+	if err := wfm.Ingest(wf); err == nil {
+		t.Fatal("expected error ingesting to a bad target")
+	}
+
+	// the variant is kept across restarts
+	wfm, err = NewSqliteWorkflowManager(db, discdb, targets, Backups{}, drive.Profiles{}, encoders, outdir, "movies.sha256", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf = wfm.GetWorkflow("d1", 0)
+	if len(wf.Variants) != 1 || wf.Variants[0].File == nil {
+		t.Fatalf("wf.Variants = %+v, expected a 720p file", wf.Variants)
+	}
+	expected := model.MkvFile{
+		Filename:   path.Join(outdir, "d1", "title_t00.720p.mkv"),
+		Shasum:     "24c422e681f1c1bd08286c7aaf5d23a5f088dcdb0b219806b3a9e579244f00c5",
+		Resolution: "720p",
+		Variant:    "720p",
+	}
+	if !cmp.Equal(*wf.Variants[0].File, expected) {
+		t.Fatalf("variant file = %+v, expected: %+v", *wf.Variants[0].File, expected)
+	}
+	if ts := wf.Variants[0].TargetStatus(small); ts.Status != model.IngestVerified {
+		t.Fatalf("expected variant target %s, got %s", model.IngestVerified, ts.Status)
+	}
+	if ts := wf.TargetStatus(movies); ts.Status != model.IngestVerified {
+		t.Fatalf("expected target %s, got %s", model.IngestVerified, ts.Status)
+	}
+
+	b, err := os.ReadFile(path.Join(small, "bar (1989) - 720p.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "FOOBAR" {
+		t.Fatalf("ingested variant = %q, expected: FOOBAR", b)
+	}
+	if _, err := os.Stat(path.Join(movies, "bar (1989) [1080p].mkv")); err != nil {
+		t.Fatalf("ripped file was not ingested: %v", err)
+	}
+}